@@ -0,0 +1,160 @@
+package testutils
+
+import (
+	"reflect"
+	"regexp"
+	"time"
+
+	"github.com/go-pg/pg/v9"
+)
+
+// Call is a record of a method called on a MockDB or MockTx
+type Call struct {
+	// Method is the name of the method called, for example "QueryOne"
+	Method string
+
+	// Query is the text of the query for Query, QueryOne and Exec calls, and
+	// of the statement for Savepoint, RollbackTo and Release calls
+	Query string
+
+	// Params are the params passed to Query, QueryOne and Exec calls
+	Params []interface{}
+
+	// ModelType is the type of the model passed to the method, or nil for
+	// Commit and Rollback. For Insert calls, it is the type of the first model.
+	ModelType reflect.Type
+
+	// TxID is the ID of the transaction the call was made in, or 0 if it was
+	// made directly on the MockDB
+	TxID int
+
+	// Result is the result of the method. Query, QueryOne and Exec return it, and
+	// for Insert, Update, Delete and ForceDelete it holds the number of rows
+	// affected.
+	Result pg.Result
+
+	// Err is the error returned by the method
+	Err error
+
+	// Time is the time of the call according to the MockDB's Clock
+	Time time.Time
+}
+
+// Calls is a list of calls made against a MockDB, with methods to filter it
+// for assertions
+type Calls []Call
+
+// Method returns the calls to any of the named methods
+func (c Calls) Method(names ...string) Calls {
+	return c.filter(func(call Call) bool {
+		for _, name := range names {
+			if call.Method == name {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// Matching returns the calls whose query matches the regular expression
+// pattern. It panics if the pattern is not a valid regexp.
+func (c Calls) Matching(pattern string) Calls {
+	re := regexp.MustCompile(pattern)
+	return c.filter(func(call Call) bool {
+		return call.Query != "" && re.MatchString(call.Query)
+	})
+}
+
+// WithParams returns the calls made with params equal to the params passed
+func (c Calls) WithParams(params ...interface{}) Calls {
+	return c.filter(func(call Call) bool {
+		if len(call.Params) != len(params) {
+			return false
+		}
+		for i, p := range params {
+			if !valuesEqual(p, call.Params[i]) {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+// ForModel returns the calls made with a model of the same type as model
+func (c Calls) ForModel(model interface{}) Calls {
+	t := reflect.TypeOf(model)
+	return c.filter(func(call Call) bool {
+		return call.ModelType == t
+	})
+}
+
+// InTx returns the calls made in the transaction with the given ID. An ID of 0
+// returns the calls made outside of any transaction.
+func (c Calls) InTx(id int) Calls {
+	return c.filter(func(call Call) bool {
+		return call.TxID == id
+	})
+}
+
+// Between returns the calls made from the start time up to but not including
+// the end time
+func (c Calls) Between(start, end time.Time) Calls {
+	return c.filter(func(call Call) bool {
+		return !call.Time.Before(start) && call.Time.Before(end)
+	})
+}
+
+// Failed returns the calls which returned an error
+func (c Calls) Failed() Calls {
+	return c.filter(func(call Call) bool {
+		return call.Err != nil
+	})
+}
+
+// filter returns the calls for which keep returns true
+func (c Calls) filter(keep func(Call) bool) Calls {
+	var filtered Calls
+	for _, call := range c {
+		if keep(call) {
+			filtered = append(filtered, call)
+		}
+	}
+	return filtered
+}
+
+// Calls returns every call made against the MockDB and its transactions, in the
+// order they were made
+func (db *MockDB) Calls() Calls {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	calls := make(Calls, len(db.calls))
+	copy(calls, db.calls)
+	return calls
+}
+
+// record adds a call to the call log
+func (db *MockDB) record(txID int, method string, model, query interface{}, params []interface{}, res pg.Result, err error) {
+	call := Call{
+		Method: method,
+		Params: params,
+		TxID:   txID,
+		Result: res,
+		Err:    err,
+		Time:   db.now(),
+	}
+	if query != nil {
+		call.Query = querySQL(query)
+	}
+	if model != nil {
+		call.ModelType = reflect.TypeOf(model)
+	}
+	db.calls = append(db.calls, call)
+}
+
+// firstModel returns the first of the models passed to Insert, or nil
+func firstModel(models []interface{}) interface{} {
+	if len(models) == 0 {
+		return nil
+	}
+	return models[0]
+}
@@ -0,0 +1,100 @@
+package testutils
+
+import (
+	"testing"
+)
+
+func TestCalls(t *testing.T) {
+	t.Run("Records MockDB calls", func(t *testing.T) {
+		tm := &TestModel{ID: 1, Name: "Test Model"}
+		db := NewMockDB()
+		db.QueueResponses(*tm)
+
+		if _, err := db.QueryOne(&TestModel{}, "SELECT * FROM test_models WHERE id = ?", 1); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Insert(tm); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Delete(&TestModel{ID: 2}); err == nil {
+			t.Fatal("expected an error deleting a missing model")
+		}
+
+		calls := db.Calls()
+		if len(calls) != 3 {
+			t.Fatal("expected 3 calls; found ", len(calls))
+		}
+		q := calls[0]
+		if q.Method != "QueryOne" || q.Query != "SELECT * FROM test_models WHERE id = ?" || q.TxID != 0 {
+			t.Fatalf("unexpected call recorded: %+v", q)
+		}
+		if len(q.Params) != 1 || q.Params[0] != 1 {
+			t.Fatalf("expected params [1]; found %v", q.Params)
+		}
+		if calls[1].Method != "Insert" || calls[1].ModelType.String() != "*testutils.TestModel" {
+			t.Fatalf("unexpected call recorded: %+v", calls[1])
+		}
+		if calls[2].Method != "Delete" || calls[2].Err == nil {
+			t.Fatalf("expected failed Delete call; found %+v", calls[2])
+		}
+	})
+
+	t.Run("Records MockTx calls", func(t *testing.T) {
+		tm := &TestModel{ID: 1, Name: "Test Model"}
+		db := NewMockDB()
+
+		var txID int
+		err := db.RunInTransaction(func(tx Tx) error {
+			txID = tx.(*MockTx).ID()
+			if _, err := tx.Query(&TestModel{}, "SELECT 1"); err != nil {
+				return err
+			}
+			return tx.Insert(tm)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Insert(&TestModel{ID: 2}); err != nil {
+			t.Fatal(err)
+		}
+
+		txCalls := db.Calls().InTx(txID)
+		if len(txCalls) != 3 {
+			t.Fatal("expected 3 calls in transaction; found ", len(txCalls))
+		}
+		methods := []string{"Query", "Insert", "Commit"}
+		for i, m := range methods {
+			if txCalls[i].Method != m {
+				t.Fatalf("expected call %d to be %s; found %s", i, m, txCalls[i].Method)
+			}
+		}
+		if len(db.Calls().InTx(0)) != 1 {
+			t.Fatal("expected 1 call outside of a transaction")
+		}
+	})
+
+	t.Run("Filters", func(t *testing.T) {
+		db := NewMockDB()
+		_, _ = db.Query(&TestModel{}, "SELECT * FROM test_models WHERE id = ?", 1)
+		_, _ = db.Query(&TestModel{}, "SELECT * FROM test_models WHERE id = ?", 2)
+		_, _ = db.Query(&struct{}{}, "SELECT * FROM other_models")
+		_ = db.Update(&TestModel{ID: 3})
+
+		calls := db.Calls()
+		if n := len(calls.Method("Query")); n != 3 {
+			t.Fatal("expected 3 Query calls; found ", n)
+		}
+		if n := len(calls.Matching(`FROM test_models`)); n != 2 {
+			t.Fatal("expected 2 calls matching test_models; found ", n)
+		}
+		if n := len(calls.Matching(`test_models`).WithParams(int64(2))); n != 1 {
+			t.Fatal("expected 1 call with params [2]; found ", n)
+		}
+		if n := len(calls.ForModel(&TestModel{})); n != 3 {
+			t.Fatal("expected 3 calls for TestModel; found ", n)
+		}
+		if n := len(calls.Failed()); n != 1 {
+			t.Fatal("expected 1 failed call; found ", n)
+		}
+	})
+}
@@ -0,0 +1,69 @@
+package testutils
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock tells MockDB the current time, for example for timestamp columns
+type Clock interface {
+	Now() time.Time
+}
+
+// wallClock is the default Clock, which uses the system time
+type wallClock struct{}
+
+// Now returns the system time
+func (wallClock) Now() time.Time {
+	return time.Now()
+}
+
+// FakeClock is a Clock whose time only changes when a test sets or advances it,
+// so timestamps set by MockDB are deterministic. It is safe for concurrent use.
+type FakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+// NewFakeClock creates a FakeClock set to t
+func NewFakeClock(t time.Time) *FakeClock {
+	return &FakeClock{now: t}
+}
+
+// Now returns the clock's current time
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Advance moves the clock's time forward by d, or back if d is negative
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// Set sets the clock's time to t
+func (c *FakeClock) Set(t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = t
+}
+
+// SetClock sets the Clock used by the MockDB and its transactions to set
+// default and timestamp columns and the time of calls. A nil Clock restores the
+// system time.
+func (db *MockDB) SetClock(clock Clock) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.clock = clock
+}
+
+// now returns the current time of the MockDB's Clock
+func (db *MockDB) now() time.Time {
+	if db.clock == nil {
+		return wallClock{}.Now()
+	}
+	return db.clock.Now()
+}
@@ -0,0 +1,46 @@
+package testutils
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFakeClock(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	t.Run("Advance and Set", func(t *testing.T) {
+		clock := NewFakeClock(start)
+		if !clock.Now().Equal(start) {
+			t.Fatalf("expected %v; found %v", start, clock.Now())
+		}
+		clock.Advance(90 * time.Minute)
+		if want := start.Add(90 * time.Minute); !clock.Now().Equal(want) {
+			t.Fatalf("expected %v; found %v", want, clock.Now())
+		}
+		clock.Set(start)
+		if !clock.Now().Equal(start) {
+			t.Fatalf("expected %v; found %v", start, clock.Now())
+		}
+	})
+
+	t.Run("Call times", func(t *testing.T) {
+		clock := NewFakeClock(start)
+		db := NewMockDB()
+		db.SetClock(clock)
+		if err := db.Insert(&Account{}); err != nil {
+			t.Fatal(err)
+		}
+		clock.Advance(time.Hour)
+		if err := db.Insert(&Account{}); err != nil {
+			t.Fatal(err)
+		}
+
+		calls := db.Calls()
+		if !calls[0].Time.Equal(start) || !calls[1].Time.Equal(start.Add(time.Hour)) {
+			t.Fatalf("unexpected call times %v and %v", calls[0].Time, calls[1].Time)
+		}
+		if n := len(calls.Between(start, start.Add(time.Minute))); n != 1 {
+			t.Fatalf("expected 1 call in the first minute; found %d", n)
+		}
+	})
+}
@@ -0,0 +1,118 @@
+package testutils
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// ConcurrentModel is a Model with a GetID which works for any ID, so many
+// goroutines can store distinct models
+type ConcurrentModel struct {
+	ID   int    `pg:"id" json:"id"`
+	Name string `pg:"name" json:"name"`
+}
+
+func (cm *ConcurrentModel) GetID() string {
+	return strconv.Itoa(cm.ID)
+}
+
+func (cm *ConcurrentModel) Equals(i interface{}) bool {
+	b, ok := i.(*ConcurrentModel)
+	return ok && cm.ID == b.ID && cm.Name == b.Name
+}
+
+// TestConcurrency runs MockDB and MockTx methods from many goroutines at once.
+// Run it with go test -race to detect unsynchronized access.
+func TestConcurrency(t *testing.T) {
+	const workers = 8
+	const iterations = 50
+
+	db := NewMockDB()
+	for i := 0; i < workers*iterations; i++ {
+		db.QueueResponses(ConcurrentModel{ID: i})
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, workers*4)
+	for w := 0; w < workers; w++ {
+		wg.Add(4)
+
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < iterations; i++ {
+				// Keys start at 1, as a zero key would take a generated one
+				m := &ConcurrentModel{ID: w*iterations + i + 1, Name: "inserted"}
+				if err := db.Insert(m); err != nil {
+					errs <- err
+					return
+				}
+				if err := db.Update(&ConcurrentModel{ID: m.ID, Name: "updated"}); err != nil {
+					errs <- err
+					return
+				}
+				if i%2 == 0 {
+					if err := db.Delete(&ConcurrentModel{ID: m.ID}); err != nil {
+						errs <- err
+						return
+					}
+				}
+			}
+		}(w)
+
+		go func() {
+			defer wg.Done()
+			for i := 0; i < iterations; i++ {
+				if _, err := db.Query(&ConcurrentModel{}, "SELECT * FROM concurrent_models"); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < iterations; i++ {
+				id := -(w*iterations + i + 1)
+				err := db.RunInTransaction(func(tx Tx) error {
+					if err := tx.Insert(&ConcurrentModel{ID: id}); err != nil {
+						return err
+					}
+					return tx.Select(&ConcurrentModel{ID: id})
+				})
+				if err != nil {
+					errs <- fmt.Errorf("transaction %d: %v", id, err)
+					return
+				}
+			}
+		}(w)
+
+		go func() {
+			defer wg.Done()
+			for i := 0; i < iterations; i++ {
+				_, _ = db.Find(&ConcurrentModel{ID: i})
+				_ = db.Calls()
+				_, _ = db.MarshalModels()
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	if n := len(db.Calls().Method("Query")); n != workers*iterations {
+		t.Fatalf("expected %d Query calls; found %d", workers*iterations, n)
+	}
+	if err := db.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+	// Half of the directly inserted models are deleted, and every model
+	// inserted in a transaction is committed
+	expected := workers*iterations/2 + workers*iterations
+	if n := len(db.models); n != expected {
+		t.Fatalf("expected %d models; found %d", expected, n)
+	}
+}
@@ -0,0 +1,174 @@
+package testutils
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// checkInserts returns an error like Postgres's if inserting the models passed
+// to Insert into models would violate their tables' constraints, including
+// between the inserted models
+func checkInserts(models, inserted []interface{}) error {
+	all := append([]interface{}(nil), models...)
+	for _, m := range inserted {
+		if err := checkInsert(all, m); err != nil {
+			return err
+		}
+		all = append(all, m)
+	}
+	return nil
+}
+
+// checkInsert returns an error like Postgres's if inserting model into models
+// would violate its table's primary key, unique or not null constraints
+func checkInsert(models []interface{}, model interface{}) error {
+	if err := checkNotNull(model, true); err != nil {
+		return err
+	}
+	tbl := getTable(reflect.TypeOf(model))
+	i, err := indexModel(models, model, true)
+	if err == nil && i >= 0 {
+		key, _ := KeyOf(model)
+		names := make([]string, len(tbl.pks))
+		for j, pk := range tbl.pks {
+			names[j] = pk.name
+		}
+		return uniqueViolation(tbl, tbl.name+"_pkey", names, key)
+	}
+	return checkUnique(models, model, -1)
+}
+
+// checkUpdate returns an error like Postgres's if updating the model in models
+// with the same primary key as model would violate its table's unique or not
+// null constraints. A missing model is left for updateModel to report.
+func checkUpdate(models []interface{}, model interface{}) error {
+	i, err := indexModel(models, model, false)
+	if err != nil || i < 0 {
+		return nil
+	}
+	// go-pg leaves the primary key out of an update's SET clause
+	if err := checkNotNull(model, false); err != nil {
+		return err
+	}
+	return checkUnique(models, model, i)
+}
+
+// checkNotNull returns a not null violation if a notnull column of model, or a
+// primary key column if pks is true, is null. Like go-pg, a zero value is sent
+// as null unless the column is tagged use_zero.
+func checkNotNull(model interface{}, pks bool) error {
+	v := reflect.Indirect(reflect.ValueOf(model))
+	if v.Kind() != reflect.Struct {
+		return nil
+	}
+	tbl := getTable(v.Type())
+	for _, c := range tbl.columns {
+		if !c.hasOption("notnull") && !(pks && isPK(tbl, c)) {
+			continue
+		}
+		if isNull(c, c.value(v)) {
+			return NotNullViolation(tbl.name, c.name)
+		}
+	}
+	return nil
+}
+
+// checkUnique returns a unique violation if the unique columns of model have
+// the same values as another model of the same type in models, other than the
+// one at index skip. Soft deleted models still count, as they do in Postgres.
+func checkUnique(models []interface{}, model interface{}, skip int) error {
+	v := reflect.Indirect(reflect.ValueOf(model))
+	if v.Kind() != reflect.Struct {
+		return nil
+	}
+	tbl := getTable(v.Type())
+	for _, group := range tbl.uniques {
+		values := make([]interface{}, len(group))
+		null := false
+		for j, c := range group {
+			f := c.value(v)
+			if isNull(c, f) {
+				null = true
+				break
+			}
+			values[j] = reflect.Indirect(f).Interface()
+		}
+		if null {
+			continue
+		}
+		for i, r := range models {
+			if i == skip || reflect.TypeOf(r) != reflect.TypeOf(model) {
+				continue
+			}
+			rv := reflect.Indirect(reflect.ValueOf(r))
+			if sameValues(group, rv, values) {
+				names := make([]string, len(group))
+				for j, c := range group {
+					names[j] = c.name
+				}
+				return uniqueViolation(tbl, tbl.name+"_"+strings.Join(names, "_")+"_key", names, values)
+			}
+		}
+	}
+	return nil
+}
+
+// sameValues returns whether the columns of v, a struct, hold the values
+func sameValues(columns []*column, v reflect.Value, values []interface{}) bool {
+	for j, c := range columns {
+		f := c.value(v)
+		if isNull(c, f) {
+			return false
+		}
+		a, b := reflect.Indirect(f).Interface(), values[j]
+		if ta, ok := a.(time.Time); ok {
+			if tb, ok := b.(time.Time); ok && ta.Equal(tb) {
+				continue
+			}
+			return false
+		}
+		if !valuesEqual(a, b) && normalizeKeyValue(a) != normalizeKeyValue(b) {
+			return false
+		}
+	}
+	return true
+}
+
+// isPK returns whether c is one of the table's primary key columns
+func isPK(tbl *table, c *column) bool {
+	for _, pk := range tbl.pks {
+		if pk == c {
+			return true
+		}
+	}
+	return false
+}
+
+// isNull returns whether go-pg would send the value f of column c as null
+func isNull(c *column, f reflect.Value) bool {
+	if !f.IsValid() {
+		return true
+	}
+	switch f.Kind() {
+	case reflect.Ptr, reflect.Interface, reflect.Map, reflect.Slice:
+		if f.IsNil() {
+			return true
+		}
+	}
+	return !c.hasOption("use_zero") && f.IsZero()
+}
+
+// uniqueViolation creates a unique violation with the detail Postgres gives
+// of the duplicate key
+func uniqueViolation(tbl *table, constraint string, columns []string, values []interface{}) error {
+	parts := make([]string, len(values))
+	for i, v := range values {
+		parts[i] = fmt.Sprint(normalizeKeyValue(v))
+	}
+	err := UniqueViolation(tbl.name, constraint).(*mockPGError)
+	err.fields['D'] = fmt.Sprintf("Key (%s)=(%s) already exists.",
+		strings.Join(columns, ", "), strings.Join(parts, ", "))
+	return err
+}
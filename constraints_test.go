@@ -0,0 +1,113 @@
+package testutils
+
+import (
+	"testing"
+
+	"github.com/go-pg/pg/v9"
+)
+
+type Member struct {
+	ID       int
+	Email    string `pg:",unique"`
+	Name     string `pg:",notnull"`
+	Admin    bool   `pg:",notnull,use_zero"`
+	TeamID   int    `pg:"unique:team_number"`
+	Number   int    `pg:"unique:team_number"`
+	Nickname *string
+}
+
+func TestConstraints(t *testing.T) {
+	t.Run("Primary key", func(t *testing.T) {
+		db := NewMockDB()
+		if err := db.Insert(&Member{ID: 1, Name: "a"}); err != nil {
+			t.Fatal(err)
+		}
+		err := db.Insert(&Member{ID: 1, Name: "b"})
+		if errCode(err) != CodeUniqueViolation {
+			t.Fatalf("expected a unique violation; found %v", err)
+		}
+		if c := err.(pg.Error).Field('n'); c != "members_pkey" {
+			t.Fatalf("expected constraint members_pkey; found %s", c)
+		}
+		if d := err.(pg.Error).Field('D'); d != "Key (id)=(1) already exists." {
+			t.Fatalf("unexpected detail %q", d)
+		}
+	})
+
+	t.Run("Unique", func(t *testing.T) {
+		db := NewMockDB()
+		if err := db.Insert(&Member{Name: "a", Email: "a@example.com"}, &Member{Name: "b"}, &Member{Name: "c"}); err != nil {
+			t.Fatalf("null unique values should not conflict: %v", err)
+		}
+		err := db.Insert(&Member{Name: "d", Email: "a@example.com"})
+		if errCode(err) != CodeUniqueViolation || err.(pg.Error).Field('n') != "members_email_key" {
+			t.Fatalf("expected a violation of members_email_key; found %v", err)
+		}
+
+		if err := db.Update(&Member{ID: 1, Name: "a", Email: "a@example.com"}); err != nil {
+			t.Fatalf("a model should not conflict with itself: %v", err)
+		}
+		err = db.Update(&Member{ID: 2, Name: "b", Email: "a@example.com"})
+		if errCode(err) != CodeUniqueViolation {
+			t.Fatalf("expected a unique violation; found %v", err)
+		}
+	})
+
+	t.Run("Composite unique", func(t *testing.T) {
+		db := NewMockDB()
+		if err := db.Insert(&Member{Name: "a", TeamID: 1, Number: 7}, &Member{Name: "b", TeamID: 2, Number: 7}); err != nil {
+			t.Fatal(err)
+		}
+		err := db.Insert(&Member{Name: "c", TeamID: 1, Number: 7})
+		if errCode(err) != CodeUniqueViolation || err.(pg.Error).Field('n') != "members_team_id_number_key" {
+			t.Fatalf("expected a violation of members_team_id_number_key; found %v", err)
+		}
+	})
+
+	t.Run("Not null", func(t *testing.T) {
+		db := NewMockDB()
+		err := db.Insert(&Member{})
+		if errCode(err) != CodeNotNullViolation || err.(pg.Error).Field('c') != "name" {
+			t.Fatalf("expected a not null violation of name; found %v", err)
+		}
+		if len(db.models) != 0 {
+			t.Fatal("expected the failed insert not to be stored")
+		}
+
+		m := &Member{Name: "a"}
+		if err := db.Insert(m); err != nil {
+			t.Fatalf("use_zero should allow false: %v", err)
+		}
+		err = db.Update(&Member{ID: m.ID})
+		if errCode(err) != CodeNotNullViolation {
+			t.Fatalf("expected a not null violation; found %v", err)
+		}
+	})
+
+	t.Run("Transactions", func(t *testing.T) {
+		db := NewMockDB()
+		db.QueueModels(&Member{ID: 1, Name: "a", Email: "a@example.com"})
+		err := db.RunInTransaction(func(tx Tx) error {
+			return tx.Insert(&Member{Name: "b", Email: "a@example.com"})
+		})
+		if !err.(pg.Error).IntegrityViolation() {
+			t.Fatalf("expected an integrity violation; found %v", err)
+		}
+		if len(db.models) != 1 {
+			t.Fatal("expected the transaction to be rolled back")
+		}
+	})
+
+	t.Run("Soft deleted rows", func(t *testing.T) {
+		db := NewMockDB()
+		if err := db.Insert(&Post{ID: 1}); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Delete(&Post{ID: 1}); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Insert(&Post{ID: 1}); errCode(err) != CodeUniqueViolation {
+			t.Fatalf("expected a soft deleted row to keep its key; found %v", err)
+		}
+	})
+}
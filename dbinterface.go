@@ -16,6 +16,14 @@ type BaseDB interface {
 	// returns ErrNoRows error when query returns zero rows or
 	// ErrMultiRows when query returns multiple rows.
 	QueryOne(model interface{}, query interface{}, params ...interface{}) (pg.Result, error)
+
+	// Exec executes a query that doesn't return rows, typically an INSERT,
+	// UPDATE or DELETE. The params are for any placeholders in the query.
+	Exec(query interface{}, params ...interface{}) (pg.Result, error)
+
+	// Model returns a new query for the model, which may be a pointer to a
+	// struct or a slice of structs.
+	Model(model ...interface{}) Query
 }
 
 // DB interface includes the pg.DB methods used in transactions API
@@ -31,6 +39,9 @@ type Tx interface {
 	// QueryOne is an alias for DB.QueryOne
 	QueryOne(model interface{}, query interface{}, params ...interface{}) (pg.Result, error)
 
+	// Exec is an alias for DB.Exec
+	Exec(query interface{}, params ...interface{}) (pg.Result, error)
+
 	// Select is an alias for DB.Select
 	Select(model interface{}) error
 
@@ -42,4 +53,10 @@ type Tx interface {
 
 	// Delete is an alias for DB.Delete
 	Delete(model interface{}) error
+
+	// ForceDelete is an alias for DB.ForceDelete
+	ForceDelete(model interface{}) error
+
+	// Model is an alias for DB.Model
+	Model(model ...interface{}) Query
 }
@@ -2,6 +2,7 @@ package testutils
 
 import (
 	"github.com/go-pg/pg/v9"
+	"github.com/go-pg/pg/v9/orm"
 )
 
 type DBWrapper struct {
@@ -14,7 +15,7 @@ type DBWrapper struct {
 func (db *DBWrapper) RunInTransaction(fn func(Tx) error) error {
 	var fn2 func(*pg.Tx) error
 	fn2 = func(tx *pg.Tx) error {
-		return fn(tx)
+		return fn(&TxWrapper{tx})
 	}
 	return db.DB.RunInTransaction(fn2)
 }
@@ -31,3 +32,112 @@ func (db *DBWrapper) Query(model interface{}, query interface{}, params ...inter
 func (db *DBWrapper) QueryOne(model interface{}, query interface{}, params ...interface{}) (pg.Result, error) {
 	return db.DB.QueryOne(model, query, params...)
 }
+
+// Model returns a new query for the model
+func (db *DBWrapper) Model(model ...interface{}) Query {
+	return &QueryWrapper{db.DB.Model(model...)}
+}
+
+// TxWrapper wraps a pg.Tx to implement the Tx interface
+type TxWrapper struct {
+	*pg.Tx
+}
+
+// Model returns a new query for the model in the transaction
+func (tx *TxWrapper) Model(model ...interface{}) Query {
+	return &QueryWrapper{tx.Tx.Model(model...)}
+}
+
+// QueryWrapper wraps an orm.Query to implement the Query interface
+type QueryWrapper struct {
+	*orm.Query
+}
+
+// Where adds a condition joined to the previous ones with AND
+func (q *QueryWrapper) Where(condition string, params ...interface{}) Query {
+	q.Query.Where(condition, params...)
+	return q
+}
+
+// WhereIn adds a condition like "id IN (?)" with the values in the slice
+func (q *QueryWrapper) WhereIn(where string, slice interface{}) Query {
+	q.Query.WhereIn(where, slice)
+	return q
+}
+
+// Order adds columns to order by
+func (q *QueryWrapper) Order(orders ...string) Query {
+	q.Query.Order(orders...)
+	return q
+}
+
+// Limit limits the number of rows returned
+func (q *QueryWrapper) Limit(n int) Query {
+	q.Query.Limit(n)
+	return q
+}
+
+// Offset skips the first rows
+func (q *QueryWrapper) Offset(n int) Query {
+	q.Query.Offset(n)
+	return q
+}
+
+// Column limits the columns selected, inserted or updated
+func (q *QueryWrapper) Column(columns ...string) Query {
+	q.Query.Column(columns...)
+	return q
+}
+
+// ColumnExpr adds an expression to select
+func (q *QueryWrapper) ColumnExpr(expr string, params ...interface{}) Query {
+	q.Query.ColumnExpr(expr, params...)
+	return q
+}
+
+// Group adds columns to group by
+func (q *QueryWrapper) Group(columns ...string) Query {
+	q.Query.Group(columns...)
+	return q
+}
+
+// GroupExpr adds an expression to group by
+func (q *QueryWrapper) GroupExpr(group string, params ...interface{}) Query {
+	q.Query.GroupExpr(group, params...)
+	return q
+}
+
+// Having adds a condition on the groups
+func (q *QueryWrapper) Having(having string, params ...interface{}) Query {
+	q.Query.Having(having, params...)
+	return q
+}
+
+// Join joins a table
+func (q *QueryWrapper) Join(join string, params ...interface{}) Query {
+	q.Query.Join(join, params...)
+	return q
+}
+
+// JoinOn adds a condition to the last join
+func (q *QueryWrapper) JoinOn(condition string, params ...interface{}) Query {
+	q.Query.JoinOn(condition, params...)
+	return q
+}
+
+// Relation loads the relation with the field name, passing the apply functions
+// a QueryWrapper of the relation's query
+func (q *QueryWrapper) Relation(name string, apply ...func(Query) (Query, error)) Query {
+	wrapped := make([]func(*orm.Query) (*orm.Query, error), len(apply))
+	for i, fn := range apply {
+		fn := fn
+		wrapped[i] = func(oq *orm.Query) (*orm.Query, error) {
+			if _, err := fn(&QueryWrapper{oq}); err != nil {
+				return nil, err
+			}
+			return oq, nil
+		}
+	}
+	q.Query.Relation(name, wrapped...)
+	return q
+}
@@ -0,0 +1,109 @@
+package testutils
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// applyDefaults sets the zero columns of inserted models which Postgres would
+// fill in, and writes them back into the models like go-pg does with
+// RETURNING. Columns tagged with a default: expression get its value, and
+// created_at and updated_at timestamp columns get the time now.
+func (db *MockDB) applyDefaults(models []interface{}, now time.Time) {
+	for _, m := range models {
+		v := reflect.ValueOf(m)
+		if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+			continue
+		}
+		for _, c := range getTable(v.Type()).columns {
+			f := c.value(v.Elem())
+			if !f.IsValid() || !f.IsZero() || c.hasOption("use_zero") {
+				continue
+			}
+			if expr, ok := c.options["default"]; ok {
+				db.setDefault(f, expr, now)
+			} else if c.name == "created_at" || c.name == "updated_at" {
+				setTime(f, now)
+			}
+		}
+	}
+}
+
+// touchModel sets the updated_at timestamp column of an updated model, if it
+// has one, to the time now
+func touchModel(model interface{}, now time.Time) {
+	v := reflect.ValueOf(model)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return
+	}
+	if c := getTable(v.Type()).column("updated_at"); c != nil {
+		if f := c.value(v.Elem()); f.IsValid() {
+			setTime(f, now)
+		}
+	}
+}
+
+// setDefault sets f to the value of a default: expression. Time functions,
+// UUID functions and literals are supported; other expressions leave f zero.
+func (db *MockDB) setDefault(f reflect.Value, expr string, now time.Time) {
+	expr = strings.TrimSpace(expr)
+	// Drop a cast, like '{}'::jsonb
+	if i := strings.LastIndex(expr, "::"); i > 0 && !strings.HasSuffix(expr, "'") {
+		expr = expr[:i]
+	}
+	switch strings.ToLower(expr) {
+	case "now()", "current_timestamp", "transaction_timestamp()", "localtimestamp", "statement_timestamp()":
+		setTime(f, now)
+		return
+	case "clock_timestamp()":
+		setTime(f, db.now())
+		return
+	case "current_date":
+		y, m, d := now.Date()
+		setTime(f, time.Date(y, m, d, 0, 0, 0, 0, now.Location()))
+		return
+	case "gen_random_uuid()", "uuid_generate_v4()":
+		if u, err := newUUID(); err == nil {
+			_ = assignValue(f, reflect.ValueOf(u.String()))
+		}
+		return
+	case "null":
+		return
+	}
+
+	var value interface{}
+	if strings.HasPrefix(expr, "'") && strings.HasSuffix(expr, "'") && len(expr) > 1 {
+		value = strings.ReplaceAll(expr[1:len(expr)-1], "''", "'")
+	} else if b, err := strconv.ParseBool(expr); err == nil {
+		value = b
+	} else if n, err := strconv.ParseInt(expr, 10, 64); err == nil {
+		value = n
+	} else if x, err := strconv.ParseFloat(expr, 64); err == nil {
+		value = x
+	} else {
+		return
+	}
+	_ = assignValue(f, reflect.ValueOf(value))
+}
+
+// setTime sets f, a time.Time field, a pointer to one or a type wrapping one
+// like pg.NullTime, to t
+func setTime(f reflect.Value, t time.Time) {
+	if f.Kind() == reflect.Ptr {
+		if f.Type().Elem() != timeType {
+			return
+		}
+		f.Set(reflect.New(timeType))
+		f = f.Elem()
+	}
+	switch {
+	case f.Type() == timeType:
+		f.Set(reflect.ValueOf(t))
+	case timeType.ConvertibleTo(f.Type()):
+		f.Set(reflect.ValueOf(t).Convert(f.Type()))
+	case isTimeType(f.Type()):
+		f.Field(0).Set(reflect.ValueOf(t))
+	}
+}
@@ -0,0 +1,109 @@
+package testutils
+
+import (
+	"testing"
+	"time"
+)
+
+type nullTime struct {
+	time.Time
+}
+
+type Order struct {
+	ID        int
+	Status    string    `pg:"default:'pending'::text"`
+	Quantity  int       `pg:"default:1"`
+	Gift      bool      `pg:"default:true"`
+	Ref       string    `pg:"default:gen_random_uuid()"`
+	PlacedAt  time.Time `pg:"default:now()"`
+	Note      string    `pg:"default:some_function()"`
+	CreatedAt time.Time
+	UpdatedAt *time.Time
+	ShippedAt nullTime `pg:"default:current_timestamp"`
+}
+
+func TestDefaults(t *testing.T) {
+	t1 := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	t2 := t1.Add(time.Hour)
+
+	t.Run("Insert", func(t *testing.T) {
+		db := NewMockDB()
+		db.SetClock(NewFakeClock(t1))
+		o := &Order{Quantity: 3}
+		if err := db.Insert(o); err != nil {
+			t.Fatal(err)
+		}
+		if o.Status != "pending" || o.Quantity != 3 || !o.Gift || o.Note != "" {
+			t.Fatalf("unexpected defaults %+v", o)
+		}
+		if _, ok := parseUUID(o.Ref); !ok {
+			t.Fatalf("expected a UUID default; found %q", o.Ref)
+		}
+		if !o.PlacedAt.Equal(t1) || !o.CreatedAt.Equal(t1) || o.UpdatedAt == nil ||
+			!o.UpdatedAt.Equal(t1) || !o.ShippedAt.Equal(t1) {
+			t.Fatalf("expected timestamps %v; found %+v", t1, o)
+		}
+
+		stored, _ := db.FindAny(&Order{ID: o.ID})
+		if stored.(*Order).Status != "pending" {
+			t.Fatal("expected the stored order to have its defaults")
+		}
+	})
+
+	t.Run("Update", func(t *testing.T) {
+		clock := NewFakeClock(t1)
+		db := NewMockDB()
+		db.SetClock(clock)
+		o := &Order{}
+		if err := db.Insert(o); err != nil {
+			t.Fatal(err)
+		}
+		clock.Set(t2)
+		if err := db.Update(o); err != nil {
+			t.Fatal(err)
+		}
+		if !o.UpdatedAt.Equal(t2) || !o.CreatedAt.Equal(t1) {
+			t.Fatalf("expected only updated_at to change; found %+v", o)
+		}
+	})
+
+	t.Run("Failed update", func(t *testing.T) {
+		clock := NewFakeClock(t1)
+		db := NewMockDB()
+		db.SetClock(clock)
+		o := &Order{ID: 1}
+		if err := db.Update(o); err == nil {
+			t.Fatal("expected an error updating a missing order")
+		}
+		if o.UpdatedAt != nil {
+			t.Fatalf("a failed update should not set updated_at; found %v", o.UpdatedAt)
+		}
+
+		tx, _ := db.Begin()
+		if err := tx.Update(o); err == nil {
+			t.Fatal("expected an error updating a missing order")
+		}
+		if o.UpdatedAt != nil {
+			t.Fatalf("a failed update should not set updated_at; found %v", o.UpdatedAt)
+		}
+		_ = tx.Rollback()
+	})
+
+	t.Run("Transaction time", func(t *testing.T) {
+		clock := NewFakeClock(t1)
+		db := NewMockDB()
+		db.SetClock(clock)
+		tx, _ := db.Begin()
+		clock.Advance(time.Hour)
+		o := &Order{}
+		if err := tx.Insert(o); err != nil {
+			t.Fatal(err)
+		}
+		if !o.CreatedAt.Equal(t1) {
+			t.Fatalf("expected the transaction's start time %v; found %v", t1, o.CreatedAt)
+		}
+		if err := tx.Commit(); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
@@ -0,0 +1,283 @@
+package testutils
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+// QueryMatcher compares the SQL of an expected query with the SQL received by
+// the mock database. It returns an error describing the mismatch, or nil if
+// the query matches.
+type QueryMatcher interface {
+	Match(expectedSQL, actualSQL string) error
+}
+
+// QueryMatcherFunc allows an ordinary function to be used as a QueryMatcher
+type QueryMatcherFunc func(expectedSQL, actualSQL string) error
+
+// Match calls f(expectedSQL, actualSQL)
+func (f QueryMatcherFunc) Match(expectedSQL, actualSQL string) error {
+	return f(expectedSQL, actualSQL)
+}
+
+// QueryMatcherEqual requires the received SQL to be identical to the expected
+// SQL.
+var QueryMatcherEqual QueryMatcher = QueryMatcherFunc(func(expectedSQL, actualSQL string) error {
+	if expectedSQL != actualSQL {
+		return fmt.Errorf("query '%s' is not equal to expected '%s'", actualSQL, expectedSQL)
+	}
+	return nil
+})
+
+// QueryMatcherRegexp treats the expected SQL as a regular expression which must
+// match the received SQL. It is the default QueryMatcher.
+var QueryMatcherRegexp QueryMatcher = QueryMatcherFunc(func(expectedSQL, actualSQL string) error {
+	re, err := regexp.Compile(expectedSQL)
+	if err != nil {
+		return fmt.Errorf("expected query '%s' is not a valid regexp: %v", expectedSQL, err)
+	}
+	if !re.MatchString(actualSQL) {
+		return fmt.Errorf("query '%s' does not match regexp '%s'", actualSQL, expectedSQL)
+	}
+	return nil
+})
+
+// QueryMatcherNormalized compares the expected and received SQL ignoring case,
+// repeated whitespace, whitespace around punctuation and a trailing semicolon.
+var QueryMatcherNormalized QueryMatcher = QueryMatcherFunc(func(expectedSQL, actualSQL string) error {
+	if normalizeSQL(expectedSQL) != normalizeSQL(actualSQL) {
+		return fmt.Errorf("query '%s' does not match expected '%s'", actualSQL, expectedSQL)
+	}
+	return nil
+})
+
+var (
+	whitespaceRE  = regexp.MustCompile(`\s+`)
+	punctuationRE = regexp.MustCompile(`\s*([(),=<>])\s*`)
+)
+
+// normalizeSQL lowercases the query, collapses whitespace and removes it around
+// punctuation so formatting differences don't affect matching
+func normalizeSQL(sql string) string {
+	sql = strings.TrimSpace(whitespaceRE.ReplaceAllString(sql, " "))
+	sql = strings.TrimSpace(strings.TrimSuffix(sql, ";"))
+	sql = punctuationRE.ReplaceAllString(sql, "$1")
+	return strings.ToLower(sql)
+}
+
+// Argument allows WithArgs to match a query parameter with a custom predicate
+// instead of comparing it for equality
+type Argument interface {
+	Match(interface{}) bool
+}
+
+type anyArg struct{}
+
+func (anyArg) Match(interface{}) bool { return true }
+
+func (anyArg) String() string { return "<any>" }
+
+// AnyArg returns an Argument which matches any query parameter
+func AnyArg() Argument {
+	return anyArg{}
+}
+
+// ExpectedQuery is a query expected by a MockDB, created by ExpectQuery. Its
+// methods configure the parameters it must be called with and the value it
+// returns.
+type ExpectedQuery struct {
+	sql         string
+	args        []interface{}
+	checkArgs   bool
+	response    interface{}
+	hasResponse bool
+	result      *MockResult
+	err         error
+	triggered   bool
+}
+
+// WithArgs requires the query to be called with the given params. Each value
+// is compared for equality with the param in the same position, unless it is
+// an Argument, in which case its Match method is used.
+func (e *ExpectedQuery) WithArgs(args ...interface{}) *ExpectedQuery {
+	e.args = args
+	e.checkArgs = true
+	return e
+}
+
+// WillReturn sets the response copied into the model when the expected query
+// is executed. It accepts the same values as QueueResponses.
+func (e *ExpectedQuery) WillReturn(response interface{}) *ExpectedQuery {
+	e.response = response
+	e.hasResponse = true
+	return e
+}
+
+// WillReturnResult sets the pg.Result returned when the expected query is
+// executed, instead of one counting the rows in the response
+func (e *ExpectedQuery) WillReturnResult(result *MockResult) *ExpectedQuery {
+	e.result = result
+	return e
+}
+
+// WillReturnError sets the error returned when the expected query is executed
+func (e *ExpectedQuery) WillReturnError(err error) *ExpectedQuery {
+	e.err = err
+	return e
+}
+
+// String describes the expectation for error messages
+func (e *ExpectedQuery) String() string {
+	s := fmt.Sprintf("query '%s'", e.sql)
+	if e.checkArgs {
+		s += fmt.Sprintf(" with args %v", e.args)
+	}
+	return s
+}
+
+// matchArgs returns an error if params don't match the expected args
+func (e *ExpectedQuery) matchArgs(params []interface{}) error {
+	if !e.checkArgs {
+		return nil
+	}
+	if len(params) != len(e.args) {
+		return fmt.Errorf("expected %d args %v; received %d args %v",
+			len(e.args), e.args, len(params), params)
+	}
+	for i, arg := range e.args {
+		if m, ok := arg.(Argument); ok {
+			if !m.Match(params[i]) {
+				return fmt.Errorf("arg %d (%v) does not match expected %v", i, params[i], arg)
+			}
+			continue
+		}
+		if !valuesEqual(arg, params[i]) {
+			return fmt.Errorf("arg %d (%v) does not match expected %v", i, params[i], arg)
+		}
+	}
+	return nil
+}
+
+// ExpectQuery adds an expectation that Query or QueryOne will be called with a
+// query matching sql, using the MockDB's QueryMatcher. Once an expectation has
+// been added, every Query and QueryOne call must match the next unfulfilled
+// expectation, in the order they were added, or it returns an error.
+func (db *MockDB) ExpectQuery(sql string) *ExpectedQuery {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	e := &ExpectedQuery{sql: sql}
+	db.expectations = append(db.expectations, e)
+	return e
+}
+
+// SetQueryMatcher changes how expected queries are compared with the queries
+// received. The default is QueryMatcherRegexp.
+func (db *MockDB) SetQueryMatcher(matcher QueryMatcher) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.queryMatcher = matcher
+}
+
+// ExpectationsWereMet returns an error listing every expectation which was not
+// fulfilled and every queued response which was not consumed, or nil if the
+// test used all of them
+func (db *MockDB) ExpectationsWereMet() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	var msgs []string
+	var unfulfilled []string
+	for _, e := range db.expectations {
+		if !e.triggered {
+			unfulfilled = append(unfulfilled, "  "+e.String())
+		}
+	}
+	if len(unfulfilled) > 0 {
+		msgs = append(msgs, fmt.Sprintf("there are %d unfulfilled expectations:\n%s",
+			len(unfulfilled), strings.Join(unfulfilled, "\n")))
+	}
+	if n := len(db.responses); n > 0 {
+		responses, err := marshalIndent(db.responses)
+		if err != nil {
+			responses = fmt.Sprintf("%v", db.responses)
+		}
+		msgs = append(msgs, fmt.Sprintf("there are %d unconsumed responses:\n%s", n, responses))
+	}
+	if len(msgs) > 0 {
+		return errors.New(strings.Join(msgs, "\n"))
+	}
+	return nil
+}
+
+// nextExpectation returns the next unfulfilled expectation if it matches the
+// query and params, marking it fulfilled, or an error explaining why the call
+// was not expected
+func (db *MockDB) nextExpectation(method string, query interface{}, params []interface{}) (*ExpectedQuery, error) {
+	sql := querySQL(query)
+	var next *ExpectedQuery
+	for _, e := range db.expectations {
+		if !e.triggered {
+			next = e
+			break
+		}
+	}
+	if next == nil {
+		return nil, fmt.Errorf(
+			"call to %s with query '%s' and args %v was not expected, all expectations were already fulfilled",
+			method, sql, params)
+	}
+
+	matcher := db.queryMatcher
+	if matcher == nil {
+		matcher = QueryMatcherRegexp
+	}
+	if err := matcher.Match(next.sql, sql); err != nil {
+		return nil, fmt.Errorf("call to %s was not expected, next expectation is %s: %v",
+			method, next, err)
+	}
+	if err := next.matchArgs(params); err != nil {
+		return nil, fmt.Errorf("call to %s with query '%s' does not match %s: %v",
+			method, sql, next, err)
+	}
+	next.triggered = true
+	return next, nil
+}
+
+// querySQL returns the text of a query passed to Query or QueryOne
+func querySQL(query interface{}) string {
+	switch q := query.(type) {
+	case string:
+		return q
+	case fmt.Stringer:
+		return q.String()
+	default:
+		return fmt.Sprint(q)
+	}
+}
+
+// valuesEqual compares two values for equality, treating numbers of different
+// types as equal if they have the same value
+func valuesEqual(a, b interface{}) bool {
+	if reflect.DeepEqual(a, b) {
+		return true
+	}
+	af, aok := toFloat(a)
+	bf, bok := toFloat(b)
+	return aok && bok && af == bf
+}
+
+// toFloat converts any numeric value to a float64
+func toFloat(v interface{}) (float64, bool) {
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(rv.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), true
+	}
+	return 0, false
+}
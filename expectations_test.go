@@ -0,0 +1,185 @@
+package testutils
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestExpectQuery(t *testing.T) {
+	t.Run("Matches regexp and returns response", func(t *testing.T) {
+		tm := &TestModel{ID: 1, Name: "Test Model"}
+		db := NewMockDB()
+		db.ExpectQuery(`SELECT \* FROM test_models WHERE id = \?`).WithArgs(1).WillReturn(*tm)
+
+		response := &TestModel{}
+		if _, err := db.QueryOne(response, "SELECT * FROM test_models WHERE id = ?", 1); err != nil {
+			t.Fatal(err)
+		}
+		if !response.Equals(tm) {
+			t.Fatal("response struct doesn't match expected response")
+		}
+	})
+
+	t.Run("Unexpected query", func(t *testing.T) {
+		db := NewMockDB()
+		db.ExpectQuery(`SELECT name FROM test_models`)
+
+		_, err := db.Query(&TestModel{}, "DELETE FROM test_models")
+		if err == nil {
+			t.Fatal("expected an error for an unexpected query")
+		}
+	})
+
+	t.Run("Mismatched args", func(t *testing.T) {
+		db := NewMockDB()
+		db.ExpectQuery(`SELECT`).WithArgs(1, "a")
+
+		if _, err := db.Query(&TestModel{}, "SELECT", 1, "b"); err == nil {
+			t.Fatal("expected an error for mismatched args")
+		}
+		if _, err := db.Query(&TestModel{}, "SELECT", 1); err == nil {
+			t.Fatal("expected an error for the wrong number of args")
+		}
+		if _, err := db.Query(&TestModel{}, "SELECT", int64(1), "a"); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("AnyArg", func(t *testing.T) {
+		db := NewMockDB()
+		db.ExpectQuery(`SELECT`).WithArgs(AnyArg(), "a")
+
+		if _, err := db.Query(&TestModel{}, "SELECT", 42, "a"); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("In order", func(t *testing.T) {
+		db := NewMockDB()
+		db.ExpectQuery(`first`)
+		db.ExpectQuery(`second`)
+
+		if _, err := db.Query(&TestModel{}, "second"); err == nil {
+			t.Fatal("expected an error for a query out of order")
+		}
+		if _, err := db.Query(&TestModel{}, "first"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.Query(&TestModel{}, "second"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.Query(&TestModel{}, "third"); err == nil {
+			t.Fatal("expected an error after all expectations were fulfilled")
+		}
+	})
+
+	t.Run("WillReturnError", func(t *testing.T) {
+		db := NewMockDB()
+		expected := errors.New("connection refused")
+		db.ExpectQuery(`SELECT`).WillReturnError(expected)
+
+		if _, err := db.Query(&TestModel{}, "SELECT 1"); err != expected {
+			t.Fatalf("expected error %v; found %v", expected, err)
+		}
+	})
+
+	t.Run("QueryMatcherEqual", func(t *testing.T) {
+		db := NewMockDB()
+		db.SetQueryMatcher(QueryMatcherEqual)
+		db.ExpectQuery(`SELECT * FROM test_models`)
+
+		if _, err := db.Query(&TestModel{}, "SELECT * FROM test_models WHERE id = 1"); err == nil {
+			t.Fatal("expected an error for a query which is not equal")
+		}
+	})
+
+	t.Run("QueryMatcherNormalized", func(t *testing.T) {
+		db := NewMockDB()
+		db.SetQueryMatcher(QueryMatcherNormalized)
+		db.ExpectQuery(`select id, name from test_models where id in (?, ?)`)
+
+		sql := "SELECT id,name\n\tFROM test_models\n\tWHERE id IN ( ?,? );"
+		if _, err := db.Query(&TestModel{}, sql, 1, 2); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func TestExpectationsWereMet(t *testing.T) {
+	t.Run("All consumed", func(t *testing.T) {
+		db := NewMockDB()
+		db.ExpectQuery(`SELECT`).WillReturn(TestModel{ID: 1})
+		if _, err := db.Query(&TestModel{}, "SELECT 1"); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.ExpectationsWereMet(); err != nil {
+			t.Fatal(err)
+		}
+
+		db = NewMockDB()
+		db.QueueResponses(TestModel{ID: 2})
+		if _, err := db.Query(&TestModel{}, "SELECT 2"); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.ExpectationsWereMet(); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("Unfulfilled expectation", func(t *testing.T) {
+		db := NewMockDB()
+		db.ExpectQuery(`SELECT name FROM test_models`)
+
+		if err := db.ExpectationsWereMet(); err == nil {
+			t.Fatal("expected an error for an unfulfilled expectation")
+		}
+	})
+
+	t.Run("Unconsumed response", func(t *testing.T) {
+		db := NewMockDB()
+		db.QueueResponses(TestModel{ID: 1, Name: "Leftover"})
+
+		err := db.ExpectationsWereMet()
+		if err == nil {
+			t.Fatal("expected an error for an unconsumed response")
+		}
+		if !strings.Contains(err.Error(), "Leftover") {
+			t.Fatalf("expected error to include the unconsumed response; found %v", err)
+		}
+	})
+
+	t.Run("NewMockDBT", func(t *testing.T) {
+		ft := &fakeTB{}
+		db := NewMockDBT(ft)
+		db.QueueResponses(TestModel{ID: 1})
+		ft.cleanup()
+		if !ft.failed {
+			t.Fatal("expected the test to fail with an unconsumed response")
+		}
+	})
+}
+
+// fakeTB records failures and cleanup functions so tests can check what
+// NewMockDBT reports
+type fakeTB struct {
+	testing.TB
+	failed   bool
+	cleanups []func()
+}
+
+func (tb *fakeTB) Helper() {}
+
+func (tb *fakeTB) Cleanup(fn func()) {
+	tb.cleanups = append(tb.cleanups, fn)
+}
+
+func (tb *fakeTB) Error(args ...interface{}) {
+	tb.failed = true
+}
+
+func (tb *fakeTB) cleanup() {
+	for i := len(tb.cleanups) - 1; i >= 0; i-- {
+		tb.cleanups[i]()
+	}
+}
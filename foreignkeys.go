@@ -0,0 +1,197 @@
+package testutils
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// foreignKey is a foreign key constraint implied by a relation, from a column
+// of the child table to a column of the parent table
+type foreignKey struct {
+	child     *table
+	col       *column
+	parent    *table
+	parentCol *column
+	onDelete  string
+}
+
+// name returns the constraint's default name in Postgres
+func (fk *foreignKey) name() string {
+	return fk.child.name + "_" + fk.col.name + "_fkey"
+}
+
+// SetForeignKeys enables or disables foreign key constraints, like creating
+// the tables with go-pg's FKConstraints option. When enabled, Insert and Update
+// return a foreign key violation if a model references a missing model, and
+// deleting a referenced model applies the relation's on_delete: tag: CASCADE
+// deletes the referencing models, SET NULL clears their foreign keys, and
+// otherwise the delete fails. Constraints are found from the relation fields
+// of the models' types and the types of models stored in the MockDB.
+func (db *MockDB) SetForeignKeys(enabled bool) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.foreignKeys = enabled
+}
+
+// foreignKeys returns the foreign keys implied by the relations of the types of
+// the models
+func foreignKeys(models ...[]interface{}) []*foreignKey {
+	seen := make(map[reflect.Type]bool)
+	var fks []*foreignKey
+	add := func(fk *foreignKey) {
+		for _, other := range fks {
+			if other.child == fk.child && other.col == fk.col && other.parent == fk.parent {
+				if other.onDelete == "" {
+					other.onDelete = fk.onDelete
+				}
+				return
+			}
+		}
+		fks = append(fks, fk)
+	}
+	for _, list := range models {
+		for _, m := range list {
+			v := reflect.Indirect(reflect.ValueOf(m))
+			if v.Kind() != reflect.Struct || seen[v.Type()] {
+				continue
+			}
+			seen[v.Type()] = true
+			for _, rel := range getTable(v.Type()).relations() {
+				onDelete := strings.ToUpper(rel.field.options["on_delete"])
+				switch rel.kind {
+				case belongsTo:
+					add(&foreignKey{rel.base, rel.baseCol, rel.join, rel.joinCol, onDelete})
+				case hasOne, hasMany:
+					add(&foreignKey{rel.join, rel.joinCol, rel.base, rel.baseCol, onDelete})
+				}
+			}
+		}
+	}
+	return fks
+}
+
+// checkForeignKeys returns a foreign key violation if any of the models passed
+// to Insert or Update references a model missing from models
+func checkForeignKeys(models, changed []interface{}) error {
+	all := append(append([]interface{}(nil), models...), changed...)
+	fks := foreignKeys(models, changed)
+	for _, m := range changed {
+		v := reflect.Indirect(reflect.ValueOf(m))
+		for _, fk := range fks {
+			if v.Type() != fk.child.typ {
+				continue
+			}
+			key, ok := columnKey(fk.col, v)
+			if !ok || len(rowsWhere(all, fk.parent, fk.parentCol, key, true)) > 0 {
+				continue
+			}
+			err := ForeignKeyViolation(fk.child.name, fk.name()).(*mockPGError)
+			err.fields['D'] = fmt.Sprintf("Key (%s)=(%v) is not present in table %q.",
+				fk.col.name, key, fk.parent.name)
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteRows deletes model from models like Delete, or like ForceDelete if
+// force is true, first applying the on_delete actions of foreign keys
+// referencing it if they are enabled. No changes are made if it fails.
+func (db *MockDB) deleteRows(models []interface{}, model interface{}, now time.Time, force bool) ([]interface{}, error) {
+	if !db.foreignKeys {
+		if force {
+			return forceDeleteModel(models, model)
+		}
+		return deleteModel(models, model, now)
+	}
+	i, err := indexModel(models, model, force)
+	if err != nil {
+		return models, err
+	}
+	if i < 0 {
+		return models, notFoundError(model, "delete")
+	}
+	if !force && getTable(reflect.TypeOf(model)).softDelete != nil {
+		// A soft delete is an update, which foreign keys do not act on
+		return deleteModel(models, model, now)
+	}
+
+	plan := &deletePlan{deleted: make(map[interface{}]bool)}
+	if err := plan.add(models, foreignKeys(models), models[i]); err != nil {
+		return models, err
+	}
+	for _, n := range plan.nulls {
+		if !plan.deleted[n.row] {
+			f := n.col.value(reflect.ValueOf(n.row).Elem())
+			f.Set(reflect.Zero(f.Type()))
+		}
+	}
+	kept := models[:0:0]
+	for _, r := range models {
+		if !plan.deleted[r] {
+			kept = append(kept, r)
+		}
+	}
+	return kept, nil
+}
+
+// deletePlan is the set of changes deleting a row makes through foreign keys,
+// collected before making any so a failed delete changes nothing
+type deletePlan struct {
+	deleted map[interface{}]bool
+	nulls   []fkNull
+}
+
+// fkNull is a foreign key column to set to null by ON DELETE SET NULL
+type fkNull struct {
+	row interface{}
+	col *column
+}
+
+// add adds the deletion of row, a stored model, to the plan, along with the
+// actions of foreign keys referencing it, or returns an error if a foreign key
+// restricts it
+func (p *deletePlan) add(models []interface{}, fks []*foreignKey, row interface{}) error {
+	if p.deleted[row] {
+		return nil
+	}
+	p.deleted[row] = true
+	v := reflect.Indirect(reflect.ValueOf(row))
+	for _, fk := range fks {
+		if v.Type() != fk.parent.typ {
+			continue
+		}
+		key, ok := columnKey(fk.parentCol, v)
+		if !ok {
+			continue
+		}
+		children := rowsWhere(models, fk.child, fk.col, key, true)
+		switch fk.onDelete {
+		case "CASCADE":
+			for _, c := range children {
+				if err := p.add(models, fks, c); err != nil {
+					return err
+				}
+			}
+		case "SET NULL":
+			for _, c := range children {
+				p.nulls = append(p.nulls, fkNull{c, fk.col})
+			}
+		default:
+			for _, c := range children {
+				if p.deleted[c] {
+					continue
+				}
+				return PGError(CodeForeignKeyViolation,
+					fmt.Sprintf("update or delete on table %q violates foreign key constraint %q on table %q",
+						fk.parent.name, fk.name(), fk.child.name),
+					PGField{'t', fk.child.name}, PGField{'n', fk.name()},
+					PGField{'D', fmt.Sprintf("Key (%s)=(%v) is still referenced from table %q.",
+						fk.parentCol.name, key, fk.child.name)})
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package testutils
+
+import (
+	"testing"
+
+	"github.com/go-pg/pg/v9"
+)
+
+type Comment struct {
+	ID     int
+	BookID int
+	Book   *Book `pg:"on_delete:RESTRICT"`
+}
+
+type Review struct {
+	ID       int
+	AuthorID *int
+	Author   *Author `pg:"on_delete:SET NULL"`
+}
+
+func TestForeignKeys(t *testing.T) {
+	t.Run("Disabled", func(t *testing.T) {
+		db := NewMockDB()
+		if err := db.Insert(&Book{AuthorID: 5}); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("Insert and Update", func(t *testing.T) {
+		db := NewMockDB()
+		db.SetForeignKeys(true)
+		err := db.Insert(&Book{AuthorID: 5})
+		if errCode(err) != CodeForeignKeyViolation || err.(pg.Error).Field('n') != "books_author_id_fkey" {
+			t.Fatalf("expected a violation of books_author_id_fkey; found %v", err)
+		}
+
+		// The author may be inserted with the book
+		b := &Book{AuthorID: 1}
+		if err := db.Insert(&Author{ID: 1}, b); err != nil {
+			t.Fatal(err)
+		}
+		b.AuthorID = 2
+		if err := db.Update(b); errCode(err) != CodeForeignKeyViolation {
+			t.Fatalf("expected a foreign key violation; found %v", err)
+		}
+
+		// Has-many relations declare the foreign key of the other type
+		if err := db.Insert(&Profile{AuthorID: 1}); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Insert(&Profile{AuthorID: 3}); errCode(err) != CodeForeignKeyViolation {
+			t.Fatalf("expected a foreign key violation; found %v", err)
+		}
+	})
+
+	t.Run("On delete", func(t *testing.T) {
+		db := NewMockDB()
+		db.SetForeignKeys(true)
+		authorID := 1
+		db.QueueModels(
+			&Author{ID: 1},
+			&Book{ID: 1, AuthorID: 1},
+			&Book{ID: 2, AuthorID: 1},
+			&Comment{ID: 1, BookID: 2},
+			&Review{ID: 1, AuthorID: &authorID},
+		)
+
+		err := db.Delete(&Author{ID: 1})
+		if errCode(err) != CodeForeignKeyViolation {
+			t.Fatalf("expected the comment to restrict the cascade; found %v", err)
+		}
+		if len(db.models) != 5 {
+			t.Fatal("expected a failed delete to change nothing")
+		}
+
+		if err := db.Delete(&Comment{ID: 1}); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Delete(&Author{ID: 1}); err != nil {
+			t.Fatal(err)
+		}
+		if len(db.models) != 1 {
+			t.Fatalf("expected the books to be deleted; found %d models", len(db.models))
+		}
+		r, _ := db.FindAny(&Review{ID: 1})
+		if r.(*Review).AuthorID != nil {
+			t.Fatal("expected the review's author to be set to null")
+		}
+	})
+
+	t.Run("Transactions", func(t *testing.T) {
+		db := NewMockDB()
+		db.SetForeignKeys(true)
+		db.QueueModels(&Author{ID: 1}, &Book{ID: 1, AuthorID: 1})
+		err := db.RunInTransaction(func(tx Tx) error {
+			return tx.ForceDelete(&Author{ID: 1})
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(db.models) != 0 {
+			t.Fatalf("expected the cascade to be committed; found %d models", len(db.models))
+		}
+	})
+}
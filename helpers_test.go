@@ -0,0 +1,13 @@
+package testutils
+
+import (
+	"github.com/go-pg/pg/v9"
+)
+
+// errCode returns the SQLSTATE code of err, or "" if it is not a pg.Error
+func errCode(err error) string {
+	if pgErr, ok := err.(pg.Error); ok {
+		return pgErr.Field('C')
+	}
+	return ""
+}
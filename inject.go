@@ -0,0 +1,125 @@
+package testutils
+
+import (
+	"encoding/json"
+	"reflect"
+	"regexp"
+)
+
+// queuedError is an error added to the response queue by QueueError
+type queuedError struct {
+	err error
+}
+
+// MarshalJSON shows the queued error's message in MarshalResponses
+func (q queuedError) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]string{"error": q.err.Error()})
+}
+
+// QueueError adds errors to the ordered list of mock responses. When a Query,
+// QueryOne or Select call reaches a queued error, it returns the error instead
+// of a response.
+func (db *MockDB) QueueError(err ...error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	for _, e := range err {
+		db.responses = append(db.responses, queuedError{err: e})
+	}
+}
+
+// ErrorRule makes MockDB and MockTx methods return an error when a call matches
+// it. Rules are created by InjectError and narrowed by chaining their methods;
+// a rule without any conditions matches every call.
+type ErrorRule struct {
+	err       error
+	methods   []string
+	modelType reflect.Type
+	query     *regexp.Regexp
+	nth       int
+	matched   int
+}
+
+// On limits the rule to calls to the named methods, for example "Insert" or
+// "Commit"
+func (r *ErrorRule) On(methods ...string) *ErrorRule {
+	r.methods = methods
+	return r
+}
+
+// ForModel limits the rule to calls with a model of the same type as model
+func (r *ErrorRule) ForModel(model interface{}) *ErrorRule {
+	r.modelType = reflect.TypeOf(model)
+	return r
+}
+
+// WhenQueryMatches limits the rule to calls with a query matching the regular
+// expression pattern. It panics if the pattern is not a valid regexp.
+func (r *ErrorRule) WhenQueryMatches(pattern string) *ErrorRule {
+	r.query = regexp.MustCompile(pattern)
+	return r
+}
+
+// OnCall makes the rule return its error only for the nth call it matches,
+// counting from 1, instead of every call
+func (r *ErrorRule) OnCall(n int) *ErrorRule {
+	r.nth = n
+	return r
+}
+
+// matches returns whether the call is one the rule applies to
+func (r *ErrorRule) matches(method string, models []interface{}, query interface{}) bool {
+	if len(r.methods) > 0 {
+		found := false
+		for _, m := range r.methods {
+			if m == method {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if r.modelType != nil {
+		found := false
+		for _, m := range models {
+			if reflect.TypeOf(m) == r.modelType {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if r.query != nil && (query == nil || !r.query.MatchString(querySQL(query))) {
+		return false
+	}
+	return true
+}
+
+// InjectError adds a rule which makes matching calls to MockDB and MockTx
+// methods return err:
+//
+//	db.InjectError(err).On("Insert").ForModel(&Order{}).OnCall(3)
+func (db *MockDB) InjectError(err error) *ErrorRule {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	r := &ErrorRule{err: err}
+	db.errorRules = append(db.errorRules, r)
+	return r
+}
+
+// injectedError returns the error of the first rule matching the call, or nil
+func (db *MockDB) injectedError(method string, models []interface{}, query interface{}) error {
+	for _, r := range db.errorRules {
+		if !r.matches(method, models, query) {
+			continue
+		}
+		r.matched++
+		if r.nth == 0 || r.nth == r.matched {
+			return r.err
+		}
+	}
+	return nil
+}
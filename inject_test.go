@@ -0,0 +1,117 @@
+package testutils
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+)
+
+type OtherModel struct {
+	ID int `pg:"id" json:"id"`
+}
+
+func (om *OtherModel) GetID() string {
+	return strconv.Itoa(om.ID)
+}
+
+func (om *OtherModel) Equals(i interface{}) bool {
+	b, ok := i.(*OtherModel)
+	return ok && om.ID == b.ID
+}
+
+func TestQueueError(t *testing.T) {
+	tm := &TestModel{ID: 1, Name: "Test Model"}
+	expected := errors.New("connection reset")
+	db := NewMockDB()
+	db.QueueResponses(*tm)
+	db.QueueError(expected)
+	db.QueueResponses(*tm)
+
+	if _, err := db.Query(&TestModel{}, "SELECT 1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.QueryOne(&TestModel{}, "SELECT 2"); err != expected {
+		t.Fatalf("expected error %v; found %v", expected, err)
+	}
+	response := &TestModel{}
+	if _, err := db.Query(response, "SELECT 3"); err != nil {
+		t.Fatal(err)
+	}
+	if !response.Equals(tm) {
+		t.Fatal("response struct doesn't match queued response")
+	}
+}
+
+func TestInjectError(t *testing.T) {
+	expected := errors.New("injected")
+
+	t.Run("Nth call for model type", func(t *testing.T) {
+		db := NewMockDB()
+		db.InjectError(expected).On("Insert").ForModel(&TestModel{}).OnCall(3)
+
+		for i := 1; i <= 4; i++ {
+			if err := db.Insert(&OtherModel{ID: i}); err != nil {
+				t.Fatal(err)
+			}
+			err := db.Insert(&TestModel{ID: i})
+			if i == 3 && err != expected {
+				t.Fatalf("expected error %v on call 3; found %v", expected, err)
+			}
+			if i != 3 && err != nil {
+				t.Fatalf("unexpected error on call %d: %v", i, err)
+			}
+		}
+		if n := len(db.models); n != 7 {
+			t.Fatal("expected 7 models inserted; found ", n)
+		}
+	})
+
+	t.Run("Query pattern", func(t *testing.T) {
+		db := NewMockDB()
+		db.InjectError(expected).WhenQueryMatches(`^UPDATE`)
+
+		if _, err := db.Query(&TestModel{}, "SELECT 1"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.Query(&TestModel{}, "UPDATE test_models SET name = ?", "x"); err != expected {
+			t.Fatalf("expected error %v; found %v", expected, err)
+		}
+		if err := db.Update(&TestModel{ID: 1}); err == expected {
+			t.Fatal("Update without a query should not match the rule")
+		}
+	})
+
+	t.Run("In transaction", func(t *testing.T) {
+		tm := &TestModel{ID: 1, Name: "Test Model"}
+		db := NewMockDB()
+		db.InjectError(expected).On("Update", "Delete")
+
+		err := db.RunInTransaction(func(tx Tx) error {
+			if err := tx.Insert(tm); err != nil {
+				return err
+			}
+			return tx.Delete(tm)
+		})
+		if err != expected {
+			t.Fatalf("expected error %v; found %v", expected, err)
+		}
+		if n := len(db.Calls().Failed()); n != 1 {
+			t.Fatal("expected 1 failed call; found ", n)
+		}
+	})
+
+	t.Run("Commit", func(t *testing.T) {
+		db := NewMockDB()
+		db.InjectError(expected).On("Commit")
+
+		err := db.RunInTransaction(func(tx Tx) error {
+			return tx.Insert(&TestModel{ID: 1})
+		})
+		if err != expected {
+			t.Fatalf("expected error %v; found %v", expected, err)
+		}
+		if n := len(db.models); n != 0 {
+			t.Fatal("expected no models after failed commit; found ", n)
+		}
+	})
+}
@@ -0,0 +1,134 @@
+package testutils
+
+import (
+	"encoding/hex"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Key is the primary key of a model, with a value for each primary key column.
+// Integer values of any type are equal, as are UUIDs stored as strings and as
+// [16]byte arrays, so a Key may be built from whichever form a test has.
+type Key []interface{}
+
+// Keyer may be implemented by a model to override its primary key
+type Keyer interface {
+	PrimaryKey() Key
+}
+
+// NewKey creates a Key with a value for each primary key column
+func NewKey(values ...interface{}) Key {
+	return Key(values)
+}
+
+// KeyOf returns the primary key of a model. It uses PrimaryKey() if the model
+// implements Keyer, GetID() if it implements Model, and otherwise the values of
+// its primary key columns, which are tagged pg:",pk" or else the id column.
+func KeyOf(model interface{}) (Key, error) {
+	switch m := model.(type) {
+	case Keyer:
+		return m.PrimaryKey(), nil
+	case Model:
+		return Key{m.GetID()}, nil
+	}
+	v := reflect.Indirect(reflect.ValueOf(model))
+	if v.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("testutils: %T is not a struct", model)
+	}
+	tbl := getTable(v.Type())
+	if len(tbl.pks) == 0 {
+		return nil, fmt.Errorf(`testutils: %T has no primary key; add a pg:",pk" tag or implement Keyer`, model)
+	}
+	key := make(Key, len(tbl.pks))
+	for i, pk := range tbl.pks {
+		if f := pk.value(v); f.IsValid() {
+			key[i] = f.Interface()
+		}
+	}
+	return key, nil
+}
+
+// Equal returns whether the keys have the same number of values and each is
+// equal after normalizing integers and UUIDs
+func (k Key) Equal(other Key) bool {
+	if len(k) != len(other) {
+		return false
+	}
+	for i := range k {
+		if normalizeKeyValue(k[i]) != normalizeKeyValue(other[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// String formats the key's values separated by commas
+func (k Key) String() string {
+	parts := make([]string, len(k))
+	for i, v := range k {
+		parts[i] = fmt.Sprint(normalizeKeyValue(v))
+	}
+	return strings.Join(parts, ",")
+}
+
+// normalizeKeyValue converts a primary key value to a comparable value, with
+// integers as int64 or uint64 and UUIDs as uuid
+func normalizeKeyValue(value interface{}) interface{} {
+	v := reflect.ValueOf(value)
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+	switch v.Kind() {
+	case reflect.Invalid:
+		return nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if n := v.Uint(); n <= 1<<63-1 {
+			return int64(n)
+		}
+		return v.Uint()
+	case reflect.String:
+		if u, ok := parseUUID(v.String()); ok {
+			return u
+		}
+		return v.String()
+	case reflect.Array:
+		if v.Len() == 16 && v.Type().Elem().Kind() == reflect.Uint8 {
+			var u uuid
+			reflect.Copy(reflect.ValueOf(&u).Elem(), v)
+			return u
+		}
+	}
+	if v.Type().Comparable() {
+		return v.Interface()
+	}
+	return fmt.Sprint(v.Interface())
+}
+
+// uuid is a UUID primary key value
+type uuid [16]byte
+
+// String formats the UUID in its canonical hyphenated form
+func (u uuid) String() string {
+	s := hex.EncodeToString(u[:])
+	return s[:8] + "-" + s[8:12] + "-" + s[12:16] + "-" + s[16:20] + "-" + s[20:]
+}
+
+// parseUUID parses a UUID in its canonical hyphenated form
+func parseUUID(s string) (uuid, bool) {
+	var u uuid
+	if len(s) != 36 || s[8] != '-' || s[13] != '-' || s[18] != '-' || s[23] != '-' {
+		return u, false
+	}
+	b, err := hex.DecodeString(s[:8] + s[9:13] + s[14:18] + s[19:23] + s[24:])
+	if err != nil {
+		return u, false
+	}
+	copy(u[:], b)
+	return u, true
+}
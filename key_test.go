@@ -0,0 +1,140 @@
+package testutils
+
+import (
+	"testing"
+)
+
+type Tag struct {
+	ID [16]byte `pg:"id,pk,type:uuid"`
+}
+
+type Slug struct {
+	Slug string `pg:",pk"`
+}
+
+type Annotation struct {
+	ID          int
+	TestModelID int
+	TestModel   *TestModel
+}
+
+type PinnedModel struct {
+	Code string
+}
+
+func (pm *PinnedModel) PrimaryKey() Key {
+	return NewKey("pinned", pm.Code)
+}
+
+type CodedModel struct {
+	ID   int
+	Code string
+	Name string
+}
+
+func (cm *CodedModel) GetID() string {
+	return cm.Code
+}
+
+func (cm *CodedModel) Equals(i interface{}) bool {
+	b, ok := i.(*CodedModel)
+	return ok && cm.Code == b.Code && cm.Name == b.Name
+}
+
+func TestKey(t *testing.T) {
+	t.Run("KeyOf", func(t *testing.T) {
+		for _, c := range []struct {
+			model interface{}
+			key   Key
+		}{
+			{&Account{ID: 7}, NewKey(7)},
+			{&Membership{OrgID: 1, UserID: 2}, NewKey(1, 2)},
+			{&Document{BaseFields: BaseFields{UUID: "abc"}}, NewKey("abc")},
+			{&Slug{Slug: "hello"}, NewKey("hello")},
+			{&TestModel{ID: 3}, NewKey("3")},
+			{&PinnedModel{Code: "x"}, NewKey("pinned", "x")},
+			{&CodedModel{ID: 4, Code: "y"}, NewKey("y")},
+		} {
+			key, err := KeyOf(c.model)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !key.Equal(c.key) {
+				t.Fatalf("expected %T key %v; found %v", c.model, c.key, key)
+			}
+		}
+		if _, err := KeyOf(&Note{}); err == nil {
+			t.Fatal("expected an error for a model without a primary key")
+		}
+	})
+
+	t.Run("Equal", func(t *testing.T) {
+		id := [16]byte{0x12, 0x3e, 0x45, 0x67, 0xe8, 0x9b, 0x12, 0xd3, 0xa4, 0x56, 0x42, 0x66, 0x14, 0x17, 0x40, 0x00}
+		if !NewKey(int32(5)).Equal(NewKey(uint64(5))) {
+			t.Fatal("integer keys of different types should be equal")
+		}
+		if !NewKey(id).Equal(NewKey("123e4567-e89b-12d3-a456-426614174000")) {
+			t.Fatal("UUID keys as an array and a string should be equal")
+		}
+		if NewKey(1, 2).Equal(NewKey(1)) || NewKey(1, 2).Equal(NewKey(2, 1)) {
+			t.Fatal("composite keys should only equal the same values in order")
+		}
+		if s := NewKey(id, 9).String(); s != "123e4567-e89b-12d3-a456-426614174000,9" {
+			t.Fatalf("unexpected key string %q", s)
+		}
+	})
+
+	t.Run("UUID keys", func(t *testing.T) {
+		db := NewMockDB()
+		tag := &Tag{ID: [16]byte{1}}
+		if err := db.Insert(tag); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Delete(&Tag{ID: [16]byte{1}}); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Delete(&Tag{ID: [16]byte{1}}); err == nil {
+			t.Fatal("expected an error deleting a missing tag")
+		}
+	})
+	t.Run("Model keys", func(t *testing.T) {
+		db := NewMockDB()
+		db.SetForeignKeys(true)
+		if err := db.Insert(&TestModel{ID: 1, Name: "Test Model"}); err != nil {
+			t.Fatal(err)
+		}
+		m := &TestModel{ID: 1}
+		if err := db.Model(m).Where("id = ?", 1).Select(); err != nil {
+			t.Fatal(err)
+		}
+		if m.Name != "Test Model" {
+			t.Fatalf("expected a query by id to find the model; found %+v", m)
+		}
+		if err := db.Insert(&Annotation{ID: 1, TestModelID: 1}); err != nil {
+			t.Fatal(err)
+		}
+		err := db.Insert(&Annotation{ID: 2, TestModelID: 2})
+		if errCode(err) != CodeForeignKeyViolation {
+			t.Fatalf("expected a foreign key violation; found %v", err)
+		}
+		err = db.Delete(&TestModel{ID: 1})
+		if errCode(err) != CodeForeignKeyViolation {
+			t.Fatalf("expected the referenced model to be kept; found %v", err)
+		}
+	})
+
+	t.Run("GetID overrides the id column", func(t *testing.T) {
+		db := NewMockDB()
+		db.QueueModels(&CodedModel{ID: 1, Code: "a", Name: "Test Model"})
+		if err := db.Update(&CodedModel{Code: "a", Name: "Updated Model"}); err != nil {
+			t.Fatal(err)
+		}
+		fm, err := db.Find(&CodedModel{Code: "a"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fm == nil || fm.(*CodedModel).Name != "Updated Model" {
+			t.Fatalf("expected to find the model by GetID; found %v", fm)
+		}
+	})
+}
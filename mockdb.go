@@ -4,14 +4,32 @@ import (
 	"encoding/json"
 	"fmt"
 	"reflect"
+	"sync"
+	"testing"
+	"time"
 
 	"github.com/go-pg/pg/v9"
 )
 
-// MockDB implements the DB interface to mock a pg.DB instance
+// MockDB implements the DB interface to mock a pg.DB instance. It is safe for
+// concurrent use by multiple goroutines, as are its transactions.
 type MockDB struct {
-	responses []interface{}
-	models    []Model
+	mu sync.Mutex
+
+	responses    []interface{}
+	models       []interface{}
+	expectations []*ExpectedQuery
+	queryMatcher QueryMatcher
+	calls        Calls
+	txs          []*MockTx
+	errorRules   []*ErrorRule
+	sequences    map[reflect.Type]int64
+	clock        Clock
+	foreignKeys  bool
+
+	selectFromResponses bool
+	evaluateSQL         bool
+	loadOnSelect        bool
 }
 
 // NewMockDB creates a new mock database client for unit tests
@@ -20,34 +38,101 @@ func NewMockDB() *MockDB {
 	return &db
 }
 
+// NewMockDBT creates a new mock database client which fails the test if any
+// queued responses or expectations have not been used when the test finishes
+func NewMockDBT(t testing.TB) *MockDB {
+	t.Helper()
+	db := NewMockDB()
+	t.Cleanup(func() {
+		if err := db.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+	return db
+}
+
 // Begin starts a transaction. Most callers should use RunInTransaction instead.
 func (db *MockDB) Begin() (*MockTx, error) {
-	tx := &MockTx{db: db, open: true, models: append(db.models)}
-	return tx, nil
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.begin(), nil
+}
+
+// begin creates a new open transaction with the next transaction ID
+func (db *MockDB) begin() *MockTx {
+	tx := &MockTx{
+		id:       len(db.txs) + 1,
+		db:       db,
+		models:   copyModels(db.models),
+		snapshot: copyModels(db.models),
+		start:    db.now(),
+	}
+	db.txs = append(db.txs, tx)
+	return tx
+}
+
+// Transactions returns every transaction begun on the MockDB, in the order they
+// were begun. Their State shows whether they were committed or rolled back.
+func (db *MockDB) Transactions() []*MockTx {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return append([]*MockTx(nil), db.txs...)
+}
+
+// OpenTransactions returns the transactions begun on the MockDB which have not
+// been committed or rolled back, which a test may treat as leaked
+func (db *MockDB) OpenTransactions() []*MockTx {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	var open []*MockTx
+	for _, tx := range db.txs {
+		if tx.state == TxOpen {
+			open = append(open, tx)
+		}
+	}
+	return open
 }
 
 // QueueResponses allows a test to add an ordered list of mock responses to the
 // database for Query, QueryOne, and Select calls
 func (db *MockDB) QueueResponses(response ...interface{}) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
 	db.responses = append(db.responses, response...)
 }
 
-// QueueResponses allows a test to add a list of mock data models to the
-// database for Update and Delete calls
-func (db *MockDB) QueueModels(model ...Model) {
+// QueueModels allows a test to add a list of mock data models to the
+// database for Select, Update and Delete calls. Models are identified by their
+// primary keys, or by GetID() if they implement Model. Zero primary keys are
+// generated like Insert.
+func (db *MockDB) QueueModels(model ...interface{}) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	_ = db.generateKeys(model)
 	db.models = append(db.models, model...)
 }
 
 // RunInTransaction runs a function in a transaction. If function
 // returns an error transaction is rollbacked, otherwise transaction
-// is committed.
+// is committed. If function panics, the transaction is rolled back and the
+// panic continues, and the transaction's RecoveredPanic returns the value.
 func (db *MockDB) RunInTransaction(fn func(tx Tx) error) error {
-	tx := &MockTx{db: db, open: true, models: append(db.models)}
+	tx, _ := db.Begin()
+	defer func() {
+		if p := recover(); p != nil {
+			tx.rollbackOnPanic(p)
+			panic(p)
+		}
+	}()
 	if err := fn(tx); err != nil {
+		_ = tx.Rollback()
 		return err
 	}
 	if err := tx.Commit(); err != nil {
-		_ = tx.Rollback()
+		// A Commit whose writes conflict has already rolled back
+		if tx.State() == TxOpen {
+			_ = tx.Rollback()
+		}
 		return err
 	}
 	if err := tx.Close(); err != nil {
@@ -60,110 +145,447 @@ func (db *MockDB) RunInTransaction(fn func(tx Tx) error) error {
 // Query executes a query that returns rows, typically a SELECT.
 // The params are for any placeholders in the query.
 func (db *MockDB) Query(model, query interface{}, params ...interface{}) (pg.Result, error) {
-	if len(db.responses) == 0 {
-		return nil, nil
-	}
-	reflect.ValueOf(model).Elem().Set(reflect.ValueOf(db.responses[0]))
-	db.responses = db.responses[1:]
-
-	return (pg.Result)(nil), nil
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	res, err := db.query(nil, "Query", model, query, params)
+	db.record(0, "Query", model, query, params, res, err)
+	return res, err
 }
 
 // QueryOne acts like Query, but query must return only one row. It
 // returns ErrNoRows error when query returns zero rows or
 // ErrMultiRows when query returns multiple rows.
 func (db *MockDB) QueryOne(model, query interface{}, params ...interface{}) (pg.Result, error) {
-	if len(db.responses) == 0 {
-		return nil, pg.ErrNoRows
-	}
-	reflect.ValueOf(model).Elem().Set(reflect.ValueOf(db.responses[0]))
-	db.responses = db.responses[1:]
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	res, err := db.query(nil, "QueryOne", model, query, params)
+	db.record(0, "QueryOne", model, query, params, res, err)
+	return res, err
+}
 
-	return (pg.Result)(nil), nil
+// Exec executes a query that doesn't return rows, typically an INSERT, UPDATE
+// or DELETE. The params are for any placeholders in the query.
+func (db *MockDB) Exec(query interface{}, params ...interface{}) (pg.Result, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	res, err := db.query(nil, "Exec", nil, query, params)
+	db.record(0, "Exec", nil, query, params, res, err)
+	return res, err
 }
 
-// Select finds a model in the models slice
+// Select finds the model in the models slice with the same type and ID
+// and copies it into model, or returns pg.ErrNoRows if it is not found. If
+// SetSelectFromResponses is enabled, it uses the next queued response instead.
 func (db *MockDB) Select(model interface{}) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	err := db.selectModel(db.models, model)
+	db.record(0, "Select", model, nil, nil, nil, err)
+	return err
+}
+
+// SetSelectFromResponses makes Select and MockTx.Select copy the next queued
+// response into the model, like QueryOne, instead of finding it in the models
+// slice
+func (db *MockDB) SetSelectFromResponses(enabled bool) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.selectFromResponses = enabled
+}
+
+// SetEvaluateSQL makes Query, QueryOne and Exec run the SQL of SELECT, INSERT,
+// UPDATE and DELETE statements against the models slice. Statements which
+// cannot be run are served from the expectations or queued responses as usual,
+// while an unterminated string or unbalanced parentheses return a syntax error.
+func (db *MockDB) SetEvaluateSQL(enabled bool) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.evaluateSQL = enabled
+}
+
+// query serves the Query, QueryOne and Exec methods of MockDB and MockTx, in
+// the transaction if tx is not nil, from the expectations, the SQL if
+// SetEvaluateSQL is enabled, or the queued responses
+func (db *MockDB) query(tx *MockTx, method string, model, query interface{}, params []interface{}) (pg.Result, error) {
+	if err := db.injectedError(method, []interface{}{model}, query); err != nil {
+		return nil, err
+	}
+	one := method == "QueryOne"
+	if len(db.expectations) > 0 {
+		e, err := db.nextExpectation(method, query, params)
+		if err != nil {
+			return nil, err
+		}
+		if e.err != nil {
+			return nil, e.err
+		}
+		if !e.hasResponse && e.result != nil {
+			return e.result, nil
+		}
+		res, err := scanResponse(one, model, e.response)
+		if err != nil {
+			return nil, err
+		}
+		if e.result != nil {
+			return e.result, nil
+		}
+		return res, nil
+	}
+	if db.evaluateSQL {
+		if res, ok, err := db.execSQL(tx, one, model, query, params); ok {
+			return res, err
+		}
+	}
 	if len(db.responses) == 0 {
-		return nil
+		return scanResponse(one, model, nil)
+	}
+	response := db.responses[0]
+	db.responses = db.responses[1:]
+	if q, ok := response.(queuedError); ok {
+		return nil, q.err
+	}
+	return scanResponse(one, model, response)
+}
+
+// selectModel serves the Select methods of MockDB and MockTx, finding the model
+// in models
+func (db *MockDB) selectModel(models []interface{}, model interface{}) error {
+	if err := db.injectedError("Select", []interface{}{model}, nil); err != nil {
+		return err
+	}
+	if db.selectFromResponses {
+		if len(db.responses) == 0 {
+			return pg.ErrNoRows
+		}
+		response := db.responses[0]
+		db.responses = db.responses[1:]
+		if q, ok := response.(queuedError); ok {
+			return q.err
+		}
+		_, err := scanResponse(true, model, response)
+		return err
+	}
+
+	found, err := findModel(models, model)
+	if err != nil {
+		return err
+	}
+	if found == nil {
+		return pg.ErrNoRows
+	}
+	if err := assignValue(reflect.ValueOf(model).Elem(), reflect.ValueOf(deepCopy(found))); err != nil {
+		return err
+	}
+	if db.loadOnSelect && reflect.ValueOf(model).Elem().Kind() == reflect.Struct {
+		return loadRelations(models, model, nil)
 	}
-	reflect.ValueOf(model).Elem().Set(reflect.ValueOf(db.responses[0]))
 	return nil
 }
 
-// Insert appends a model to the models slice
+// Insert appends a copy of a model to the models slice, first setting a zero
+// primary key to the next value of the type's sequence, or a random UUID for a
+// uuid column, and other zero columns with a default: tag or named created_at
+// or updated_at
 func (db *MockDB) Insert(model ...interface{}) error {
-	tms := make([]Model, len(model))
-	for i, m := range model {
-		tms[i] = m.(Model)
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	err := db.injectedError("Insert", model, nil)
+	if err == nil {
+		err = db.insert(model)
+	}
+	db.record(0, "Insert", firstModel(model), nil, nil, writeResult(len(model), err), err)
+	return err
+}
+
+// insert serves Insert and the Insert method of MockQuery
+func (db *MockDB) insert(model []interface{}) error {
+	copies, err := db.prepareInserts(model, db.now())
+	if err != nil {
+		return err
 	}
-	db.models = append(db.models, tms...)
+	if err := checkInserts(db.models, copies); err != nil {
+		return err
+	}
+	if db.foreignKeys {
+		if err := checkForeignKeys(db.models, copies); err != nil {
+			return err
+		}
+	}
+	// The copies are stored, so later changes to the models do not change the
+	// stored rows
+	models, err := insertModels(db.models, copies)
+	if err != nil {
+		return err
+	}
+	returnInserted(model, copies)
+	db.models = models
 	return nil
 }
 
-// Update finds a model in the models slice based on its GetID() and updates it,
-// or returns an error if it is not found
-func (db *MockDB) Update(model interface{}) error {
-	for i, r := range db.models {
-		m, ok := r.(Model)
-		if !ok {
-			return fmt.Errorf("model is not a Model: %v", r)
+// prepareInserts returns copies of the models passed to Insert with their
+// primary keys generated and defaults filled in, so the models themselves are
+// only changed once the copies have passed the checks
+func (db *MockDB) prepareInserts(model []interface{}, now time.Time) ([]interface{}, error) {
+	copies := copyModels(model)
+	if err := db.generateKeys(copies); err != nil {
+		return nil, err
+	}
+	db.applyDefaults(copies, now)
+	return copies, nil
+}
+
+// returnInserted writes the columns generated for the copies made by
+// prepareInserts back into the zero columns of the models, like go-pg does
+// with RETURNING
+func returnInserted(model, copies []interface{}) {
+	for i, m := range model {
+		v := reflect.ValueOf(m)
+		if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+			continue
 		}
-		if reflect.TypeOf(m) == reflect.TypeOf(model) && m.GetID() == model.(Model).GetID() {
-			reflect.ValueOf(db.models[i]).Elem().Set(reflect.ValueOf(model).Elem())
-			return nil
+		c := reflect.ValueOf(copies[i]).Elem()
+		for _, col := range getTable(v.Type()).columns {
+			f, cf := col.value(v.Elem()), col.value(c)
+			if f.IsValid() && cf.IsValid() && f.IsZero() {
+				f.Set(copyValue(cf, map[copyKey]reflect.Value{}))
+			}
 		}
 	}
-	return fmt.Errorf("%s model with ID %s not found to update",
-		reflect.TypeOf(model).String(),
-		model.(Model).GetID())
+}
+
+// Update sets the model's updated_at column, if it has one, then finds a model
+// in the models slice based on its ID and updates it, or returns an error if it
+// is not found
+func (db *MockDB) Update(model interface{}) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	err := db.injectedError("Update", []interface{}{model}, nil)
+	if err == nil {
+		err = db.update(model, true)
+	}
+	db.record(0, "Update", model, nil, nil, writeResult(1, err), err)
+	return err
+}
+
+// update serves Update, the Update method of MockQuery and UPDATE statements,
+// setting updated_at if touch is true
+func (db *MockDB) update(model interface{}, touch bool) error {
+	// Touch a copy, so the model is only changed if the update succeeds
+	now := db.now()
+	updated := model
+	if touch {
+		updated = deepCopy(model)
+		touchModel(updated, now)
+	}
+	if err := checkUpdate(db.models, updated); err != nil {
+		return err
+	}
+	if db.foreignKeys {
+		if err := checkForeignKeys(db.models, []interface{}{updated}); err != nil {
+			return err
+		}
+	}
+	if err := updateModel(db.models, updated); err != nil {
+		return err
+	}
+	if touch {
+		touchModel(model, now)
+	}
+	return nil
 }
 
 // Delete finds a model in the DB and removes it, or returns an error if it is
-// not found
+// not found. A model with a soft_delete column is kept and its column is set to
+// the time now instead, which hides it from Find, Select, Update and Delete.
 func (db *MockDB) Delete(model interface{}) error {
-	for i, r := range db.models {
-		m, ok := r.(Model)
-		if !ok {
-			return fmt.Errorf("model is not a Model: %v", r)
-		}
-		if reflect.TypeOf(m) == reflect.TypeOf(model) && m.GetID() == model.(Model).GetID() {
-			db.models = append(db.models[:i], db.models[i+1:]...)
-			return nil
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	err := db.injectedError("Delete", []interface{}{model}, nil)
+	if err == nil {
+		err = db.delete(model, false)
+	}
+	db.record(0, "Delete", model, nil, nil, writeResult(1, err), err)
+	return err
+}
+
+// delete serves Delete, ForceDelete and the Delete and ForceDelete methods of
+// MockQuery
+func (db *MockDB) delete(model interface{}, force bool) error {
+	now := db.now()
+	models, err := db.deleteRows(db.models, model, now, force)
+	db.models = models
+	if err == nil && !force {
+		markDeleted(model, now)
+	}
+	return err
+}
+
+// statement makes the changes of a statement affecting many rows, in the
+// transaction if tx is not nil, undoing them all if any fails like a single
+// statement in Postgres
+func (db *MockDB) statement(tx *MockTx, fn func() error) error {
+	models := db.models
+	var writes int
+	if tx != nil {
+		models = tx.models
+		writes = len(tx.writes)
+	}
+	models = append([]interface{}(nil), models...)
+	copies := copyModels(models)
+	err := fn()
+	if err == nil {
+		return nil
+	}
+	for i, m := range models {
+		if v := reflect.ValueOf(m); v.Kind() == reflect.Ptr && !v.IsNil() {
+			v.Elem().Set(reflect.ValueOf(copies[i]).Elem())
 		}
 	}
-	return fmt.Errorf("%s model with ID %s not found to delete",
-		reflect.TypeOf(model).String(),
-		model.(Model).GetID())
+	if tx != nil {
+		tx.models = models
+		tx.writes = tx.writes[:writes]
+	} else {
+		db.models = models
+	}
+	return err
 }
 
 // Find searches through the MockDB models and returns a model of matching type
-// and ID if it exists, or nil if not.
+// and ID if it exists and is not soft deleted, or nil if not
 func (db *MockDB) Find(model Model) (Model, error) {
-	for _, r := range db.models {
-		if reflect.TypeOf(r) == reflect.TypeOf(model) && r.GetID() == model.(Model).GetID() {
-			return r, nil
-		}
+	found, err := db.FindAny(model)
+	if found == nil || err != nil {
+		return nil, err
 	}
-	return nil, nil
+	return found.(Model), nil
+}
+
+// FindAny is Find for any model, including those identified by their pk tags
+// rather than GetID. It returns an error if the model has no primary key.
+func (db *MockDB) FindAny(model interface{}) (interface{}, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	found, err := findModel(db.models, model)
+	if found == nil || err != nil || !db.loadOnSelect {
+		return found, err
+	}
+	if v := reflect.ValueOf(found); v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return found, nil
+	}
+	// Relations are loaded into a copy, so the stored model is unchanged
+	found = deepCopy(found)
+	return found, loadRelations(db.models, found, nil)
 }
 
 // MarshalModels returns a pretty string of JSON for logging out the contents of
 // the MockDB models
 func (db *MockDB) MarshalModels() (string, error) {
-	bytes, err := json.MarshalIndent(db.models, "", "  ")
-	if err != nil {
-		return "", err
-	}
-	return string(bytes), nil
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return marshalIndent(db.models)
 }
 
 // MarshalModels returns a pretty string of JSON for logging out the contents of
 // the MockDB responses
 func (db *MockDB) MarshalResponses() (string, error) {
-	bytes, err := json.MarshalIndent(db.responses, "", "  ")
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return marshalIndent(db.responses)
+}
+
+// marshalIndent returns a pretty string of JSON for v
+func marshalIndent(v interface{}) (string, error) {
+	bytes, err := json.MarshalIndent(v, "", "  ")
 	if err != nil {
 		return "", err
 	}
 	return string(bytes), nil
-}
\ No newline at end of file
+}
+
+// insertModels appends the models passed to Insert to models. Each must be a
+// pointer to a struct so it can be updated in place, unless it implements Model.
+func insertModels(models []interface{}, model []interface{}) ([]interface{}, error) {
+	for _, m := range model {
+		if _, ok := m.(Model); ok {
+			continue
+		}
+		v := reflect.ValueOf(m)
+		if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+			return models, fmt.Errorf("testutils: Insert(%T) needs a pointer to a struct", m)
+		}
+	}
+	return append(models, model...), nil
+}
+
+// findModel returns the model in models with the same type and primary key as
+// model, or nil. Soft deleted models are not found.
+func findModel(models []interface{}, model interface{}) (interface{}, error) {
+	i, err := indexModel(models, model, false)
+	if err != nil || i < 0 {
+		return nil, err
+	}
+	return models[i], nil
+}
+
+// indexModel returns the index in models of the model with the same type and
+// primary key as model, or -1. Soft deleted models are skipped unless
+// withDeleted is true.
+func indexModel(models []interface{}, model interface{}, withDeleted bool) (int, error) {
+	key, err := KeyOf(model)
+	if err != nil {
+		return -1, err
+	}
+	for i, r := range models {
+		if reflect.TypeOf(r) != reflect.TypeOf(model) || (!withDeleted && isSoftDeleted(r)) {
+			continue
+		}
+		if rkey, err := KeyOf(r); err == nil && rkey.Equal(key) {
+			return i, nil
+		}
+	}
+	return -1, nil
+}
+
+// notFoundError returns the error of an Update or Delete of a model which is
+// not in models
+func notFoundError(model interface{}, action string) error {
+	key, _ := KeyOf(model)
+	return fmt.Errorf("%s model with ID %s not found to %s",
+		reflect.TypeOf(model).String(), key, action)
+}
+
+// updateModel finds a model in models based on its primary key and updates it,
+// or returns an error if it is not found
+func updateModel(models []interface{}, model interface{}) error {
+	i, err := indexModel(models, model, false)
+	if err != nil {
+		return err
+	}
+	if i < 0 {
+		return notFoundError(model, "update")
+	}
+	dst := reflect.ValueOf(models[i])
+	if dst.Kind() != reflect.Ptr {
+		models[i] = deepCopy(model)
+		return nil
+	}
+	dst.Elem().Set(reflect.Indirect(reflect.ValueOf(deepCopy(model))))
+	return nil
+}
+
+// deleteModel finds a model in models and returns models without it, or
+// returns an error if it is not found. A model with a soft_delete column is
+// kept and marked deleted at now instead.
+func deleteModel(models []interface{}, model interface{}, now time.Time) ([]interface{}, error) {
+	i, err := indexModel(models, model, false)
+	if err != nil {
+		return models, err
+	}
+	if i < 0 {
+		return models, notFoundError(model, "delete")
+	}
+	if markDeleted(models[i], now) {
+		return models, nil
+	}
+	return append(models[:i], models[i+1:]...), nil
+}
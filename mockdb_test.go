@@ -2,7 +2,10 @@ package testutils
 
 import (
 	"reflect"
+	"strconv"
 	"testing"
+
+	"github.com/go-pg/pg/v9"
 )
 
 type TestModel struct {
@@ -11,7 +14,7 @@ type TestModel struct {
 }
 
 func (tm *TestModel) GetID() string {
-	return string((*tm).ID)
+	return strconv.Itoa(tm.ID)
 }
 
 func (tm *TestModel) Equals(i interface{}) bool {
@@ -62,7 +65,7 @@ func TestMockDB(t *testing.T) {
 		if rT != eT {
 			t.Fatal("expected model in queue of type ", eT.String(), "; found ", rT.String())
 		}
-		if db.models[0].GetID() != tm.GetID() {
+		if db.models[0].(*TestModel).GetID() != tm.GetID() {
 			t.Fatalf("model in queue (%v) does not match expected (%v)", db.models[0], tm)
 		}
 	})
@@ -106,9 +109,9 @@ func TestMockDB(t *testing.T) {
 	t.Run("Select", func(t *testing.T) {
 		tm := &TestModel{ID: 1, Name: "Test Model"}
 		emptyModel := &TestModel{}
-		db := &MockDB{responses: []interface{}{*tm}}
+		db := &MockDB{models: []interface{}{&TestModel{ID: 2}, tm}}
 
-		response := &TestModel{}
+		response := &TestModel{ID: 1}
 		err := db.Select(response)
 		if err != nil {
 			t.Fatal(err)
@@ -116,9 +119,34 @@ func TestMockDB(t *testing.T) {
 		if response.Equals(emptyModel) {
 			t.Fatal("Returned TestModel is empty")
 		}
+		if !response.Equals(tm) {
+			t.Fatal("response struct doesn't match stored model")
+		}
+		if response == tm {
+			t.Fatal("Select should copy the stored model")
+		}
+
+		if err := db.Select(&TestModel{ID: 3}); err != pg.ErrNoRows {
+			t.Fatal("expected pg.ErrNoRows for a missing model; found ", err)
+		}
+	})
+
+	t.Run("Select from responses", func(t *testing.T) {
+		tm := &TestModel{ID: 1, Name: "Test Model"}
+		db := &MockDB{responses: []interface{}{*tm}}
+		db.SetSelectFromResponses(true)
+
+		response := &TestModel{}
+		err := db.Select(response)
+		if err != nil {
+			t.Fatal(err)
+		}
 		if !response.Equals(tm) {
 			t.Fatal("response struct doesn't match queued response")
 		}
+		if len(db.responses) != 0 {
+			t.Fatal("Select should consume the queued response")
+		}
 	})
 
 	t.Run("Insert", func(t *testing.T) {
@@ -138,29 +166,46 @@ func TestMockDB(t *testing.T) {
 		if rT != eT {
 			t.Fatal("expected model in queue of type ", eT.String(), "; found ", rT.String())
 		}
-		iM := db.models[0]
+		iM := db.models[0].(*TestModel)
 		if !iM.Equals(tm) {
 			t.Fatalf("MockDB model (%v) does not match expected (%v)", iM, tm)
 		}
 	})
 
+	t.Run("Inserted copies", func(t *testing.T) {
+		tm := &TestModel{ID: 1, Name: "Test Model"}
+		db := NewMockDB()
+		if err := db.Insert(tm); err != nil {
+			t.Fatal(err)
+		}
+		tm.Name = "Changed after insert"
+
+		fm, err := db.Find(&TestModel{ID: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fm == nil || fm.(*TestModel).Name != "Test Model" {
+			t.Fatalf("changes to a model after it was inserted should not be stored; found %v", fm)
+		}
+	})
+
 	t.Run("Update", func(t *testing.T) {
 		tm := &TestModel{ID: 1, Name: "Test Model"}
-		db := &MockDB{models: []Model{tm}}
+		db := &MockDB{models: []interface{}{tm}}
 
 		tm2 := &TestModel{ID: 1, Name: "Updated Model"}
 		if err := db.Update(tm2); err != nil {
 			t.Fatal(err)
 		}
-		um := db.models[0]
+		um := db.models[0].(*TestModel)
 		if !um.Equals(tm2) {
-			t.Fatalf("MockDB model (%v) does not match expected (%v)", *um.(*TestModel), *tm)
+			t.Fatalf("MockDB model (%v) does not match expected (%v)", *um, *tm)
 		}
 	})
 
 	t.Run("Delete", func(t *testing.T) {
 		tm := &TestModel{ID: 1, Name: "Test Model"}
-		db := &MockDB{models: []Model{tm}}
+		db := &MockDB{models: []interface{}{tm}}
 
 		if err := db.Delete(tm); err != nil {
 			t.Fatal(err)
@@ -172,7 +217,7 @@ func TestMockDB(t *testing.T) {
 
 	t.Run("Find", func(t *testing.T) {
 		tm := &TestModel{ID: 1, Name: "Test Model"}
-		db := &MockDB{models: []Model{tm}}
+		db := &MockDB{models: []interface{}{tm}}
 
 		fm, err := db.Find(tm)
 		if err != nil {
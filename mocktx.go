@@ -1,126 +1,461 @@
 package testutils
 
 import (
-	"encoding/json"
 	"fmt"
 	"reflect"
+	"time"
 
 	"github.com/go-pg/pg/v9"
 )
 
-// MockTx implements the Tx interface to mock a pg.Tx instance
+// MockTx implements the Tx interface to mock a pg.Tx instance. Transactions
+// have repeatable read isolation: a transaction works on deep copies of the
+// MockDB's models taken when it begins, so its changes are invisible to the
+// MockDB and other transactions until it is committed, and changes committed by
+// others are invisible to it. Committing an update or delete of a row which
+// another transaction has changed or deleted since it began is a serialization
+// failure.
 type MockTx struct {
-	db   *MockDB
-	open bool
-	models  []Model
+	id     int
+	db     *MockDB
+	state  TxState
+	models []interface{}
+	writes []txWrite
+	// snapshot holds copies of the MockDB's models as they were when the
+	// transaction began, to detect rows changed by others before it commits
+	snapshot []interface{}
+	// start is the time the transaction began, which Postgres's now() returns
+	// throughout it
+	start time.Time
+
+	savepoints []savepoint
+	nested     int
+	recovered  interface{}
+}
+
+// TxState is the state of a MockTx
+type TxState int
+
+const (
+	// TxOpen is the state of a transaction which has not been committed or
+	// rolled back
+	TxOpen TxState = iota
+
+	// TxCommitted is the state of a committed transaction
+	TxCommitted
+
+	// TxRolledBack is the state of a rolled back transaction
+	TxRolledBack
+)
+
+// String returns the name of the state
+func (s TxState) String() string {
+	switch s {
+	case TxOpen:
+		return "open"
+	case TxCommitted:
+		return "committed"
+	case TxRolledBack:
+		return "rolled back"
+	default:
+		return fmt.Sprintf("TxState(%d)", int(s))
+	}
+}
+
+// txWrite is an Insert, Update or Delete made in a transaction, which is
+// applied to the MockDB when the transaction is committed. The model is a copy
+// owned by the txWrite.
+type txWrite struct {
+	method string
+	model  interface{}
 }
 
+// RunInTransaction runs a function in a nested transaction, using a savepoint.
+// If function returns an error, the changes it made are rolled back without
+// affecting the rest of the transaction, otherwise they are kept. If function
+// panics, the whole transaction is rolled back and the panic continues.
 func (tx *MockTx) RunInTransaction(fn func(tx *MockTx) error) error {
-	if err := fn(tx); err != nil {
+	tx.db.mu.Lock()
+	tx.nested++
+	name := fmt.Sprintf("nested_%d", tx.nested)
+	err := tx.savepoint(name)
+	tx.db.mu.Unlock()
+	if err != nil {
 		return err
 	}
-	if err := tx.Commit(); err != nil {
+
+	defer func() {
+		if p := recover(); p != nil {
+			tx.rollbackOnPanic(p)
+			panic(p)
+		}
+	}()
+	if err := fn(tx); err != nil {
+		_ = tx.RollbackTo(name)
+		_ = tx.Release(name)
 		return err
 	}
-	if err := tx.Close(); err != nil {
-		return err
+	return tx.Release(name)
+}
+
+// rollbackOnPanic rolls back the transaction because a function running in it
+// panicked with the value p
+func (tx *MockTx) rollbackOnPanic(p interface{}) {
+	tx.db.mu.Lock()
+	defer tx.db.mu.Unlock()
+	if tx.recovered == nil {
+		tx.recovered = p
+	}
+	if tx.state == TxOpen {
+		_ = tx.rollback()
 	}
+}
 
+// RecoveredPanic returns the value of the panic which made RunInTransaction
+// roll back the transaction, or nil if there was none
+func (tx *MockTx) RecoveredPanic() interface{} {
+	tx.db.mu.Lock()
+	defer tx.db.mu.Unlock()
+	return tx.recovered
+}
+
+// State returns whether the transaction is open, committed or rolled back
+func (tx *MockTx) State() TxState {
+	tx.db.mu.Lock()
+	defer tx.db.mu.Unlock()
+	return tx.state
+}
+
+// checkOpen returns pg.ErrTxDone if the transaction has been committed or
+// rolled back
+func (tx *MockTx) checkOpen() error {
+	if tx.state != TxOpen {
+		return pg.ErrTxDone
+	}
 	return nil
 }
 
+// ID returns the number of the transaction, which starts at 1 for the first
+// transaction of a MockDB. Calls made in the transaction are recorded with it.
+func (tx *MockTx) ID() int {
+	return tx.id
+}
+
 // Query is an alias for DB.Query
 func (tx *MockTx) Query(model interface{}, query interface{}, params ...interface{}) (pg.Result, error) {
-	return tx.db.Query(model, query, params...)
+	tx.db.mu.Lock()
+	defer tx.db.mu.Unlock()
+	var res pg.Result
+	err := tx.checkOpen()
+	if err == nil {
+		res, err = tx.db.query(tx, "Query", model, query, params)
+	}
+	tx.db.record(tx.id, "Query", model, query, params, res, err)
+	return res, err
 }
 
 // QueryOne is an alias for DB.QueryOne
 func (tx *MockTx) QueryOne(model interface{}, query interface{}, params ...interface{}) (pg.Result, error) {
-	return tx.db.QueryOne(model, query, params...)
+	tx.db.mu.Lock()
+	defer tx.db.mu.Unlock()
+	var res pg.Result
+	err := tx.checkOpen()
+	if err == nil {
+		res, err = tx.db.query(tx, "QueryOne", model, query, params)
+	}
+	tx.db.record(tx.id, "QueryOne", model, query, params, res, err)
+	return res, err
+}
+
+// Exec is an alias for DB.Exec
+func (tx *MockTx) Exec(query interface{}, params ...interface{}) (pg.Result, error) {
+	tx.db.mu.Lock()
+	defer tx.db.mu.Unlock()
+	var res pg.Result
+	err := tx.checkOpen()
+	if err == nil {
+		res, err = tx.db.query(tx, "Exec", nil, query, params)
+	}
+	tx.db.record(tx.id, "Exec", nil, query, params, res, err)
+	return res, err
 }
 
 // Select is an alias for DB.Select
 func (tx *MockTx) Select(model interface{}) error {
-	return tx.db.Select(model)
+	tx.db.mu.Lock()
+	defer tx.db.mu.Unlock()
+	err := tx.checkOpen()
+	if err == nil {
+		err = tx.db.selectModel(tx.models, model)
+	}
+	tx.db.record(tx.id, "Select", model, nil, nil, nil, err)
+	return err
 }
 
 // Insert is an alias for DB.Insert
 func (tx *MockTx) Insert(model ...interface{}) error {
-	// return tx.db.Insert(model...)
-	tms := make([]Model, len(model))
-	for i, m := range model {
-	tms[i] = m.(Model)
+	tx.db.mu.Lock()
+	defer tx.db.mu.Unlock()
+	err := tx.checkOpen()
+	if err == nil {
+		err = tx.db.injectedError("Insert", model, nil)
+	}
+	if err == nil {
+		err = tx.insert(model)
+	}
+	tx.db.record(tx.id, "Insert", firstModel(model), nil, nil, writeResult(len(model), err), err)
+	return err
+}
+
+// insert serves Insert and the Insert method of MockQuery
+func (tx *MockTx) insert(model []interface{}) error {
+	copies, err := tx.db.prepareInserts(model, tx.start)
+	if err != nil {
+		return err
+	}
+	if err := checkInserts(tx.models, copies); err != nil {
+		return err
+	}
+	if tx.db.foreignKeys {
+		if err := checkForeignKeys(tx.models, copies); err != nil {
+			return err
+		}
+	}
+	models, err := insertModels(tx.models, copies)
+	if err != nil {
+		return err
+	}
+	returnInserted(model, copies)
+	tx.models = models
+	for _, m := range copies {
+		tx.writes = append(tx.writes, txWrite{"Insert", deepCopy(m)})
 	}
-	tx.models = append(tx.models, tms...)
 	return nil
 }
 
 // Update is an alias for DB.Update
 func (tx *MockTx) Update(model interface{}) error {
-	// return tx.db.Update(model)
-	for i, r := range tx.models {
-		m, ok := r.(Model)
-		if !ok {
-			return fmt.Errorf("model is not a Model: %v", r)
-		}
-		if reflect.TypeOf(m) == reflect.TypeOf(model) && m.GetID() == model.(Model).GetID() {
-			reflect.ValueOf(tx.models[i]).Elem().Set(reflect.ValueOf(model).Elem())
-			return nil
+	tx.db.mu.Lock()
+	defer tx.db.mu.Unlock()
+	err := tx.checkOpen()
+	if err == nil {
+		err = tx.db.injectedError("Update", []interface{}{model}, nil)
+	}
+	if err == nil {
+		err = tx.update(model, true)
+	}
+	tx.db.record(tx.id, "Update", model, nil, nil, writeResult(1, err), err)
+	return err
+}
+
+// update serves Update, the Update method of MockQuery and UPDATE statements,
+// setting updated_at if touch is true
+func (tx *MockTx) update(model interface{}, touch bool) error {
+	// Touch a copy, so the model is only changed if the update succeeds
+	updated := model
+	if touch {
+		updated = deepCopy(model)
+		touchModel(updated, tx.start)
+	}
+	if err := checkUpdate(tx.models, updated); err != nil {
+		return err
+	}
+	if tx.db.foreignKeys {
+		if err := checkForeignKeys(tx.models, []interface{}{updated}); err != nil {
+			return err
 		}
 	}
-	return fmt.Errorf("%s model with ID %s not found to update",
-		reflect.TypeOf(model).String(),
-		model.(Model).GetID())
+	if err := updateModel(tx.models, updated); err != nil {
+		return err
+	}
+	if touch {
+		touchModel(model, tx.start)
+	}
+	tx.writes = append(tx.writes, txWrite{"Update", deepCopy(updated)})
+	return nil
 }
 
 // Delete is an alias for DB.Delete
 func (tx *MockTx) Delete(model interface{}) error {
-	// return tx.db.Delete(model)
-	for i, r := range tx.models {
-		m, ok := r.(Model)
-		if !ok {
-			return fmt.Errorf("model is not a Model: %v", r)
+	tx.db.mu.Lock()
+	defer tx.db.mu.Unlock()
+	err := tx.checkOpen()
+	if err == nil {
+		err = tx.db.injectedError("Delete", []interface{}{model}, nil)
+	}
+	if err == nil {
+		err = tx.delete(model, false)
+	}
+	tx.db.record(tx.id, "Delete", model, nil, nil, writeResult(1, err), err)
+	return err
+}
+
+// delete serves Delete, ForceDelete and the Delete and ForceDelete methods of
+// MockQuery
+func (tx *MockTx) delete(model interface{}, force bool) error {
+	models, err := tx.db.deleteRows(tx.models, model, tx.start, force)
+	if err != nil {
+		return err
+	}
+	tx.models = models
+	method := "Delete"
+	if force {
+		method = "ForceDelete"
+	} else {
+		markDeleted(model, tx.start)
+	}
+	tx.writes = append(tx.writes, txWrite{method, deepCopy(model)})
+	return nil
+}
+
+// Commit commits the transaction. It returns pg.ErrTxDone if the transaction
+// has already been committed or rolled back. If its writes conflict with
+// changes committed since it began, none are applied, the transaction is rolled
+// back and the error is returned.
+func (tx *MockTx) Commit() error {
+	tx.db.mu.Lock()
+	defer tx.db.mu.Unlock()
+	err := tx.checkOpen()
+	if err == nil {
+		err = tx.db.injectedError("Commit", nil, nil)
+	}
+	if err != nil {
+		tx.db.record(tx.id, "Commit", nil, nil, nil, nil, err)
+		return err
+	}
+	// Apply the writes to the current models, rather than replacing them with
+	// the transaction's models, to keep changes committed by other
+	// transactions in the meantime
+	err = tx.db.statement(nil, func() error {
+		for _, w := range tx.writes {
+			if err := tx.checkConcurrent(w); err != nil {
+				return err
+			}
 		}
-		if reflect.TypeOf(m) == reflect.TypeOf(model) && m.GetID() == model.(Model).GetID() {
-			tx.models = append(tx.models[:i], tx.models[i+1:]...)
-			return nil
+		for _, w := range tx.writes {
+			if err := tx.commitWrite(w); err != nil {
+				return err
+			}
 		}
+		return nil
+	})
+	tx.db.record(tx.id, "Commit", nil, nil, nil, nil, err)
+	if err != nil {
+		tx.finish(TxRolledBack)
+		return err
 	}
-	return fmt.Errorf("%s model with ID %s not found to delete",
-		reflect.TypeOf(model).String(),
-		model.(Model).GetID())
+	tx.finish(TxCommitted)
+	return nil
 }
 
-// Commit commits the transaction.
-func (tx *MockTx) Commit() error {
-	tx.db.models = tx.models
-	// copy(tx.db.models, tx.models)
-	tx.db.models = append(tx.models)
-	tx.models = nil
+// checkConcurrent returns a serialization failure if a write of the
+// transaction updates or deletes a row which has been changed or deleted in the
+// MockDB since the transaction began, as Postgres does in a repeatable read
+// transaction. Rows inserted by the transaction itself are not checked.
+func (tx *MockTx) checkConcurrent(w txWrite) error {
+	if w.method == "Insert" {
+		return nil
+	}
+	i, err := indexModel(tx.snapshot, w.model, true)
+	if err != nil || i < 0 {
+		return err
+	}
+	j, err := indexModel(tx.db.models, w.model, true)
+	if err != nil {
+		return err
+	}
+	if j < 0 || !reflect.DeepEqual(tx.db.models[j], tx.snapshot[i]) {
+		return SerializationFailure()
+	}
 	return nil
 }
 
-// Rollback aborts the transaction.
+// commitWrite applies a write of the transaction to the MockDB's models,
+// checking it against them like the write itself was checked against the
+// transaction's
+func (tx *MockTx) commitWrite(w txWrite) error {
+	db := tx.db
+	switch w.method {
+	case "Insert":
+		model := []interface{}{w.model}
+		if err := checkInserts(db.models, model); err != nil {
+			return err
+		}
+		if db.foreignKeys {
+			if err := checkForeignKeys(db.models, model); err != nil {
+				return err
+			}
+		}
+		models, err := insertModels(db.models, model)
+		db.models = models
+		return err
+	case "Update":
+		if err := checkUpdate(db.models, w.model); err != nil {
+			return err
+		}
+		if db.foreignKeys {
+			if err := checkForeignKeys(db.models, []interface{}{w.model}); err != nil {
+				return err
+			}
+		}
+		return updateModel(db.models, w.model)
+	case "Delete", "ForceDelete":
+		force := w.method == "ForceDelete"
+		models, err := db.deleteRows(db.models, w.model, tx.start, force)
+		db.models = models
+		return err
+	}
+	return nil
+}
+
+// Rollback aborts the transaction. It returns pg.ErrTxDone if the transaction
+// has already been committed or rolled back.
 func (tx *MockTx) Rollback() error {
+	tx.db.mu.Lock()
+	defer tx.db.mu.Unlock()
+	return tx.rollback()
+}
+
+// rollback discards the changes made in the transaction
+func (tx *MockTx) rollback() error {
+	err := tx.checkOpen()
+	if err != nil {
+		tx.db.record(tx.id, "Rollback", nil, nil, nil, nil, err)
+		return err
+	}
+	err = tx.db.injectedError("Rollback", nil, nil)
+	tx.db.record(tx.id, "Rollback", nil, nil, nil, nil, err)
+	tx.finish(TxRolledBack)
+	return err
+}
+
+// finish ends the transaction in the given state
+func (tx *MockTx) finish(state TxState) {
+	tx.state = state
 	tx.models = nil
-	return nil
+	tx.writes = nil
+	tx.savepoints = nil
 }
 
 // Close calls Rollback if the tx has not already been committed or rolled back.
 func (tx *MockTx) Close() error {
-	defer func() { tx.open = false }()
-	if tx.open {
-		return tx.Rollback()
+	tx.db.mu.Lock()
+	defer tx.db.mu.Unlock()
+	if tx.state == TxOpen {
+		return tx.rollback()
 	}
 	return nil
 }
 
+// Calls returns the calls made in the transaction, in the order they were made
+func (tx *MockTx) Calls() Calls {
+	return tx.db.Calls().InTx(tx.id)
+}
+
 // MarshalModels returns a pretty string of JSON for logging out the contents of
 // the MockTx models
 func (tx *MockTx) MarshalModels() (string, error) {
-	bytes, err := json.MarshalIndent(tx.models, "", "  ")
-	if err != nil {
-		return "", err
-	}
-	return string(bytes), nil
+	tx.db.mu.Lock()
+	defer tx.db.mu.Unlock()
+	return marshalIndent(tx.models)
 }
@@ -1,8 +1,11 @@
 package testutils
 
 import (
+	"errors"
 	"reflect"
 	"testing"
+
+	"github.com/go-pg/pg/v9"
 )
 
 func TestMockTx(t *testing.T) {
@@ -59,11 +62,11 @@ func TestMockTx(t *testing.T) {
 
 	t.Run("Select", func(t *testing.T) {
 		tm := &TestModel{ID: 1, Name: "Test Model"}
-		db := &MockDB{responses: []interface{}{*tm}}
-		response := &TestModel{}
+		db := &MockDB{models: []interface{}{tm}}
+		response := &TestModel{ID: 1}
 
 		err := db.RunInTransaction(func(tx Tx) error {
-			err := db.Select(response)
+			err := tx.Select(response)
 			return err
 		})
 
@@ -74,7 +77,7 @@ func TestMockTx(t *testing.T) {
 			t.Fatal("Returned TestModel is empty")
 		}
 		if !response.Equals(tm) {
-			t.Fatal("response struct doesn't match queued response")
+			t.Fatal("response struct doesn't match stored model")
 		}
 	})
 
@@ -99,7 +102,7 @@ func TestMockTx(t *testing.T) {
 		if rT != eT {
 			t.Fatal("expected model in queue of type ", eT.String(), "; found ", rT.String())
 		}
-		iM := db.models[0]
+		iM := db.models[0].(*TestModel)
 		if !iM.Equals(tm) {
 			t.Fatalf("MockDB model (%v) does not match expected (%v)", iM, *tm)
 		}
@@ -107,7 +110,7 @@ func TestMockTx(t *testing.T) {
 
 	t.Run("Update", func(t *testing.T) {
 		tm := &TestModel{ID: 1, Name: "Test Model"}
-		db := MockDB{models: []Model{tm}}
+		db := MockDB{models: []interface{}{tm}}
 
 		um := &TestModel{ID: 1, Name:"Updated Model"}
 		err := db.RunInTransaction(func (tx Tx) error {
@@ -117,7 +120,7 @@ func TestMockTx(t *testing.T) {
 		if err != nil {
 			t.Fatal(err)
 		}
-		fm := db.models[0]
+		fm := db.models[0].(*TestModel)
 		if !fm.Equals(um) {
 			t.Fatalf("MockDB model (%v) does not match expected (%v)", fm, um)
 		}
@@ -125,7 +128,7 @@ func TestMockTx(t *testing.T) {
 
 	t.Run("Delete", func(t *testing.T) {
 		tm := &TestModel{ID: 1, Name: "Test Model"}
-		db := MockDB{models: []Model{tm}}
+		db := MockDB{models: []interface{}{tm}}
 
 		err := db.RunInTransaction(func (tx Tx) error {
 			err := tx.Delete(tm)
@@ -138,4 +141,283 @@ func TestMockTx(t *testing.T) {
 			t.Fatal("MockDB.models should be empty")
 		}
 	})
+
+	t.Run("Isolation", func(t *testing.T) {
+		tm := &TestModel{ID: 1, Name: "Test Model"}
+		db := &MockDB{models: []interface{}{tm}}
+
+		tx, _ := db.Begin()
+		other, _ := db.Begin()
+		if err := tx.Update(&TestModel{ID: 1, Name: "Updated Model"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := tx.Insert(&TestModel{ID: 2, Name: "Inserted Model"}); err != nil {
+			t.Fatal(err)
+		}
+		if tm.Name != "Test Model" || len(db.models) != 1 {
+			t.Fatal("uncommitted changes should be invisible to the MockDB")
+		}
+		response := &TestModel{ID: 1}
+		if err := other.Select(response); err != nil {
+			t.Fatal(err)
+		}
+		if response.Name != "Test Model" {
+			t.Fatal("uncommitted changes should be invisible to other transactions")
+		}
+
+		if err := tx.Commit(); err != nil {
+			t.Fatal(err)
+		}
+		if tm.Name != "Updated Model" || len(db.models) != 2 {
+			t.Fatal("committed changes should be visible to the MockDB")
+		}
+		if err := other.Select(response); err != nil {
+			t.Fatal(err)
+		}
+		if response.Name != "Test Model" {
+			t.Fatal("changes committed after a transaction began should be invisible to it")
+		}
+		_ = other.Rollback()
+	})
+
+	t.Run("Commit conflicts", func(t *testing.T) {
+		tm := &TestModel{ID: 1, Name: "Test Model"}
+		db := &MockDB{models: []interface{}{tm}}
+
+		tx, _ := db.Begin()
+		other, _ := db.Begin()
+		if err := tx.Insert(&TestModel{ID: 2, Name: "First"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := other.Update(&TestModel{ID: 1, Name: "Updated Model"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := other.Insert(&TestModel{ID: 2, Name: "Second"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := tx.Commit(); err != nil {
+			t.Fatal(err)
+		}
+		err := other.Commit()
+		if errCode(err) != CodeUniqueViolation {
+			t.Fatalf("expected a unique violation; found %v", err)
+		}
+		if other.State() != TxRolledBack {
+			t.Fatalf("expected the failed transaction to be rolled back; found %s", other.State())
+		}
+		if len(db.models) != 2 || tm.Name != "Test Model" || db.models[1].(*TestModel).Name != "First" {
+			t.Fatalf("no write of a failed commit should be applied; found %v", db.models)
+		}
+
+		tx, _ = db.Begin()
+		other, _ = db.Begin()
+		if err := tx.Delete(&TestModel{ID: 2}); err != nil {
+			t.Fatal(err)
+		}
+		if err := other.Update(&TestModel{ID: 2, Name: "Updated Model"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := tx.Commit(); err != nil {
+			t.Fatal(err)
+		}
+		err = other.Commit()
+		if errCode(err) != CodeSerializationFailure {
+			t.Fatalf("expected a serialization failure; found %v", err)
+		}
+
+		tx, _ = db.Begin()
+		other, _ = db.Begin()
+		if err := tx.Update(&TestModel{ID: 1, Name: "First"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := other.Update(&TestModel{ID: 1, Name: "Second"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := tx.Commit(); err != nil {
+			t.Fatal(err)
+		}
+		err = other.Commit()
+		if errCode(err) != CodeSerializationFailure {
+			t.Fatalf("expected a serialization failure for a concurrent update; found %v", err)
+		}
+		if db.models[0].(*TestModel).Name != "First" {
+			t.Fatalf("the first committed update should be kept; found %v", db.models[0])
+		}
+	})
+
+	t.Run("RunInTransaction commit conflicts", func(t *testing.T) {
+		db := &MockDB{models: []interface{}{&TestModel{ID: 1, Name: "Test Model"}}}
+
+		other, _ := db.Begin()
+		err := db.RunInTransaction(func(tx Tx) error {
+			if err := other.Delete(&TestModel{ID: 1}); err != nil {
+				return err
+			}
+			if err := other.Commit(); err != nil {
+				return err
+			}
+			return tx.Update(&TestModel{ID: 1, Name: "Updated Model"})
+		})
+		if errCode(err) != CodeSerializationFailure {
+			t.Fatalf("expected a serialization failure; found %v", err)
+		}
+		tx := db.Transactions()[1]
+		if calls := tx.Calls(); len(calls) != 2 || calls[1].Method != "Commit" {
+			t.Fatalf("a failed Commit should not be followed by a Rollback; found %v", calls)
+		}
+	})
+
+	t.Run("Rollback", func(t *testing.T) {
+		tm := &TestModel{ID: 1, Name: "Test Model"}
+		db := &MockDB{models: []interface{}{tm}}
+
+		tx, _ := db.Begin()
+		inserted := &TestModel{ID: 2, Name: "Inserted Model"}
+		if err := tx.Insert(inserted); err != nil {
+			t.Fatal(err)
+		}
+		if err := tx.Update(&TestModel{ID: 1, Name: "Updated Model"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := tx.Delete(tm); err != nil {
+			t.Fatal(err)
+		}
+		if err := tx.Rollback(); err != nil {
+			t.Fatal(err)
+		}
+		if len(db.models) != 1 || db.models[0] != tm || tm.Name != "Test Model" {
+			t.Fatalf("rolled back changes should be discarded; found %v", db.models)
+		}
+	})
+
+	t.Run("Inserted copies", func(t *testing.T) {
+		db := &MockDB{}
+		tm := &TestModel{ID: 1, Name: "Test Model"}
+
+		err := db.RunInTransaction(func(tx Tx) error {
+			if err := tx.Insert(tm); err != nil {
+				return err
+			}
+			tm.Name = "Changed after insert"
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if db.models[0].(*TestModel).Name != "Test Model" {
+			t.Fatal("changes to a model after it was inserted should not be stored")
+		}
+	})
+
+	t.Run("State", func(t *testing.T) {
+		db := &MockDB{}
+
+		committed, _ := db.Begin()
+		if err := committed.Commit(); err != nil {
+			t.Fatal(err)
+		}
+		if err := committed.Commit(); err != pg.ErrTxDone {
+			t.Fatal("expected pg.ErrTxDone for a double commit; found ", err)
+		}
+		if err := committed.Insert(&TestModel{ID: 1}); err != pg.ErrTxDone {
+			t.Fatal("expected pg.ErrTxDone for an Insert after commit; found ", err)
+		}
+		if _, err := committed.Query(&TestModel{}, "SELECT 1"); err != pg.ErrTxDone {
+			t.Fatal("expected pg.ErrTxDone for a Query after commit; found ", err)
+		}
+		if err := committed.Close(); err != nil {
+			t.Fatal("expected Close after commit to be a no-op; found ", err)
+		}
+		if committed.State() != TxCommitted {
+			t.Fatal("expected committed state; found ", committed.State())
+		}
+
+		rolledBack, _ := db.Begin()
+		if err := rolledBack.Rollback(); err != nil {
+			t.Fatal(err)
+		}
+		if err := rolledBack.Commit(); err != pg.ErrTxDone {
+			t.Fatal("expected pg.ErrTxDone for a commit after rollback; found ", err)
+		}
+		if rolledBack.State() != TxRolledBack {
+			t.Fatal("expected rolled back state; found ", rolledBack.State())
+		}
+
+		leaked, _ := db.Begin()
+		open := db.OpenTransactions()
+		if len(open) != 1 || open[0] != leaked {
+			t.Fatal("expected the leaked transaction to be open")
+		}
+		if n := len(db.Transactions()); n != 3 {
+			t.Fatal("expected 3 transactions; found ", n)
+		}
+	})
+
+	t.Run("RunInTransaction rolls back on error", func(t *testing.T) {
+		db := &MockDB{}
+		var mtx *MockTx
+
+		err := db.RunInTransaction(func(tx Tx) error {
+			mtx = tx.(*MockTx)
+			return errors.New("failed")
+		})
+		if err == nil {
+			t.Fatal("expected an error")
+		}
+		if mtx.State() != TxRolledBack {
+			t.Fatal("expected rolled back state; found ", mtx.State())
+		}
+	})
+
+	t.Run("RunInTransaction rolls back on panic", func(t *testing.T) {
+		tm := &TestModel{ID: 1, Name: "Test Model"}
+		db := &MockDB{models: []interface{}{tm}}
+
+		func() {
+			defer func() {
+				if p := recover(); p != "boom" {
+					t.Fatal("expected the panic to continue; recovered ", p)
+				}
+			}()
+			_ = db.RunInTransaction(func(tx Tx) error {
+				if err := tx.Update(&TestModel{ID: 1, Name: "Updated Model"}); err != nil {
+					return err
+				}
+				panic("boom")
+			})
+		}()
+
+		txs := db.Transactions()
+		if len(txs) != 1 {
+			t.Fatal("expected 1 transaction; found ", len(txs))
+		}
+		if txs[0].State() != TxRolledBack {
+			t.Fatal("expected rolled back state; found ", txs[0].State())
+		}
+		if txs[0].RecoveredPanic() != "boom" {
+			t.Fatal("expected the panic to be recorded; found ", txs[0].RecoveredPanic())
+		}
+		if tm.Name != "Test Model" {
+			t.Fatal("changes made before the panic should be rolled back")
+		}
+	})
+
+	t.Run("Nested RunInTransaction rolls back on panic", func(t *testing.T) {
+		db := &MockDB{}
+		tx, _ := db.Begin()
+		_ = tx.Insert(&TestModel{ID: 1})
+
+		func() {
+			defer func() { _ = recover() }()
+			_ = tx.RunInTransaction(func(inner *MockTx) error {
+				panic("nested boom")
+			})
+		}()
+		if tx.State() != TxRolledBack || tx.RecoveredPanic() != "nested boom" {
+			t.Fatal("expected the transaction to be rolled back by the panic")
+		}
+		if err := tx.Commit(); err != pg.ErrTxDone {
+			t.Fatal("expected pg.ErrTxDone after the panic; found ", err)
+		}
+	})
 }
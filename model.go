@@ -1,5 +1,8 @@
 package testutils
 
+// Model may be implemented by a model to override how MockDB identifies it.
+// Models which do not implement it are identified by their primary key
+// columns, tagged pg:",pk", or else their id column.
 type Model interface {
 	GetID() string
 	Equals(interface{}) bool
@@ -0,0 +1,130 @@
+package testutils
+
+import (
+	"fmt"
+
+	"github.com/go-pg/pg/v9"
+)
+
+// SQLSTATE codes of Postgres errors commonly handled by applications
+const (
+	CodeFeatureNotSupported       = "0A000"
+	CodeDivisionByZero            = "22012"
+	CodeInvalidRowCountInLimit    = "2201W"
+	CodeInvalidRowCountInOffset   = "2201X"
+	CodeInvalidTextRepresentation = "22P02"
+	CodeNotNullViolation          = "23502"
+	CodeForeignKeyViolation       = "23503"
+	CodeUniqueViolation           = "23505"
+	CodeCheckViolation            = "23514"
+	CodeExclusionViolation        = "23P01"
+	CodeInvalidSavepoint          = "3B001"
+	CodeSerializationFailure      = "40001"
+	CodeDeadlockDetected          = "40P01"
+	CodeSyntaxError               = "42601"
+	CodeAmbiguousColumn           = "42702"
+	CodeUndefinedColumn           = "42703"
+	CodeGroupingError             = "42803"
+	CodeDatatypeMismatch          = "42804"
+	CodeUndefinedFunction         = "42883"
+	CodeUndefinedObject           = "42704"
+	CodeCannotCoerce              = "42846"
+	CodeDuplicateAlias            = "42712"
+	CodeUndefinedTable            = "42P01"
+	CodeInvalidColumnReference    = "42P10"
+	CodeQueryCanceled             = "57014"
+)
+
+// PGField is a field of a Postgres error message, identified by the same byte
+// passed to pg.Error's Field method, for example 'n' for the constraint name
+type PGField struct {
+	Field byte
+	Value string
+}
+
+// mockPGError implements the pg.Error interface with a fixed set of fields
+type mockPGError struct {
+	fields map[byte]string
+}
+
+// Error formats the error the same way as go-pg
+func (e *mockPGError) Error() string {
+	return fmt.Sprintf("%s #%s %s", e.Field('S'), e.Field('C'), e.Field('M'))
+}
+
+// Field returns the value of a field of the error, or an empty string
+func (e *mockPGError) Field(field byte) string {
+	return e.fields[field]
+}
+
+// IntegrityViolation returns whether the error's SQLSTATE code is one of the
+// integrity constraint violations reported by go-pg
+func (e *mockPGError) IntegrityViolation() bool {
+	switch e.Field('C') {
+	case "23000", "23001", CodeNotNullViolation, CodeForeignKeyViolation,
+		CodeUniqueViolation, CodeCheckViolation, CodeExclusionViolation:
+		return true
+	default:
+		return false
+	}
+}
+
+// PGError creates an error implementing pg.Error with the SQLSTATE code and
+// message in its 'C' and 'M' fields. The severity fields default to ERROR and
+// any other fields, like the table ('t') or constraint ('n') name, may be
+// added.
+func PGError(code, message string, fields ...PGField) pg.Error {
+	e := &mockPGError{fields: map[byte]string{
+		'S': "ERROR",
+		'V': "ERROR",
+		'C': code,
+		'M': message,
+	}}
+	for _, f := range fields {
+		e.fields[f.Field] = f.Value
+	}
+	return e
+}
+
+// UniqueViolation creates a pg.Error for a duplicate key in a unique
+// constraint or index
+func UniqueViolation(table, constraint string) pg.Error {
+	return PGError(CodeUniqueViolation,
+		fmt.Sprintf("duplicate key value violates unique constraint %q", constraint),
+		PGField{'t', table}, PGField{'n', constraint})
+}
+
+// ForeignKeyViolation creates a pg.Error for an insert or update which
+// references a missing row
+func ForeignKeyViolation(table, constraint string) pg.Error {
+	return PGError(CodeForeignKeyViolation,
+		fmt.Sprintf("insert or update on table %q violates foreign key constraint %q", table, constraint),
+		PGField{'t', table}, PGField{'n', constraint})
+}
+
+// NotNullViolation creates a pg.Error for a null value in a not null column
+func NotNullViolation(table, column string) pg.Error {
+	return PGError(CodeNotNullViolation,
+		fmt.Sprintf("null value in column %q violates not-null constraint", column),
+		PGField{'t', table}, PGField{'c', column})
+}
+
+// CheckViolation creates a pg.Error for a row which fails a check constraint
+func CheckViolation(table, constraint string) pg.Error {
+	return PGError(CodeCheckViolation,
+		fmt.Sprintf("new row for relation %q violates check constraint %q", table, constraint),
+		PGField{'t', table}, PGField{'n', constraint})
+}
+
+// SerializationFailure creates a pg.Error for a transaction which could not be
+// serialized with a concurrent transaction and should be retried
+func SerializationFailure() pg.Error {
+	return PGError(CodeSerializationFailure,
+		"could not serialize access due to concurrent update")
+}
+
+// DeadlockDetected creates a pg.Error for a transaction aborted to resolve a
+// deadlock
+func DeadlockDetected() pg.Error {
+	return PGError(CodeDeadlockDetected, "deadlock detected")
+}
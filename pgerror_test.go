@@ -0,0 +1,64 @@
+package testutils
+
+import (
+	"testing"
+
+	"github.com/go-pg/pg/v9"
+)
+
+func TestPGError(t *testing.T) {
+	t.Run("Fields", func(t *testing.T) {
+		err := PGError("22P02", "invalid input syntax", PGField{'D', "detail"})
+		if err.Field('C') != "22P02" || err.Field('M') != "invalid input syntax" || err.Field('D') != "detail" {
+			t.Fatalf("unexpected fields in %v", err)
+		}
+		if err.Error() != "ERROR #22P02 invalid input syntax" {
+			t.Fatal("unexpected error message: ", err.Error())
+		}
+		if err.IntegrityViolation() {
+			t.Fatal("22P02 is not an integrity violation")
+		}
+	})
+
+	t.Run("Helpers", func(t *testing.T) {
+		tests := []struct {
+			err       pg.Error
+			code      string
+			integrity bool
+		}{
+			{UniqueViolation("users", "users_email_key"), CodeUniqueViolation, true},
+			{ForeignKeyViolation("orders", "orders_user_id_fkey"), CodeForeignKeyViolation, true},
+			{NotNullViolation("users", "email"), CodeNotNullViolation, true},
+			{CheckViolation("orders", "orders_total_check"), CodeCheckViolation, true},
+			{SerializationFailure(), CodeSerializationFailure, false},
+			{DeadlockDetected(), CodeDeadlockDetected, false},
+		}
+		for _, tt := range tests {
+			if tt.err.Field('C') != tt.code {
+				t.Fatalf("expected code %s; found %s", tt.code, tt.err.Field('C'))
+			}
+			if tt.err.IntegrityViolation() != tt.integrity {
+				t.Fatalf("expected IntegrityViolation() of %s to be %v", tt.code, tt.integrity)
+			}
+		}
+		if n := UniqueViolation("users", "users_email_key").Field('n'); n != "users_email_key" {
+			t.Fatal("expected constraint name users_email_key; found ", n)
+		}
+	})
+
+	t.Run("Injected", func(t *testing.T) {
+		db := NewMockDB()
+		db.InjectError(UniqueViolation("test_models", "test_models_pkey")).On("Insert")
+
+		err := db.RunInTransaction(func(tx Tx) error {
+			return tx.Insert(&TestModel{ID: 1})
+		})
+		pgErr, ok := err.(pg.Error)
+		if !ok {
+			t.Fatalf("expected a pg.Error; found %T", err)
+		}
+		if pgErr.Field('C') != CodeUniqueViolation {
+			t.Fatal("expected a unique violation; found ", pgErr)
+		}
+	})
+}
@@ -0,0 +1,797 @@
+package testutils
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-pg/pg/v9"
+)
+
+// Query is the part of go-pg's orm.Query API returned by the Model method of
+// DB and Tx. QueryWrapper implements it with go-pg and MockQuery against the
+// models stored in a MockDB.
+type Query interface {
+	// Where adds a condition joined to the previous ones with AND
+	Where(condition string, params ...interface{}) Query
+
+	// WhereIn adds a condition like "id IN (?)" with the values in the slice
+	WhereIn(where string, slice interface{}) Query
+
+	// Order adds columns to order by, like "name" or "created_at DESC"
+	Order(orders ...string) Query
+
+	// Limit limits the number of rows returned
+	Limit(n int) Query
+
+	// Offset skips the first rows
+	Offset(n int) Query
+
+	// Column limits the columns selected
+	Column(columns ...string) Query
+
+	// ColumnExpr adds an expression to select, like "count(*) AS total"
+	ColumnExpr(expr string, params ...interface{}) Query
+
+	// Group adds columns to group by
+	Group(columns ...string) Query
+
+	// GroupExpr adds an expression to group by
+	GroupExpr(group string, params ...interface{}) Query
+
+	// Having adds a condition on the groups, joined to the previous ones with
+	// AND
+	Having(having string, params ...interface{}) Query
+
+	// Join joins a table, like "LEFT JOIN authors AS a ON a.id = book.author_id"
+	Join(join string, params ...interface{}) Query
+
+	// JoinOn adds a condition to the last join, joined to its others with AND
+	JoinOn(condition string, params ...interface{}) Query
+
+	// Relation loads the relation with the field name, which may be nested
+	// with dots. The apply functions may filter and order the related rows.
+	Relation(name string, apply ...func(Query) (Query, error)) Query
+
+	// Select selects rows into the model, or into values if given
+	Select(values ...interface{}) error
+}
+
+// MockQuery implements the Query interface against the models stored in a
+// MockDB, or in a transaction if it was created by MockTx.Model. Conditions,
+// orders and columns are SQL evaluated against each model, so a query selects
+// the same rows as it would from Postgres. Errors in the SQL are returned by
+// the method which runs the query.
+type MockQuery struct {
+	db    *MockDB
+	tx    *MockTx
+	model interface{}
+	tbl   *table
+	err   error
+
+	where     []queryClause
+	joins     []queryJoin
+	orders    []queryClause
+	columns   []queryClause
+	groups    []queryClause
+	having    []queryClause
+	relations []queryRelation
+	limit     int
+	offset    int
+}
+
+// queryClause is SQL passed to a query method with its params
+type queryClause struct {
+	sql    string
+	params []interface{}
+}
+
+// queryJoin is a table joined by Join, with the conditions added by JoinOn
+type queryJoin struct {
+	queryClause
+	on []queryClause
+}
+
+// queryRelation is a relation to load after selecting rows
+type queryRelation struct {
+	name  string
+	apply []func(Query) (Query, error)
+	// query is the query of the related model built by the apply functions
+	// before the query runs
+	query *MockQuery
+}
+
+// Model creates a query for the model, which may be a pointer to a struct or a
+// slice of structs, or a nil pointer of a struct type, like go-pg's Model
+func (db *MockDB) Model(model ...interface{}) Query {
+	return newMockQuery(db, nil, model)
+}
+
+// Model is an alias for DB.Model, whose queries run in the transaction
+func (tx *MockTx) Model(model ...interface{}) Query {
+	return newMockQuery(tx.db, tx, model)
+}
+
+// newMockQuery creates a query for the model
+func newMockQuery(db *MockDB, tx *MockTx, model []interface{}) *MockQuery {
+	q := &MockQuery{db: db, tx: tx}
+	if len(model) != 1 || model[0] == nil {
+		q.err = fmt.Errorf("testutils: Model needs one model, not %d", len(model))
+		return q
+	}
+	q.model = model[0]
+	t := reflect.TypeOf(q.model)
+	for t.Kind() == reflect.Ptr || isSlice(t) {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		q.err = fmt.Errorf("testutils: Model(%T) needs a struct or a slice of structs", q.model)
+		return q
+	}
+	q.tbl = getTable(t)
+	return q
+}
+
+// Where adds a condition joined to the previous ones with AND
+func (q *MockQuery) Where(condition string, params ...interface{}) Query {
+	q.where = append(q.where, queryClause{condition, params})
+	return q
+}
+
+// WhereIn adds a condition like "id IN (?)" with the values in the slice
+func (q *MockQuery) WhereIn(where string, slice interface{}) Query {
+	return q.Where(where, pg.In(slice))
+}
+
+// Order adds columns to order by, like "name" or "created_at DESC"
+func (q *MockQuery) Order(orders ...string) Query {
+	for _, o := range orders {
+		q.orders = append(q.orders, queryClause{sql: o})
+	}
+	return q
+}
+
+// Limit limits the number of rows returned
+func (q *MockQuery) Limit(n int) Query {
+	q.limit = n
+	return q
+}
+
+// Offset skips the first rows
+func (q *MockQuery) Offset(n int) Query {
+	q.offset = n
+	return q
+}
+
+// Column limits the columns selected. Columns not selected are left unchanged
+// in the model.
+func (q *MockQuery) Column(columns ...string) Query {
+	for _, c := range columns {
+		q.columns = append(q.columns, queryClause{sql: c})
+	}
+	return q
+}
+
+// ColumnExpr adds an expression to select, like "count(*) AS total", which may
+// be an aggregate
+func (q *MockQuery) ColumnExpr(expr string, params ...interface{}) Query {
+	q.columns = append(q.columns, queryClause{expr, params})
+	return q
+}
+
+// Group adds columns to group by. The columns selected must be grouped or
+// used in aggregates, unless the primary key is grouped.
+func (q *MockQuery) Group(columns ...string) Query {
+	for _, c := range columns {
+		q.groups = append(q.groups, queryClause{sql: c})
+	}
+	return q
+}
+
+// GroupExpr adds an expression to group by
+func (q *MockQuery) GroupExpr(group string, params ...interface{}) Query {
+	q.groups = append(q.groups, queryClause{group, params})
+	return q
+}
+
+// Having adds a condition on the groups, joined to the previous ones with AND
+func (q *MockQuery) Having(having string, params ...interface{}) Query {
+	q.having = append(q.having, queryClause{having, params})
+	return q
+}
+
+// Join joins a table, like "JOIN authors AS a ON a.id = book.author_id" or a
+// LEFT or CROSS JOIN, whose columns may be used in conditions, orders and
+// columns. The table is found by the name of a stored model's type.
+func (q *MockQuery) Join(join string, params ...interface{}) Query {
+	q.joins = append(q.joins, queryJoin{queryClause: queryClause{join, params}})
+	return q
+}
+
+// JoinOn adds a condition to the last join, joined to its others with AND
+func (q *MockQuery) JoinOn(condition string, params ...interface{}) Query {
+	if len(q.joins) == 0 {
+		q.err = errors.New("pg: no joins to apply JoinOn")
+		return q
+	}
+	j := &q.joins[len(q.joins)-1]
+	j.on = append(j.on, queryClause{condition, params})
+	return q
+}
+
+// Relation loads the relation with the field name into the selected models,
+// like LoadRelations. The apply functions are passed a query of the related
+// model, whose conditions and orders filter and order the related rows. Like
+// go-pg, a belongs to or has one relation is also joined with a LEFT JOIN
+// aliased by its column name, like "author", or by its path, like
+// "author__profile", so its columns may be used in the query.
+func (q *MockQuery) Relation(name string, apply ...func(Query) (Query, error)) Query {
+	q.relations = append(q.relations, queryRelation{name: name, apply: apply})
+	return q
+}
+
+// Select selects the rows the query matches into the model, or into values if
+// given. A struct or scalar destination must receive exactly one row, or
+// pg.ErrNoRows or pg.ErrMultiRows is returned. If SetSelectFromResponses is
+// enabled, the next queued response is used instead.
+func (q *MockQuery) Select(values ...interface{}) error {
+	return q.run("Select", func(models []interface{}, now time.Time) (pg.Result, error) {
+		n, err := q.selectRows(models, now, values)
+		if err != nil {
+			return nil, err
+		}
+		return NewMockResult(nil, n, n), nil
+	})
+}
+
+// run runs a query under the MockDB's lock, checking the transaction is open
+// and for injected errors, and records the call. The apply functions of its
+// relations are called first, without the lock, so they may use the MockDB.
+func (q *MockQuery) run(method string, fn func(models []interface{}, now time.Time) (pg.Result, error)) error {
+	relErr := q.buildRelations()
+	q.db.mu.Lock()
+	defer q.db.mu.Unlock()
+	sql, params := q.sql()
+	err := q.err
+	if err == nil {
+		err = relErr
+	}
+	if err == nil && q.tx != nil {
+		err = q.tx.checkOpen()
+	}
+	if err == nil {
+		err = q.db.injectedError(method, []interface{}{q.model}, sql)
+	}
+	var res pg.Result
+	if err == nil {
+		res, err = fn(q.models(), q.now())
+	}
+	txID := 0
+	if q.tx != nil {
+		txID = q.tx.id
+	}
+	q.db.record(txID, method, q.model, sql, params, res, err)
+	return err
+}
+
+// models returns the models the query runs against
+func (q *MockQuery) models() []interface{} {
+	if q.tx != nil {
+		return q.tx.models
+	}
+	return q.db.models
+}
+
+// now returns the time now() returns in the query
+func (q *MockQuery) now() time.Time {
+	if q.tx != nil {
+		return q.tx.start
+	}
+	return q.db.now()
+}
+
+// selectRows selects the rows the query matches into the model or values, and
+// returns the number selected
+func (q *MockQuery) selectRows(models []interface{}, now time.Time, values []interface{}) (int, error) {
+	dest := values
+	if len(dest) == 0 {
+		v := reflect.ValueOf(q.model)
+		if v.Kind() != reflect.Ptr || v.IsNil() {
+			return 0, fmt.Errorf("testutils: Select of Model(%T) needs values to select into", q.model)
+		}
+		dest = []interface{}{q.model}
+	}
+	if q.db.selectFromResponses {
+		if len(q.db.responses) == 0 {
+			return 0, pg.ErrNoRows
+		}
+		response := q.db.responses[0]
+		q.db.responses = q.db.responses[1:]
+		if qe, ok := response.(queuedError); ok {
+			return 0, qe.err
+		}
+		one := len(dest) > 1 || !isSlice(reflect.TypeOf(dest[0]).Elem())
+		res, err := scanResponse(one, dest[0], response)
+		if err != nil {
+			return 0, err
+		}
+		return res.RowsReturned(), nil
+	}
+
+	results, err := q.results(models, now)
+	if err != nil {
+		return 0, err
+	}
+	if err := scanResults(dest, results, true); err != nil {
+		return 0, err
+	}
+	if len(q.relations) > 0 {
+		for _, d := range dest {
+			for _, v := range modelStructs(d) {
+				if v.Type() != q.tbl.typ || !v.CanAddr() {
+					continue
+				}
+				if err := q.loadRelations(models, now, v); err != nil {
+					return 0, err
+				}
+			}
+		}
+	}
+	return len(results), nil
+}
+
+// results returns the results of the query, grouped if it has a GROUP BY
+// clause, HAVING or aggregates
+func (q *MockQuery) results(models []interface{}, now time.Time) ([]resultRow, error) {
+	sq := &selectQuery{limit: -1, offset: q.offset}
+	if q.limit > 0 {
+		sq.limit = q.limit
+	}
+	for _, c := range q.columns {
+		err := parseList(c.sql, c.params, q.tbl, q.model, func(p *parser) error {
+			item, err := p.selectItem()
+			sq.items = append(sq.items, item)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, g := range q.groups {
+		err := parseList(g.sql, g.params, q.tbl, q.model, func(p *parser) error {
+			e, err := p.expr(precOr)
+			sq.groups = append(sq.groups, e)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, h := range q.having {
+		e, err := parseExpr(h.sql, h.params, q.tbl, q.model)
+		if err != nil {
+			return nil, err
+		}
+		if sq.having == nil {
+			sq.having = e
+		} else {
+			sq.having = &binaryExpr{"and", sq.having, e}
+		}
+	}
+	var err error
+	if sq.orders, err = q.orderItems(); err != nil {
+		return nil, err
+	}
+	rows, err := q.filter(models, now)
+	if err != nil {
+		return nil, err
+	}
+	base := &evalContext{
+		sources: []*rowSource{{tbl: q.tbl, name: q.tbl.name, alias: q.tbl.alias}},
+		now:     now,
+	}
+	joins, err := q.tableJoins(models)
+	if err != nil {
+		return nil, err
+	}
+	for _, j := range joins {
+		base = base.with(j.source, reflect.Value{}, nil)
+	}
+	return sq.run(base, rows)
+}
+
+// orderItems parses the orders passed to Order
+func (q *MockQuery) orderItems() ([]orderItem, error) {
+	var orders []orderItem
+	for _, o := range q.orders {
+		err := parseList(o.sql, o.params, q.tbl, q.model, func(p *parser) error {
+			item, err := p.orderItem()
+			orders = append(orders, item)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return orders, nil
+}
+
+// rows returns the rows the query matches, ordered and limited
+func (q *MockQuery) rows(models []interface{}, now time.Time) ([]*evalContext, error) {
+	rows, err := q.filter(models, now)
+	if err != nil {
+		return nil, err
+	}
+	orders, err := q.orderItems()
+	if err != nil {
+		return nil, err
+	}
+	if err := sortRows(rows, orders); err != nil {
+		return nil, err
+	}
+	return limitRows(rows, q.offset, q.limit), nil
+}
+
+// limitRows returns rows after skipping offset rows, and at most limit rows
+// unless limit is 0
+func limitRows(rows []*evalContext, offset, limit int) []*evalContext {
+	if offset > len(rows) {
+		offset = len(rows)
+	}
+	if offset > 0 {
+		rows = rows[offset:]
+	}
+	if limit > 0 && limit < len(rows) {
+		rows = rows[:limit]
+	}
+	return rows
+}
+
+// filter returns the rows of the query's table matching its conditions, in the
+// order they were stored. The rows are joined to the tables of the query's
+// joins and relations first.
+func (q *MockQuery) filter(models []interface{}, now time.Time) ([]*evalContext, error) {
+	where, err := q.whereExpr()
+	if err != nil {
+		return nil, err
+	}
+	var rows []*evalContext
+	for _, m := range models {
+		v := reflect.Indirect(reflect.ValueOf(m))
+		if v.Type() != q.tbl.typ || isSoftDeleted(m) {
+			continue
+		}
+		rows = append(rows, &evalContext{
+			sources: []*rowSource{{tbl: q.tbl, name: q.tbl.name, alias: q.tbl.alias, row: v, model: m}},
+			now:     now,
+		})
+	}
+	tjs, err := q.tableJoins(models)
+	if err != nil {
+		return nil, err
+	}
+	for _, j := range tjs {
+		if rows, err = j.rows(rows, models); err != nil {
+			return nil, err
+		}
+	}
+	if where == nil {
+		return rows, nil
+	}
+	var matched []*evalContext
+	for _, ctx := range rows {
+		ok, err := evalCondition(ctx, where)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			matched = append(matched, ctx)
+		}
+	}
+	return matched, nil
+}
+
+// tableJoins returns the joins of the query's relations to one row, which
+// go-pg joins with a LEFT JOIN, followed by those passed to Join
+func (q *MockQuery) tableJoins(models []interface{}) ([]*tableJoin, error) {
+	sources := []*rowSource{{tbl: q.tbl, name: q.tbl.name, alias: q.tbl.alias}}
+	var joins []*tableJoin
+	for _, r := range q.relations {
+		tbl, alias := q.tbl, q.tbl.alias
+		prefix := ""
+		for _, name := range strings.Split(r.name, ".") {
+			rel := tbl.relation(name)
+			if rel == nil || rel.kind != belongsTo && rel.kind != hasOne {
+				break
+			}
+			joined := prefix + rel.field.name
+			if checkAlias(sources, joined) == nil {
+				j := &tableJoin{
+					source: &rowSource{tbl: rel.join, name: rel.join.name, alias: joined},
+					left:   true,
+					on: &binaryExpr{"=", &columnExpr{joined, rel.joinCol.name},
+						&columnExpr{alias, rel.baseCol.name}},
+				}
+				joins = append(joins, j)
+				sources = append(sources, j.source)
+			}
+			tbl, alias, prefix = rel.join, joined, joined+"__"
+		}
+	}
+	for _, qj := range q.joins {
+		tokens, err := lexSQL(qj.sql, qj.params, q.tbl, q.model)
+		if err != nil {
+			return nil, err
+		}
+		p := &parser{tokens: tokens}
+		sj, err := p.join()
+		if err == nil && sj == nil {
+			err = p.unexpected()
+		}
+		if err == nil {
+			err = p.end()
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, on := range qj.on {
+			e, err := parseExpr(on.sql, on.params, q.tbl, q.model)
+			if err != nil {
+				return nil, err
+			}
+			if sj.on == nil {
+				sj.on = e
+			} else {
+				sj.on = &binaryExpr{"and", sj.on, e}
+			}
+		}
+		if !sj.cross && sj.on == nil {
+			return nil, syntaxError(fmt.Sprintf("%q has no ON condition", qj.sql))
+		}
+		tbl := statementTable(sj.table, nil, models, q.db.models)
+		if tbl == nil {
+			return nil, PGError(CodeUndefinedTable, fmt.Sprintf("relation %q does not exist", sj.table))
+		}
+		j, err := newTableJoin(sj, tbl, sources)
+		if err != nil {
+			return nil, err
+		}
+		joins = append(joins, j)
+		sources = append(sources, j.source)
+	}
+	return joins, nil
+}
+
+// whereExpr parses the query's conditions into one expression, joined with AND
+func (q *MockQuery) whereExpr() (expr, error) {
+	var e expr
+	for _, w := range q.where {
+		cond, err := parseExpr(w.sql, w.params, q.tbl, q.model)
+		if err != nil {
+			return nil, err
+		}
+		if e == nil {
+			e = cond
+		} else {
+			e = &binaryExpr{"and", e, cond}
+		}
+	}
+	return e, nil
+}
+
+// loadRelations loads the query's relations into v, a selected struct
+func (q *MockQuery) loadRelations(models []interface{}, now time.Time, v reflect.Value) error {
+	rels := append([]queryRelation(nil), q.relations...)
+	// Load "Author" before "Author.Profile", which loads into it
+	sort.SliceStable(rels, func(i, j int) bool {
+		return strings.Count(rels[i].name, ".") < strings.Count(rels[j].name, ".")
+	})
+	for _, r := range rels {
+		if err := q.loadRelation(models, now, v, strings.Split(r.name, "."), r.query); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadRelation loads the relation at the path of field names into v, loading
+// any relations along the path which have not been loaded. The rows of the
+// last relation are filtered and ordered by rq if it is not nil.
+func (q *MockQuery) loadRelation(models []interface{}, now time.Time, v reflect.Value,
+	path []string, rq *MockQuery) error {
+	rel := getTable(v.Type()).relation(path[0])
+	if rel == nil {
+		return fmt.Errorf("testutils: %s has no relation %q", v.Type(), path[0])
+	}
+	f := rel.field.value(v)
+	if !f.IsValid() {
+		return nil
+	}
+	var loaded []reflect.Value
+	if len(path) > 1 && !f.IsZero() {
+		loaded = modelStructs(f.Addr().Interface())
+	} else {
+		rows := rel.related(models, v)
+		if len(path) == 1 && rq != nil {
+			var err error
+			if rows, err = filterRelation(rq, rows, now); err != nil {
+				return err
+			}
+		}
+		for _, p := range rel.set(v, rows) {
+			loaded = append(loaded, p.Elem())
+		}
+	}
+	if len(path) == 1 {
+		return nil
+	}
+	for _, l := range loaded {
+		if err := q.loadRelation(models, now, l, path[1:], rq); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// buildRelations calls the apply functions of the query's relations with a
+// query of the related model, whose conditions and orders filter and order the
+// related rows when they are loaded
+func (q *MockQuery) buildRelations() error {
+	for i, r := range q.relations {
+		q.relations[i].query = nil
+		if len(r.apply) == 0 || q.tbl == nil {
+			continue
+		}
+		tbl := q.tbl
+		for _, name := range strings.Split(r.name, ".") {
+			rel := tbl.relation(name)
+			if rel == nil {
+				// Left for loadRelation to report
+				tbl = nil
+				break
+			}
+			tbl = rel.join
+		}
+		if tbl == nil {
+			continue
+		}
+		rq := &MockQuery{db: q.db, tx: q.tx, model: reflect.New(tbl.typ).Interface(), tbl: tbl}
+		for _, fn := range r.apply {
+			if _, err := fn(rq); err != nil {
+				return err
+			}
+		}
+		if rq.err != nil {
+			return rq.err
+		}
+		q.relations[i].query = rq
+	}
+	return nil
+}
+
+// filterRelation filters and orders the related rows of a relation with the
+// conditions and orders of rq, a query built by buildRelations
+func filterRelation(rq *MockQuery, rows []interface{}, now time.Time) ([]interface{}, error) {
+	ctxs, err := rq.rows(rows, now)
+	if err != nil {
+		return nil, err
+	}
+	filtered := make([]interface{}, len(ctxs))
+	for i, ctx := range ctxs {
+		filtered[i] = ctx.sources[0].model
+	}
+	return filtered, nil
+}
+
+// sql returns SQL like go-pg would run for the query, and its params, which
+// are recorded in Calls and matched by InjectError's WhenQueryMatches
+func (q *MockQuery) sql() (string, []interface{}) {
+	if q.tbl == nil {
+		return "", nil
+	}
+	var b strings.Builder
+	var params []interface{}
+	b.WriteString("SELECT ")
+	if len(q.columns) == 0 {
+		b.WriteString("*")
+	}
+	for i, c := range q.columns {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString(c.sql)
+		params = append(params, c.params...)
+	}
+	fmt.Fprintf(&b, " FROM %q AS %q", q.tbl.name, q.tbl.alias)
+	for _, j := range q.joins {
+		b.WriteString(" " + j.sql)
+		params = append(params, j.params...)
+		for i, on := range j.on {
+			if i == 0 {
+				b.WriteString(" ON ")
+			} else {
+				b.WriteString(" AND ")
+			}
+			fmt.Fprintf(&b, "(%s)", on.sql)
+			params = append(params, on.params...)
+		}
+	}
+	for i, w := range q.where {
+		if i == 0 {
+			b.WriteString(" WHERE ")
+		} else {
+			b.WriteString(" AND ")
+		}
+		fmt.Fprintf(&b, "(%s)", w.sql)
+		params = append(params, w.params...)
+	}
+	for i, g := range q.groups {
+		if i == 0 {
+			b.WriteString(" GROUP BY ")
+		} else {
+			b.WriteString(", ")
+		}
+		b.WriteString(g.sql)
+		params = append(params, g.params...)
+	}
+	for i, h := range q.having {
+		if i == 0 {
+			b.WriteString(" HAVING ")
+		} else {
+			b.WriteString(" AND ")
+		}
+		fmt.Fprintf(&b, "(%s)", h.sql)
+		params = append(params, h.params...)
+	}
+	for i, o := range q.orders {
+		if i == 0 {
+			b.WriteString(" ORDER BY ")
+		} else {
+			b.WriteString(", ")
+		}
+		b.WriteString(o.sql)
+		params = append(params, o.params...)
+	}
+	if q.limit > 0 {
+		fmt.Fprintf(&b, " LIMIT %d", q.limit)
+	}
+	if q.offset > 0 {
+		fmt.Fprintf(&b, " OFFSET %d", q.offset)
+	}
+	return b.String(), params
+}
+
+// modelStructs returns the structs of a model, which may be a struct, a slice
+// of structs or pointers, or a pointer to either
+func modelStructs(model interface{}) []reflect.Value {
+	v := reflect.ValueOf(model)
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+	if v.Kind() == reflect.Struct {
+		return []reflect.Value{v}
+	}
+	if !isSlice(v.Type()) {
+		return nil
+	}
+	var structs []reflect.Value
+	for i := 0; i < v.Len(); i++ {
+		e := v.Index(i)
+		if e.Kind() == reflect.Ptr {
+			if e.IsNil() {
+				continue
+			}
+			e = e.Elem()
+		}
+		if e.Kind() == reflect.Struct {
+			structs = append(structs, e)
+		}
+	}
+	return structs
+}
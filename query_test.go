@@ -0,0 +1,250 @@
+package testutils
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-pg/pg/v9"
+)
+
+func TestQuery(t *testing.T) {
+	members := func() *MockDB {
+		db := NewMockDB()
+		db.QueueModels(
+			&Member{ID: 1, Email: "ann@example.com", Name: "Ann", TeamID: 1, Number: 1},
+			&Member{ID: 2, Email: "bob@example.com", Name: "Bob", TeamID: 1, Number: 2},
+			&Member{ID: 3, Email: "cat@example.org", Name: "Cat", TeamID: 2, Number: 1, Admin: true},
+		)
+		return db
+	}
+	ids := func(ms []Member) []int {
+		var out []int
+		for _, m := range ms {
+			out = append(out, m.ID)
+		}
+		return out
+	}
+	equal := func(a, b []int) bool {
+		if len(a) != len(b) {
+			return false
+		}
+		for i := range a {
+			if a[i] != b[i] {
+				return false
+			}
+		}
+		return true
+	}
+
+	t.Run("Implements Query interface", func(t *testing.T) {
+		var q interface{} = NewMockDB().Model(&Member{})
+		if _, ok := q.(*MockQuery); !ok {
+			t.Fatal("expected Model to return a *MockQuery")
+		}
+		var w interface{} = &QueryWrapper{}
+		if _, ok := w.(Query); !ok {
+			t.Fatal("expected QueryWrapper to implement Query")
+		}
+	})
+
+	t.Run("Where", func(t *testing.T) {
+		db := members()
+		var ms []Member
+		err := db.Model(&ms).
+			Where("team_id = ? OR admin = ?", 1, true).
+			Order("id DESC").
+			Select()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := ids(ms); !equal(got, []int{3, 2, 1}) {
+			t.Fatalf("expected members 3, 2, 1; found %v", got)
+		}
+
+		ms = nil
+		err = db.Model(&ms).
+			WhereIn("id IN (?)", []int{1, 3}).
+			Where("email LIKE ?", "%.com").
+			Select()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := ids(ms); !equal(got, []int{1}) {
+			t.Fatalf("expected member 1; found %v", got)
+		}
+	})
+
+	t.Run("Limit and Offset", func(t *testing.T) {
+		db := members()
+		var ms []Member
+		if err := db.Model(&ms).Order("id").Offset(1).Limit(1).Select(); err != nil {
+			t.Fatal(err)
+		}
+		if got := ids(ms); !equal(got, []int{2}) {
+			t.Fatalf("expected member 2; found %v", got)
+		}
+	})
+
+	t.Run("Column", func(t *testing.T) {
+		db := members()
+		var names []string
+		if err := db.Model(&Member{}).Column("name").Order("name DESC").Select(&names); err != nil {
+			t.Fatal(err)
+		}
+		if len(names) != 3 || names[0] != "Cat" {
+			t.Fatalf("expected names in descending order; found %v", names)
+		}
+
+		m := &Member{}
+		if err := db.Model(m).Column("id", "email").Where("id = 2").Select(); err != nil {
+			t.Fatal(err)
+		}
+		if m.ID != 2 || m.Email != "bob@example.com" || m.Name != "" {
+			t.Fatalf("expected only id and email to be selected; found %+v", m)
+		}
+
+		if err := db.Model(&Member{}).Column("missing").Select(); err == nil {
+			t.Fatal("expected an error selecting an undefined column")
+		}
+	})
+
+	t.Run("Select one", func(t *testing.T) {
+		db := members()
+		if err := db.Model(&Member{}).Where("id = 4").Select(); err != pg.ErrNoRows {
+			t.Fatalf("expected pg.ErrNoRows; found %v", err)
+		}
+		if err := db.Model(&Member{}).Where("team_id = 1").Select(); err != pg.ErrMultiRows {
+			t.Fatalf("expected pg.ErrMultiRows; found %v", err)
+		}
+
+		m := &Member{}
+		if err := db.Model(m).Where("id = ?", 3).Select(); err != nil {
+			t.Fatal(err)
+		}
+		if m.Name != "Cat" {
+			t.Fatalf("expected Cat; found %+v", m)
+		}
+	})
+
+	t.Run("Relation", func(t *testing.T) {
+		db := NewMockDB()
+		db.QueueModels(
+			&Author{ID: 1, Name: "Ann"},
+			&Author{ID: 2, Name: "Bob"},
+			&Book{ID: 1, AuthorID: 1, Title: "B"},
+			&Book{ID: 2, AuthorID: 1, Title: "A"},
+			&Book{ID: 3, AuthorID: 2, Title: "C"},
+		)
+		a := &Author{}
+		err := db.Model(a).
+			Relation("Books", func(q Query) (Query, error) {
+				return q.Where("title <> ?", "C").Order("title"), nil
+			}).
+			Where("name = ?", "Ann").
+			Select()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(a.Books) != 2 || a.Books[0].Title != "A" {
+			t.Fatalf("expected the author's books in order; found %+v", a.Books)
+		}
+
+		var books []Book
+		if err := db.Model(&books).Relation("Author").Where("book.id = 3").Select(); err != nil {
+			t.Fatal(err)
+		}
+		if len(books) != 1 || books[0].Author == nil || books[0].Author.Name != "Bob" {
+			t.Fatalf("expected the book's author; found %+v", books)
+		}
+
+		if err := db.Model(a).Relation("Missing").Select(); err == nil {
+			t.Fatal("expected an error for an unknown relation")
+		}
+
+		// Apply functions are called without the MockDB's lock, so they may
+		// use the MockDB
+		done := make(chan error, 1)
+		go func() {
+			done <- db.Model(a).
+				Relation("Books", func(q Query) (Query, error) {
+					var c []Book
+					err := db.Model(&c).Where("title = ?", "C").Select()
+					return q.Where("id > ?", len(c)), err
+				}).
+				Where("name = ?", "Ann").
+				Select()
+		}()
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatal(err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("expected an apply function using the MockDB not to deadlock")
+		}
+		if len(a.Books) != 1 || a.Books[0].ID != 2 {
+			t.Fatalf("expected the books filtered by the apply function; found %+v", a.Books)
+		}
+	})
+
+	t.Run("Transactions", func(t *testing.T) {
+		db := members()
+		tx, err := db.Begin()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := tx.Delete(&Member{ID: 1}); err != nil {
+			t.Fatal(err)
+		}
+		var ms []Member
+		if err := tx.Model(&ms).Select(); err != nil || len(ms) != 2 {
+			t.Fatalf("expected 2 members in the transaction; found %v (%v)", ids(ms), err)
+		}
+		ms = nil
+		if err := db.Model(&ms).Select(); err != nil || len(ms) != 3 {
+			t.Fatalf("expected 3 members outside the transaction; found %v (%v)", ids(ms), err)
+		}
+		if err := tx.Rollback(); err != nil {
+			t.Fatal(err)
+		}
+		if err := tx.Model(&ms).Select(); err == nil {
+			t.Fatal("expected an error querying a closed transaction")
+		}
+	})
+
+	t.Run("Calls and injected errors", func(t *testing.T) {
+		db := members()
+		injected := errors.New("injected")
+		db.InjectError(injected).On("Select").WhenQueryMatches(`"team_id" = 2|team_id = 2`)
+
+		var ms []Member
+		if err := db.Model(&ms).Where("team_id = 1").Select(); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Model(&ms).Where("team_id = 2").Select(); err != injected {
+			t.Fatalf("expected the injected error; found %v", err)
+		}
+		calls := db.Calls().Method("Select")
+		if len(calls) != 2 {
+			t.Fatalf("expected 2 Select calls; found %d", len(calls))
+		}
+		if len(calls.Matching(`FROM "members"`)) != 2 {
+			t.Fatalf("expected the calls to record their query; found %q", calls[0].Query)
+		}
+	})
+
+	t.Run("Clock", func(t *testing.T) {
+		at := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+		db := NewMockDB()
+		db.SetClock(NewFakeClock(at))
+		db.QueueModels(&Post{ID: 1})
+		var posts []Post
+		if err := db.Model(&posts).Where("now() = ?", at).Select(); err != nil {
+			t.Fatal(err)
+		}
+		if len(posts) != 1 {
+			t.Fatal("expected now() to be the time of the clock")
+		}
+	})
+}
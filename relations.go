@@ -0,0 +1,342 @@
+package testutils
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// relationKind is the kind of a go-pg relation
+type relationKind int
+
+const (
+	// belongsTo relations have the foreign key in the model's own table, like
+	// Author *User with an AuthorID column
+	belongsTo relationKind = iota
+	// hasOne relations have the foreign key in the related table, which has
+	// at most one row for the model
+	hasOne
+	// hasMany relations have the foreign key in the related table, like
+	// Comments []*Comment with a PostID column in Comment
+	hasMany
+	// many2many relations join the tables through a third table named by a
+	// many2many: tag
+	many2many
+)
+
+// relation is a relation field resolved against the tables it joins
+type relation struct {
+	field *column
+	kind  relationKind
+	base  *table
+	join  *table
+
+	// baseCol and joinCol are the columns joined on: for belongsTo, the
+	// foreign key in base and the primary key of join, and otherwise the
+	// primary key of base and the foreign key in join. For many2many, they are
+	// the primary keys of both tables.
+	baseCol *column
+	joinCol *column
+
+	// m2mTable is the name of the table joining a many2many relation, and
+	// m2mBase and m2mJoin its columns referencing base and join
+	m2mTable string
+	m2mBase  string
+	m2mJoin  string
+}
+
+// relation returns the table's relation with the field name, or nil if there
+// is none or go-pg could not join it
+func (tbl *table) relation(name string) *relation {
+	for _, f := range tbl.relationFields {
+		if f.field == name || f.name == name {
+			return resolveRelation(tbl, f)
+		}
+	}
+	return nil
+}
+
+// relations returns the table's relations which go-pg could join
+func (tbl *table) relations() []*relation {
+	var rels []*relation
+	for _, f := range tbl.relationFields {
+		if rel := resolveRelation(tbl, f); rel != nil {
+			rels = append(rels, rel)
+		}
+	}
+	return rels
+}
+
+// resolveRelation finds the columns a relation field joins on, following
+// go-pg's naming conventions unless the field has an fk: tag. Relations are
+// resolved when used rather than in newTable, as tables may refer to each
+// other.
+func resolveRelation(base *table, f *column) *relation {
+	t := f.typ
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	slice := t.Kind() == reflect.Slice
+	if slice {
+		t = t.Elem()
+		if t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+	}
+	rel := &relation{field: f, base: base, join: getTable(t)}
+	join := rel.join
+	fk := f.options["fk"]
+
+	if m2m, ok := f.options["many2many"]; ok && slice {
+		if len(base.pks) != 1 || len(join.pks) != 1 {
+			return nil
+		}
+		rel.kind = many2many
+		rel.m2mTable = strings.Trim(strings.SplitN(m2m, ":", 2)[0], `"`)
+		rel.m2mBase = fkColumnName(fk, underscore(base.typ.Name())+"_id")
+		rel.m2mJoin = fkColumnName(f.options["joinFK"], fkColumnName(f.options["join_fk"], underscore(join.typ.Name())+"_id"))
+		rel.baseCol, rel.joinCol = base.pks[0], join.pks[0]
+		return rel
+	}
+
+	if !slice && len(join.pks) == 1 {
+		for _, name := range fkCandidates(fk, underscore(f.field)+"_id") {
+			if c := base.column(name); c != nil {
+				rel.kind = belongsTo
+				rel.baseCol, rel.joinCol = c, join.pks[0]
+				return rel
+			}
+		}
+	}
+	if len(base.pks) != 1 {
+		return nil
+	}
+	for _, name := range fkCandidates(fk, underscore(base.typ.Name())+"_id") {
+		if c := join.column(name); c != nil {
+			rel.kind = hasOne
+			if slice {
+				rel.kind = hasMany
+			}
+			rel.baseCol, rel.joinCol = base.pks[0], c
+			return rel
+		}
+	}
+	return nil
+}
+
+// fkCandidates returns the column names an fk: tag may refer to, as go-pg
+// versions name either the column or the prefix of its field, or the default
+// name if there is no tag
+func fkCandidates(fk, def string) []string {
+	if fk == "" {
+		return []string{def}
+	}
+	return []string{fk, underscore(fk), underscore(fk) + "_id"}
+}
+
+// fkColumnName returns the column name of a many2many fk: tag, or the default
+// name if there is no tag
+func fkColumnName(fk, def string) string {
+	if fk == "" {
+		return def
+	}
+	return underscore(fk)
+}
+
+// columnKey returns the value of column c in v, a struct, normalized for
+// comparison with normalizeKeyValue, and false if it is null
+func columnKey(c *column, v reflect.Value) (interface{}, bool) {
+	f := c.value(v)
+	if isNull(c, f) {
+		return nil, false
+	}
+	return normalizeKeyValue(f.Interface()), true
+}
+
+// rowsWhere returns the models of the table's type whose column c equals key.
+// Soft deleted models are skipped unless withDeleted is true.
+func rowsWhere(models []interface{}, tbl *table, c *column, key interface{}, withDeleted bool) []interface{} {
+	var rows []interface{}
+	for _, r := range models {
+		v := reflect.Indirect(reflect.ValueOf(r))
+		if v.Type() != tbl.typ || (!withDeleted && isSoftDeleted(r)) {
+			continue
+		}
+		if k, ok := columnKey(c, v); ok && k == key {
+			rows = append(rows, r)
+		}
+	}
+	return rows
+}
+
+// tableRows returns the models stored for the table with the name, which may
+// be of any type
+func tableRows(models []interface{}, name string) []interface{} {
+	var rows []interface{}
+	for _, r := range models {
+		v := reflect.Indirect(reflect.ValueOf(r))
+		if v.Kind() == reflect.Struct && getTable(v.Type()).name == name {
+			rows = append(rows, r)
+		}
+	}
+	return rows
+}
+
+// related returns the models in models related to v, a struct of the
+// relation's base table
+func (rel *relation) related(models []interface{}, v reflect.Value) []interface{} {
+	key, ok := columnKey(rel.baseCol, v)
+	if !ok {
+		return nil
+	}
+	if rel.kind != many2many {
+		return rowsWhere(models, rel.join, rel.joinCol, key, false)
+	}
+	var rows []interface{}
+	for _, r := range tableRows(models, rel.m2mTable) {
+		jv := reflect.Indirect(reflect.ValueOf(r))
+		jt := getTable(jv.Type())
+		baseCol, joinCol := jt.column(rel.m2mBase), jt.column(rel.m2mJoin)
+		if baseCol == nil || joinCol == nil {
+			continue
+		}
+		if k, ok := columnKey(baseCol, jv); !ok || k != key {
+			continue
+		}
+		if k, ok := columnKey(joinCol, jv); ok {
+			rows = append(rows, rowsWhere(models, rel.join, rel.joinCol, k, false)...)
+		}
+	}
+	return rows
+}
+
+// load sets the relation's field in v, a struct of its base table, to copies
+// of the related models, and returns pointers to the loaded structs
+func (rel *relation) load(models []interface{}, v reflect.Value) []reflect.Value {
+	return rel.set(v, rel.related(models, v))
+}
+
+// set sets the relation's field in v to copies of rows, which are models of the
+// joined table, and returns pointers to the loaded structs
+func (rel *relation) set(v reflect.Value, rows []interface{}) []reflect.Value {
+	f := rel.field.value(v)
+	if !f.IsValid() {
+		return nil
+	}
+	var loaded []reflect.Value
+	ft := f.Type()
+	if ft.Kind() == reflect.Ptr && ft.Elem().Kind() == reflect.Slice {
+		if f.IsNil() {
+			f.Set(reflect.New(ft.Elem()))
+		}
+		f = f.Elem()
+		ft = f.Type()
+	}
+	switch ft.Kind() {
+	case reflect.Slice:
+		s := reflect.MakeSlice(ft, 0, len(rows))
+		for _, r := range rows {
+			c := reflect.ValueOf(deepCopy(r))
+			if ft.Elem().Kind() == reflect.Ptr {
+				s = reflect.Append(s, c)
+			} else {
+				s = reflect.Append(s, c.Elem())
+			}
+		}
+		f.Set(s)
+		for i := 0; i < s.Len(); i++ {
+			if e := s.Index(i); e.Kind() == reflect.Ptr {
+				loaded = append(loaded, e)
+			} else {
+				loaded = append(loaded, e.Addr())
+			}
+		}
+	case reflect.Ptr:
+		if len(rows) == 0 {
+			f.Set(reflect.Zero(ft))
+			break
+		}
+		f.Set(reflect.ValueOf(deepCopy(rows[0])))
+		loaded = append(loaded, f)
+	case reflect.Struct:
+		if len(rows) == 0 {
+			f.Set(reflect.Zero(ft))
+			break
+		}
+		f.Set(reflect.ValueOf(deepCopy(rows[0])).Elem())
+		loaded = append(loaded, f.Addr())
+	}
+	return loaded
+}
+
+// loadRelations loads the named relations of model from models. Names may be
+// nested with dots, like "Author.Profile", and no names loads every relation.
+func loadRelations(models []interface{}, model interface{}, names []string) error {
+	v := reflect.ValueOf(model)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("testutils: LoadRelations(%T) needs a pointer to a struct", model)
+	}
+	tbl := getTable(v.Type())
+	if len(names) == 0 {
+		for _, rel := range tbl.relations() {
+			rel.load(models, v.Elem())
+		}
+		return nil
+	}
+
+	var order []string
+	nested := make(map[string][]string)
+	for _, name := range names {
+		parts := strings.SplitN(name, ".", 2)
+		if _, ok := nested[parts[0]]; !ok {
+			order = append(order, parts[0])
+			nested[parts[0]] = nil
+		}
+		if len(parts) == 2 {
+			nested[parts[0]] = append(nested[parts[0]], parts[1])
+		}
+	}
+	for _, name := range order {
+		rel := tbl.relation(name)
+		if rel == nil {
+			return fmt.Errorf("testutils: %T has no relation %q", model, name)
+		}
+		for _, child := range rel.load(models, v.Elem()) {
+			if len(nested[name]) == 0 {
+				continue
+			}
+			if err := loadRelations(models, child.Interface(), nested[name]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// LoadRelations sets the named relation fields of model, a pointer to a struct,
+// to copies of the related models, like go-pg's Relation. Relations are found
+// from go-pg's has-one, belongs-to, has-many and many2many struct tags and
+// naming conventions. Names may be nested with dots, like "Author.Profile", and
+// no names loads every relation of model.
+func (db *MockDB) LoadRelations(model interface{}, names ...string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return loadRelations(db.models, model, names)
+}
+
+// SetLoadRelations makes Select, MockTx.Select and Find load every relation of
+// the models they return, like LoadRelations with no names. Find then returns
+// a copy of the stored model.
+func (db *MockDB) SetLoadRelations(enabled bool) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.loadOnSelect = enabled
+}
+
+// LoadRelations is an alias for DB.LoadRelations, which sees the transaction's
+// changes
+func (tx *MockTx) LoadRelations(model interface{}, names ...string) error {
+	tx.db.mu.Lock()
+	defer tx.db.mu.Unlock()
+	return loadRelations(tx.models, model, names)
+}
@@ -0,0 +1,166 @@
+package testutils
+
+import (
+	"reflect"
+	"testing"
+)
+
+type Author struct {
+	ID      int
+	Name    string
+	Profile *Profile
+	Books   []*Book `pg:"on_delete:CASCADE"`
+}
+
+type Profile struct {
+	ID       int
+	AuthorID int
+	Bio      string
+}
+
+type Book struct {
+	ID       int
+	AuthorID int
+	Author   *Author
+	Title    string
+	Genres   []Genre `pg:"many2many:book_genres"`
+}
+
+type Genre struct {
+	ID   int
+	Name string
+}
+
+type BookGenre struct {
+	tableName struct{} `pg:"book_genres"`
+
+	BookID  int `pg:",pk"`
+	GenreID int `pg:",pk"`
+}
+
+func TestRelations(t *testing.T) {
+	newDB := func() *MockDB {
+		db := NewMockDB()
+		db.QueueModels(
+			&Author{ID: 1, Name: "Ann"},
+			&Profile{ID: 1, AuthorID: 1, Bio: "Writer"},
+			&Book{ID: 1, AuthorID: 1, Title: "First"},
+			&Book{ID: 2, AuthorID: 1, Title: "Second"},
+			&Genre{ID: 1, Name: "Fiction"},
+			&Genre{ID: 2, Name: "Poetry"},
+			&BookGenre{BookID: 1, GenreID: 2},
+		)
+		return db
+	}
+
+	t.Run("Resolve", func(t *testing.T) {
+		for _, c := range []struct {
+			model interface{}
+			field string
+			kind  relationKind
+		}{
+			{&Book{}, "Author", belongsTo},
+			{&Author{}, "Profile", hasOne},
+			{&Author{}, "Books", hasMany},
+			{&Book{}, "Genres", many2many},
+		} {
+			rel := getTable(reflect.TypeOf(c.model)).relation(c.field)
+			if rel == nil || rel.kind != c.kind {
+				t.Fatalf("expected %T.%s to be a relation of kind %d; found %v", c.model, c.field, c.kind, rel)
+			}
+		}
+	})
+
+	t.Run("Load", func(t *testing.T) {
+		db := newDB()
+		a := &Author{ID: 1}
+		if err := db.Select(a); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.LoadRelations(a, "Profile", "Books.Genres"); err != nil {
+			t.Fatal(err)
+		}
+		if a.Profile == nil || a.Profile.Bio != "Writer" {
+			t.Fatalf("expected the author's profile; found %v", a.Profile)
+		}
+		if len(a.Books) != 2 || a.Books[1].Title != "Second" {
+			t.Fatalf("expected the author's books; found %v", a.Books)
+		}
+		if len(a.Books[0].Genres) != 1 || a.Books[0].Genres[0].Name != "Poetry" {
+			t.Fatalf("expected the first book's genres; found %v", a.Books[0].Genres)
+		}
+		if a.Books[0].Author != nil {
+			t.Fatal("expected only the named relations to be loaded")
+		}
+
+		a.Books[0].Title = "Changed"
+		stored, _ := db.FindAny(&Book{ID: 1})
+		if stored.(*Book).Title != "First" {
+			t.Fatal("expected loaded models to be copies")
+		}
+	})
+
+	t.Run("Load all", func(t *testing.T) {
+		db := newDB()
+		b := &Book{ID: 1, AuthorID: 1}
+		if err := db.LoadRelations(b); err != nil {
+			t.Fatal(err)
+		}
+		if b.Author == nil || b.Author.Name != "Ann" || len(b.Genres) != 1 {
+			t.Fatalf("expected every relation to be loaded; found %+v", b)
+		}
+	})
+
+	t.Run("Load on select", func(t *testing.T) {
+		db := newDB()
+		b := &Book{ID: 1}
+		if err := db.Select(b); err != nil {
+			t.Fatal(err)
+		}
+		if b.Author != nil {
+			t.Fatal("expected relations not to be loaded by default")
+		}
+
+		db.SetLoadRelations(true)
+		if err := db.Select(b); err != nil {
+			t.Fatal(err)
+		}
+		if b.Author == nil || b.Author.Name != "Ann" || len(b.Genres) != 1 {
+			t.Fatalf("expected Select to load every relation; found %+v", b)
+		}
+		found, err := db.FindAny(&Author{ID: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if a := found.(*Author); a.Profile == nil || len(a.Books) != 2 {
+			t.Fatalf("expected Find to load every relation; found %+v", a)
+		}
+		err = db.RunInTransaction(func(tx Tx) error {
+			if err := tx.Insert(&Book{ID: 3, AuthorID: 1, Title: "Third"}); err != nil {
+				return err
+			}
+			a := &Author{ID: 1}
+			if err := tx.Select(a); err != nil {
+				return err
+			}
+			if len(a.Books) != 3 {
+				t.Fatalf("expected the transaction's book to be loaded; found %v", a.Books)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		stored, _ := db.FindAny(&Author{ID: 1})
+		if db.models[0].(*Author).Books != nil || stored == db.models[0] {
+			t.Fatal("expected relations to be loaded into copies of the stored models")
+		}
+	})
+
+	t.Run("Unknown relation", func(t *testing.T) {
+		db := newDB()
+		if err := db.LoadRelations(&Book{ID: 1}, "Publisher"); err == nil {
+			t.Fatal("expected an error for an unknown relation")
+		}
+	})
+}
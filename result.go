@@ -0,0 +1,50 @@
+package testutils
+
+import (
+	"github.com/go-pg/pg/v9"
+	"github.com/go-pg/pg/v9/orm"
+)
+
+// MockResult implements pg.Result for the results returned by MockDB and
+// MockTx methods, so code reading the number of rows affected or returned
+// works in tests
+type MockResult struct {
+	model        orm.Model
+	rowsAffected int
+	rowsReturned int
+}
+
+// NewMockResult creates a pg.Result with the given model and row counts, to
+// return from an expected query with WillReturnResult. The model may be nil.
+func NewMockResult(model orm.Model, rowsAffected, rowsReturned int) *MockResult {
+	return &MockResult{
+		model:        model,
+		rowsAffected: rowsAffected,
+		rowsReturned: rowsReturned,
+	}
+}
+
+// Model returns the model of the result, if one was set
+func (r *MockResult) Model() orm.Model {
+	return r.model
+}
+
+// RowsAffected returns the number of rows affected by SELECT, INSERT, UPDATE,
+// or DELETE queries. It returns -1 if query can't possibly affect any rows.
+func (r *MockResult) RowsAffected() int {
+	return r.rowsAffected
+}
+
+// RowsReturned returns the number of rows returned by the query.
+func (r *MockResult) RowsReturned() int {
+	return r.rowsReturned
+}
+
+// writeResult creates the result of an Insert, Update or Delete call which
+// affected n rows, or nil if the call failed
+func writeResult(n int, err error) pg.Result {
+	if err != nil {
+		return nil
+	}
+	return NewMockResult(nil, n, 0)
+}
@@ -0,0 +1,92 @@
+package testutils
+
+import (
+	"testing"
+
+	"github.com/go-pg/pg/v9"
+)
+
+func TestMockResult(t *testing.T) {
+	t.Run("Query", func(t *testing.T) {
+		db := NewMockDB()
+		db.QueueResponses([]TestModel{{ID: 1}, {ID: 2}})
+
+		var response []TestModel
+		res, err := db.Query(&response, "SELECT * FROM test_models")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.RowsReturned() != 2 || res.RowsAffected() != 2 {
+			t.Fatalf("expected 2 rows; found %d returned and %d affected",
+				res.RowsReturned(), res.RowsAffected())
+		}
+
+		res, err = db.Query(&response, "SELECT * FROM test_models")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.RowsReturned() != 0 {
+			t.Fatal("expected 0 rows for an empty queue; found ", res.RowsReturned())
+		}
+	})
+
+	t.Run("QueryOne", func(t *testing.T) {
+		db := NewMockDB()
+		db.QueueResponses(TestModel{ID: 1})
+
+		res, err := db.QueryOne(&TestModel{}, "SELECT * FROM test_models WHERE id = 1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.RowsReturned() != 1 {
+			t.Fatal("expected 1 row; found ", res.RowsReturned())
+		}
+
+		res, err = db.QueryOne(&TestModel{}, "SELECT * FROM test_models WHERE id = 1")
+		if err != pg.ErrNoRows {
+			t.Fatal("expected pg.ErrNoRows; found ", err)
+		}
+		if res != nil {
+			t.Fatal("expected no result with pg.ErrNoRows")
+		}
+	})
+
+	t.Run("WillReturnResult", func(t *testing.T) {
+		db := NewMockDB()
+		db.ExpectQuery(`UPDATE test_models`).WillReturnResult(NewMockResult(nil, 3, 0))
+
+		res, err := db.Query(nil, "UPDATE test_models SET name = 'x'")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.RowsAffected() != 3 {
+			t.Fatal("expected 3 rows affected; found ", res.RowsAffected())
+		}
+	})
+
+	t.Run("Insert, Update and Delete", func(t *testing.T) {
+		db := NewMockDB()
+		tm := &TestModel{ID: 1, Name: "Test Model"}
+
+		if err := db.Insert(tm, &TestModel{ID: 2}); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Update(tm); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Delete(&TestModel{ID: 3}); err == nil {
+			t.Fatal("expected an error deleting a missing model")
+		}
+
+		calls := db.Calls()
+		if n := calls[0].Result.RowsAffected(); n != 2 {
+			t.Fatal("expected Insert to affect 2 rows; found ", n)
+		}
+		if n := calls[1].Result.RowsAffected(); n != 1 {
+			t.Fatal("expected Update to affect 1 row; found ", n)
+		}
+		if calls[2].Result != nil {
+			t.Fatal("expected no result for a failed Delete")
+		}
+	})
+}
@@ -0,0 +1,98 @@
+package testutils
+
+import (
+	"fmt"
+)
+
+// savepoint is the state of a transaction when a savepoint was created
+type savepoint struct {
+	name   string
+	models []interface{}
+	writes int
+}
+
+// Savepoint creates a savepoint in the transaction, which RollbackTo can undo
+// the changes made after and Release can remove. Like Postgres, a name may be
+// reused, in which case the newest savepoint with the name is used.
+func (tx *MockTx) Savepoint(name string) error {
+	tx.db.mu.Lock()
+	defer tx.db.mu.Unlock()
+	return tx.savepoint(name)
+}
+
+// savepoint creates a savepoint in the transaction
+func (tx *MockTx) savepoint(name string) error {
+	query := "SAVEPOINT " + name
+	err := tx.checkOpen()
+	if err == nil {
+		err = tx.db.injectedError("Savepoint", nil, query)
+	}
+	if err == nil {
+		tx.savepoints = append(tx.savepoints, savepoint{
+			name:   name,
+			models: copyModels(tx.models),
+			writes: len(tx.writes),
+		})
+	}
+	tx.db.record(tx.id, "Savepoint", nil, query, nil, nil, err)
+	return err
+}
+
+// RollbackTo undoes the changes made in the transaction since the named
+// savepoint was created and removes the savepoints created after it. The named
+// savepoint remains, so it can be rolled back to again.
+func (tx *MockTx) RollbackTo(name string) error {
+	tx.db.mu.Lock()
+	defer tx.db.mu.Unlock()
+
+	query := "ROLLBACK TO SAVEPOINT " + name
+	err := tx.checkOpen()
+	if err == nil {
+		err = tx.db.injectedError("RollbackTo", nil, query)
+	}
+	if err == nil {
+		var i int
+		i, err = tx.findSavepoint(name)
+		if err == nil {
+			sp := tx.savepoints[i]
+			tx.models = copyModels(sp.models)
+			tx.writes = tx.writes[:sp.writes]
+			tx.savepoints = tx.savepoints[:i+1]
+		}
+	}
+	tx.db.record(tx.id, "RollbackTo", nil, query, nil, nil, err)
+	return err
+}
+
+// Release removes the named savepoint and the savepoints created after it,
+// keeping the changes made since it was created
+func (tx *MockTx) Release(name string) error {
+	tx.db.mu.Lock()
+	defer tx.db.mu.Unlock()
+
+	query := "RELEASE SAVEPOINT " + name
+	err := tx.checkOpen()
+	if err == nil {
+		err = tx.db.injectedError("Release", nil, query)
+	}
+	if err == nil {
+		var i int
+		i, err = tx.findSavepoint(name)
+		if err == nil {
+			tx.savepoints = tx.savepoints[:i]
+		}
+	}
+	tx.db.record(tx.id, "Release", nil, query, nil, nil, err)
+	return err
+}
+
+// findSavepoint returns the index of the newest savepoint with the name, or an
+// error like Postgres returns if there is none
+func (tx *MockTx) findSavepoint(name string) (int, error) {
+	for i := len(tx.savepoints) - 1; i >= 0; i-- {
+		if tx.savepoints[i].name == name {
+			return i, nil
+		}
+	}
+	return -1, PGError(CodeInvalidSavepoint, fmt.Sprintf("savepoint %q does not exist", name))
+}
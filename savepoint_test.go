@@ -0,0 +1,106 @@
+package testutils
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestSavepoints(t *testing.T) {
+	t.Run("RollbackTo", func(t *testing.T) {
+		db := NewMockDB()
+		tx, _ := db.Begin()
+
+		if err := tx.Insert(&TestModel{ID: 1, Name: "Before"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := tx.Savepoint("sp"); err != nil {
+			t.Fatal(err)
+		}
+		if err := tx.Insert(&TestModel{ID: 2, Name: "After"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := tx.Update(&TestModel{ID: 1, Name: "Updated"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := tx.RollbackTo("sp"); err != nil {
+			t.Fatal(err)
+		}
+		if err := tx.Insert(&TestModel{ID: 3, Name: "After rollback"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := tx.Commit(); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(db.models) != 2 {
+			t.Fatal("expected 2 models after commit; found ", len(db.models))
+		}
+		if name := db.models[0].(*TestModel).Name; name != "Before" {
+			t.Fatal("expected update after savepoint to be rolled back; found ", name)
+		}
+		if id := db.models[1].(*TestModel).ID; id != 3 {
+			t.Fatal("expected insert after rollback to be kept; found ID ", id)
+		}
+	})
+
+	t.Run("Release", func(t *testing.T) {
+		db := NewMockDB()
+		tx, _ := db.Begin()
+
+		_ = tx.Savepoint("outer")
+		_ = tx.Insert(&TestModel{ID: 1})
+		_ = tx.Savepoint("inner")
+		if err := tx.Release("outer"); err != nil {
+			t.Fatal(err)
+		}
+		err := tx.RollbackTo("inner")
+		if errCode(err) != CodeInvalidSavepoint {
+			t.Fatal("expected released savepoints to be removed; found ", err)
+		}
+		if err := tx.Commit(); err != nil {
+			t.Fatal(err)
+		}
+		if len(db.models) != 1 {
+			t.Fatal("expected released changes to be committed")
+		}
+	})
+
+	t.Run("Nested RunInTransaction", func(t *testing.T) {
+		db := NewMockDB()
+		expected := errors.New("inner failure")
+
+		err := db.RunInTransaction(func(tx Tx) error {
+			mtx := tx.(*MockTx)
+			if err := tx.Insert(&TestModel{ID: 1, Name: "Outer"}); err != nil {
+				return err
+			}
+			err := mtx.RunInTransaction(func(inner *MockTx) error {
+				if err := inner.Insert(&TestModel{ID: 2, Name: "Failed"}); err != nil {
+					return err
+				}
+				return expected
+			})
+			if err != expected {
+				t.Fatalf("expected error %v from nested transaction; found %v", expected, err)
+			}
+			return mtx.RunInTransaction(func(inner *MockTx) error {
+				return inner.Insert(&TestModel{ID: 3, Name: "Succeeded"})
+			})
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(db.models) != 2 {
+			t.Fatal("expected 2 models after commit; found ", len(db.models))
+		}
+		for _, m := range db.models {
+			if m.(*TestModel).Name == "Failed" {
+				t.Fatal("changes of a failed nested transaction should be rolled back")
+			}
+		}
+		if n := len(db.Calls().Method("Savepoint", "RollbackTo", "Release")); n != 5 {
+			t.Fatal("expected 5 savepoint calls; found ", n)
+		}
+	})
+}
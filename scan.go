@@ -0,0 +1,125 @@
+package testutils
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/go-pg/pg/v9"
+)
+
+// scanResponse copies a response into the model passed to a query, mirroring
+// how go-pg scans rows. A slice response holds one row per element and any
+// other non-nil response is a single row.
+//
+// Slice models (*[]T or *[]*T) are filled with every row. Other models receive
+// the last row, like go-pg scanning each row into the same struct. When one is
+// true, as for QueryOne, the response must hold exactly one row or
+// pg.ErrNoRows or pg.ErrMultiRows is returned.
+func scanResponse(one bool, model, response interface{}) (pg.Result, error) {
+	rows := responseRows(response)
+	if one {
+		if len(rows) == 0 {
+			return nil, pg.ErrNoRows
+		}
+		if len(rows) > 1 {
+			return nil, pg.ErrMultiRows
+		}
+	}
+	if err := scanRows(model, rows); err != nil {
+		return nil, err
+	}
+	return NewMockResult(nil, len(rows), len(rows)), nil
+}
+
+// responseRows splits a response into rows
+func responseRows(response interface{}) []reflect.Value {
+	if response == nil {
+		return nil
+	}
+	v := reflect.ValueOf(response)
+	if !isSlice(v.Type()) {
+		return []reflect.Value{v}
+	}
+	rows := make([]reflect.Value, v.Len())
+	for i := range rows {
+		rows[i] = v.Index(i)
+	}
+	return rows
+}
+
+// scanRows copies rows into model, which must be a pointer unless it is nil
+func scanRows(model interface{}, rows []reflect.Value) error {
+	if model == nil {
+		return nil
+	}
+	v := reflect.ValueOf(model)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return fmt.Errorf("testutils: model must be a non-nil pointer, not %T", model)
+	}
+	dest := v.Elem()
+
+	if isSlice(dest.Type()) {
+		slice := reflect.MakeSlice(dest.Type(), len(rows), len(rows))
+		for i, row := range rows {
+			if err := assignValue(slice.Index(i), row); err != nil {
+				return err
+			}
+		}
+		dest.Set(slice)
+		return nil
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+	return assignValue(dest, rows[len(rows)-1])
+}
+
+// assignValue sets dst to src, dereferencing or taking the address of src and
+// converting between numeric types as needed
+func assignValue(dst, src reflect.Value) error {
+	for src.IsValid() && src.Kind() == reflect.Interface {
+		src = src.Elem()
+	}
+	if !src.IsValid() {
+		dst.Set(reflect.Zero(dst.Type()))
+		return nil
+	}
+
+	switch {
+	case src.Type().AssignableTo(dst.Type()):
+		dst.Set(src)
+	case src.Kind() == reflect.Ptr && src.Type().Elem().AssignableTo(dst.Type()):
+		if src.IsNil() {
+			dst.Set(reflect.Zero(dst.Type()))
+		} else {
+			dst.Set(src.Elem())
+		}
+	case dst.Kind() == reflect.Ptr && src.Type().AssignableTo(dst.Type().Elem()):
+		p := reflect.New(dst.Type().Elem())
+		p.Elem().Set(src)
+		dst.Set(p)
+	case isNumber(src.Kind()) && isNumber(dst.Kind()):
+		dst.Set(src.Convert(dst.Type()))
+	case src.Kind() == reflect.String && dst.Kind() == reflect.String:
+		dst.Set(src.Convert(dst.Type()))
+	default:
+		return fmt.Errorf("testutils: cannot scan %s into %s", src.Type(), dst.Type())
+	}
+	return nil
+}
+
+// isSlice returns whether t is a slice holding rows, rather than a []byte
+func isSlice(t reflect.Type) bool {
+	return t.Kind() == reflect.Slice && t.Elem().Kind() != reflect.Uint8
+}
+
+// isNumber returns whether values of kind k are integers or floats
+func isNumber(k reflect.Kind) bool {
+	switch k {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
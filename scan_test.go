@@ -0,0 +1,98 @@
+package testutils
+
+import (
+	"testing"
+
+	"github.com/go-pg/pg/v9"
+)
+
+func TestMultiRow(t *testing.T) {
+	rows := []TestModel{{ID: 1, Name: "One"}, {ID: 2, Name: "Two"}}
+
+	t.Run("Query slice of structs", func(t *testing.T) {
+		db := NewMockDB()
+		db.QueueResponses(rows)
+
+		var response []TestModel
+		if _, err := db.Query(&response, "SELECT * FROM test_models"); err != nil {
+			t.Fatal(err)
+		}
+		if len(response) != 2 || response[1].Name != "Two" {
+			t.Fatalf("unexpected response: %v", response)
+		}
+	})
+
+	t.Run("Query slice of pointers", func(t *testing.T) {
+		db := NewMockDB()
+		db.QueueResponses(rows, &rows[0])
+
+		var response []*TestModel
+		if _, err := db.Query(&response, "SELECT * FROM test_models"); err != nil {
+			t.Fatal(err)
+		}
+		if len(response) != 2 || response[0].Name != "One" || response[1].Name != "Two" {
+			t.Fatalf("unexpected response: %v", response)
+		}
+
+		if _, err := db.Query(&response, "SELECT * FROM test_models WHERE id = 1"); err != nil {
+			t.Fatal(err)
+		}
+		if len(response) != 1 || response[0].Name != "One" {
+			t.Fatalf("expected a single row for a single response; found %v", response)
+		}
+	})
+
+	t.Run("QueryOne", func(t *testing.T) {
+		db := NewMockDB()
+		db.QueueResponses(rows, []TestModel{}, rows[:1], &rows[1])
+
+		response := &TestModel{}
+		if _, err := db.QueryOne(response, "SELECT * FROM test_models"); err != pg.ErrMultiRows {
+			t.Fatal("expected pg.ErrMultiRows; found ", err)
+		}
+		if _, err := db.QueryOne(response, "SELECT * FROM test_models"); err != pg.ErrNoRows {
+			t.Fatal("expected pg.ErrNoRows; found ", err)
+		}
+		if _, err := db.QueryOne(response, "SELECT * FROM test_models"); err != nil {
+			t.Fatal(err)
+		}
+		if response.Name != "One" {
+			t.Fatalf("unexpected response: %v", response)
+		}
+		if _, err := db.QueryOne(response, "SELECT * FROM test_models"); err != nil {
+			t.Fatal(err)
+		}
+		if response.Name != "Two" {
+			t.Fatalf("unexpected response: %v", response)
+		}
+	})
+
+	t.Run("Scalars", func(t *testing.T) {
+		db := NewMockDB()
+		db.QueueResponses(int64(42), []int{1, 2, 3})
+
+		var count int
+		if _, err := db.QueryOne(&count, "SELECT count(*) FROM test_models"); err != nil {
+			t.Fatal(err)
+		}
+		if count != 42 {
+			t.Fatal("expected count of 42; found ", count)
+		}
+		var ids []int64
+		if _, err := db.Query(&ids, "SELECT id FROM test_models"); err != nil {
+			t.Fatal(err)
+		}
+		if len(ids) != 3 || ids[2] != 3 {
+			t.Fatalf("unexpected ids: %v", ids)
+		}
+	})
+
+	t.Run("Mismatched types", func(t *testing.T) {
+		db := NewMockDB()
+		db.QueueResponses("not a model")
+
+		if _, err := db.Query(&TestModel{}, "SELECT * FROM test_models"); err == nil {
+			t.Fatal("expected an error scanning a string into a struct")
+		}
+	})
+}
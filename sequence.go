@@ -0,0 +1,114 @@
+package testutils
+
+import (
+	"crypto/rand"
+	"reflect"
+)
+
+// SetSequence sets the next value of the primary key sequence for the type of
+// model, which Insert assigns to models of the type with a zero primary key.
+// By default a sequence starts at 1 and skips past any primary key inserted
+// explicitly, so tests can mix fixed and generated IDs.
+func (db *MockDB) SetSequence(model interface{}, next int64) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.sequences == nil {
+		db.sequences = make(map[reflect.Type]int64)
+	}
+	db.sequences[getTable(reflect.TypeOf(model)).typ] = next - 1
+}
+
+// generateKeys fills in zero primary keys of the models, like a serial or
+// uuid column's default fills them in Postgres and go-pg writes them back with
+// RETURNING. Like Postgres sequences, values are not reused after a rollback.
+func (db *MockDB) generateKeys(models []interface{}) error {
+	for _, m := range models {
+		v := reflect.ValueOf(m)
+		if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+			continue
+		}
+		tbl := getTable(v.Type())
+		if len(tbl.pks) != 1 {
+			continue
+		}
+		pk := tbl.pks[0]
+		f := pk.value(v.Elem())
+		if !f.IsValid() {
+			continue
+		}
+		if f.Kind() == reflect.Ptr && f.IsNil() {
+			// A nil key is only set if a value is generated for it
+			g := reflect.New(f.Type().Elem())
+			if err := db.generateKey(tbl, pk, g.Elem()); err != nil {
+				return err
+			}
+			if !g.Elem().IsZero() {
+				f.Set(g)
+			}
+			continue
+		}
+		if err := db.generateKey(tbl, pk, reflect.Indirect(f)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// generateKey sets the primary key field f to the next value of the table's
+// sequence or a random UUID if it is zero, or otherwise moves the sequence
+// past its value
+func (db *MockDB) generateKey(tbl *table, pk *column, f reflect.Value) error {
+	if db.sequences == nil {
+		db.sequences = make(map[reflect.Type]int64)
+	}
+	switch f.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if n := f.Int(); n != 0 {
+			if n > db.sequences[tbl.typ] {
+				db.sequences[tbl.typ] = n
+			}
+			return nil
+		}
+		db.sequences[tbl.typ]++
+		f.SetInt(db.sequences[tbl.typ])
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if n := int64(f.Uint()); n != 0 {
+			if n > db.sequences[tbl.typ] {
+				db.sequences[tbl.typ] = n
+			}
+			return nil
+		}
+		db.sequences[tbl.typ]++
+		f.SetUint(uint64(db.sequences[tbl.typ]))
+	case reflect.String:
+		if f.String() != "" || pk.options["type"] != "uuid" {
+			return nil
+		}
+		u, err := newUUID()
+		if err != nil {
+			return err
+		}
+		f.SetString(u.String())
+	case reflect.Array:
+		if f.Len() != 16 || f.Type().Elem().Kind() != reflect.Uint8 || !f.IsZero() {
+			return nil
+		}
+		u, err := newUUID()
+		if err != nil {
+			return err
+		}
+		reflect.Copy(f, reflect.ValueOf(u[:]))
+	}
+	return nil
+}
+
+// newUUID returns a random version 4 UUID
+func newUUID() (uuid, error) {
+	var u uuid
+	if _, err := rand.Read(u[:]); err != nil {
+		return u, err
+	}
+	u[6] = u[6]&0x0f | 0x40
+	u[8] = u[8]&0x3f | 0x80
+	return u, nil
+}
@@ -0,0 +1,135 @@
+package testutils
+
+import (
+	"errors"
+	"testing"
+)
+
+type Ticket struct {
+	ID   *int
+	Code *string `pg:",unique"`
+}
+
+type Session struct {
+	ID    string `pg:"id,pk,type:uuid"`
+	Token string
+}
+
+func TestSequence(t *testing.T) {
+	t.Run("Integer keys", func(t *testing.T) {
+		db := NewMockDB()
+		a, b := &Account{}, &Account{}
+		if err := db.Insert(a, b); err != nil {
+			t.Fatal(err)
+		}
+		if a.ID != 1 || b.ID != 2 {
+			t.Fatalf("expected IDs 1 and 2; found %d and %d", a.ID, b.ID)
+		}
+
+		if err := db.Insert(&Account{ID: 10}); err != nil {
+			t.Fatal(err)
+		}
+		c := &Account{}
+		if err := db.Insert(c); err != nil {
+			t.Fatal(err)
+		}
+		if c.ID != 11 {
+			t.Fatalf("expected the sequence to skip an explicit ID; found %d", c.ID)
+		}
+	})
+
+	t.Run("SetSequence", func(t *testing.T) {
+		db := NewMockDB()
+		db.SetSequence(&Account{}, 100)
+		a := &Account{}
+		if err := db.Insert(a); err != nil {
+			t.Fatal(err)
+		}
+		if a.ID != 100 {
+			t.Fatalf("expected ID 100; found %d", a.ID)
+		}
+		if c := (&Category{}); db.Insert(c) != nil || c.ID != 1 {
+			t.Fatalf("expected each type to have its own sequence; found %d", c.ID)
+		}
+	})
+
+	t.Run("UUID keys", func(t *testing.T) {
+		db := NewMockDB()
+		s, tag := &Session{}, &Tag{}
+		if err := db.Insert(s, tag); err != nil {
+			t.Fatal(err)
+		}
+		u, ok := parseUUID(s.ID)
+		if !ok || u[6]>>4 != 4 {
+			t.Fatalf("expected a version 4 UUID; found %q", s.ID)
+		}
+		if tag.ID == [16]byte{} {
+			t.Fatal("expected a generated UUID")
+		}
+		if found, _ := db.FindAny(&Session{ID: s.ID}); found == nil {
+			t.Fatal("expected to find the session by its generated ID")
+		}
+	})
+
+	t.Run("Transactions", func(t *testing.T) {
+		db := NewMockDB()
+		a := &Account{}
+		err := db.RunInTransaction(func(tx Tx) error {
+			if err := tx.Insert(a); err != nil {
+				return err
+			}
+			return errors.New("rollback")
+		})
+		if err == nil || a.ID != 1 {
+			t.Fatalf("expected ID 1 in the rolled back transaction; found %d", a.ID)
+		}
+
+		b := &Account{}
+		err = db.RunInTransaction(func(tx Tx) error {
+			return tx.Insert(b)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b.ID != 2 {
+			t.Fatalf("expected a rolled back ID not to be reused; found %d", b.ID)
+		}
+		if found, _ := db.FindAny(&Account{ID: 2}); found == nil {
+			t.Fatal("expected the committed account")
+		}
+	})
+	t.Run("Failed inserts", func(t *testing.T) {
+		db := NewMockDB()
+		db.SetForeignKeys(true)
+		b := &Book{AuthorID: 5}
+		if err := db.Insert(b); err == nil {
+			t.Fatal("expected a foreign key violation")
+		}
+		if b.ID != 0 {
+			t.Fatalf("expected a failed insert to leave the model unchanged; found %+v", b)
+		}
+
+		if err := db.Insert(&Order{ID: 1}); err != nil {
+			t.Fatal(err)
+		}
+		o := &Order{ID: 1}
+		if err := db.Insert(o); err == nil {
+			t.Fatal("expected a unique violation")
+		}
+		if *o != (Order{ID: 1}) {
+			t.Fatalf("expected defaults not to be set by a failed insert; found %+v", o)
+		}
+
+		code := "a"
+		if err := db.Insert(&Ticket{Code: &code}); err != nil {
+			t.Fatal(err)
+		}
+		tk := &Ticket{Code: &code}
+		err := db.RunInTransaction(func(tx Tx) error {
+			return tx.Insert(tk)
+		})
+		if err == nil || tk.ID != nil {
+			t.Fatalf("expected a failed insert to leave a nil key; found %v", tk.ID)
+		}
+	})
+}
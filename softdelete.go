@@ -0,0 +1,105 @@
+package testutils
+
+import (
+	"reflect"
+	"time"
+)
+
+// ForceDelete finds a model in the DB and removes it, even if it has a
+// soft_delete column or has been soft deleted, or returns an error if it is not
+// found
+func (db *MockDB) ForceDelete(model interface{}) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	err := db.injectedError("ForceDelete", []interface{}{model}, nil)
+	if err == nil {
+		err = db.delete(model, true)
+	}
+	db.record(0, "ForceDelete", model, nil, nil, writeResult(1, err), err)
+	return err
+}
+
+// Deleted returns the soft deleted model in the MockDB models with the same
+// type and ID as model, or nil if there is none, for asserting that a model
+// was soft deleted
+func (db *MockDB) Deleted(model interface{}) (interface{}, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return findDeleted(db.models, model)
+}
+
+// ForceDelete is an alias for DB.ForceDelete
+func (tx *MockTx) ForceDelete(model interface{}) error {
+	tx.db.mu.Lock()
+	defer tx.db.mu.Unlock()
+	err := tx.checkOpen()
+	if err == nil {
+		err = tx.db.injectedError("ForceDelete", []interface{}{model}, nil)
+	}
+	if err == nil {
+		err = tx.delete(model, true)
+	}
+	tx.db.record(tx.id, "ForceDelete", model, nil, nil, writeResult(1, err), err)
+	return err
+}
+
+// Deleted is an alias for DB.Deleted, which sees the transaction's changes
+func (tx *MockTx) Deleted(model interface{}) (interface{}, error) {
+	tx.db.mu.Lock()
+	defer tx.db.mu.Unlock()
+	return findDeleted(tx.models, model)
+}
+
+// forceDeleteModel finds a model in models, including soft deleted models, and
+// returns models without it, or returns an error if it is not found
+func forceDeleteModel(models []interface{}, model interface{}) ([]interface{}, error) {
+	i, err := indexModel(models, model, true)
+	if err != nil {
+		return models, err
+	}
+	if i < 0 {
+		return models, notFoundError(model, "delete")
+	}
+	return append(models[:i], models[i+1:]...), nil
+}
+
+// findDeleted returns the soft deleted model in models with the same type and
+// primary key as model, or nil
+func findDeleted(models []interface{}, model interface{}) (interface{}, error) {
+	i, err := indexModel(models, model, true)
+	if err != nil || i < 0 || !isSoftDeleted(models[i]) {
+		return nil, err
+	}
+	return models[i], nil
+}
+
+// softDeleteValue returns the soft_delete column of a model, or an invalid
+// Value if it has none
+func softDeleteValue(model interface{}) reflect.Value {
+	v := reflect.ValueOf(model)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return reflect.Value{}
+	}
+	c := getTable(v.Type()).softDelete
+	if c == nil {
+		return reflect.Value{}
+	}
+	return c.value(v.Elem())
+}
+
+// isSoftDeleted returns whether a model's soft_delete column is set
+func isSoftDeleted(model interface{}) bool {
+	f := softDeleteValue(model)
+	return f.IsValid() && !f.IsZero()
+}
+
+// markDeleted sets a model's soft_delete column to now, returning false if it
+// has none
+func markDeleted(model interface{}, now time.Time) bool {
+	f := softDeleteValue(model)
+	if !f.IsValid() {
+		return false
+	}
+	setTime(f, now)
+	return true
+}
@@ -0,0 +1,94 @@
+package testutils
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-pg/pg/v9"
+)
+
+type Post struct {
+	ID        int
+	Title     string
+	DeletedAt time.Time `pg:",soft_delete"`
+}
+
+func TestSoftDelete(t *testing.T) {
+	deletedAt := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	t.Run("Delete", func(t *testing.T) {
+		db := NewMockDB()
+		db.SetClock(NewFakeClock(deletedAt))
+		db.QueueModels(&Post{ID: 1, Title: "Hello"})
+
+		p := &Post{ID: 1}
+		if err := db.Delete(p); err != nil {
+			t.Fatal(err)
+		}
+		if !p.DeletedAt.Equal(deletedAt) {
+			t.Fatalf("expected DeletedAt %v; found %v", deletedAt, p.DeletedAt)
+		}
+		if found, _ := db.FindAny(&Post{ID: 1}); found != nil {
+			t.Fatal("expected Find to hide the soft deleted post")
+		}
+		if err := db.Select(&Post{ID: 1}); err != pg.ErrNoRows {
+			t.Fatalf("expected pg.ErrNoRows; found %v", err)
+		}
+		if err := db.Update(&Post{ID: 1}); err == nil {
+			t.Fatal("expected an error updating a soft deleted post")
+		}
+		if err := db.Delete(&Post{ID: 1}); err == nil {
+			t.Fatal("expected an error deleting a soft deleted post again")
+		}
+
+		deleted, err := db.Deleted(&Post{ID: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if deleted == nil || deleted.(*Post).Title != "Hello" {
+			t.Fatalf("expected the soft deleted post; found %v", deleted)
+		}
+	})
+
+	t.Run("ForceDelete", func(t *testing.T) {
+		db := NewMockDB()
+		db.QueueModels(&Post{ID: 1}, &Post{ID: 2})
+		if err := db.Delete(&Post{ID: 1}); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.ForceDelete(&Post{ID: 1}); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.ForceDelete(&Post{ID: 2}); err != nil {
+			t.Fatal(err)
+		}
+		if len(db.models) != 0 {
+			t.Fatalf("expected no models; found %d", len(db.models))
+		}
+		if deleted, _ := db.Deleted(&Post{ID: 1}); deleted != nil {
+			t.Fatal("expected the post to be removed")
+		}
+	})
+
+	t.Run("Transactions", func(t *testing.T) {
+		db := NewMockDB()
+		db.SetClock(NewFakeClock(deletedAt))
+		db.QueueModels(&Post{ID: 1}, &Post{ID: 2})
+		err := db.RunInTransaction(func(tx Tx) error {
+			if err := tx.Delete(&Post{ID: 1}); err != nil {
+				return err
+			}
+			return tx.ForceDelete(&Post{ID: 2})
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		deleted, _ := db.Deleted(&Post{ID: 1})
+		if deleted == nil || !deleted.(*Post).DeletedAt.Equal(deletedAt) {
+			t.Fatalf("expected post 1 to be soft deleted; found %v", deleted)
+		}
+		if len(db.models) != 1 {
+			t.Fatalf("expected post 2 to be removed; found %d models", len(db.models))
+		}
+	})
+}
@@ -0,0 +1,575 @@
+package testutils
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// rowSource is a table in the FROM clause of a query, with the row of it an
+// expression is being evaluated against
+type rowSource struct {
+	tbl   *table
+	name  string
+	alias string
+	// row is the struct of the current row, or an invalid Value for the nulls
+	// of an outer join with no matching row
+	row reflect.Value
+	// model is the stored model holding row
+	model interface{}
+}
+
+// evalContext is what an expression is evaluated against: the current row of
+// each table, and the time now() returns
+type evalContext struct {
+	sources []*rowSource
+	now     time.Time
+	// group is the group of rows aggregate functions are evaluated over in a
+	// grouped query
+	group *rowGroup
+}
+
+// lookup returns the source and column a column reference refers to, or an
+// error if there is none or an unqualified name is in more than one table
+func (ctx *evalContext) lookup(qualifier, name string) (*rowSource, *column, error) {
+	var found *rowSource
+	var col *column
+	for _, s := range ctx.sources {
+		if qualifier != "" && qualifier != s.alias && qualifier != s.name {
+			continue
+		}
+		c := s.tbl.column(name)
+		if c == nil {
+			continue
+		}
+		if found != nil {
+			return nil, nil, PGError(CodeAmbiguousColumn,
+				fmt.Sprintf("column reference %q is ambiguous", name))
+		}
+		found, col = s, c
+	}
+	if found == nil {
+		if qualifier != "" {
+			name = qualifier + "." + name
+		}
+		return nil, nil, PGError(CodeUndefinedColumn, fmt.Sprintf("column %s does not exist", name))
+	}
+	return found, col, nil
+}
+
+// field returns the field of a column reference in the current row, or an
+// invalid Value if the row is the nulls of an outer join
+func (ctx *evalContext) field(e *columnExpr) (*column, reflect.Value, error) {
+	s, c, err := ctx.lookup(e.table, e.name)
+	if err != nil {
+		return nil, reflect.Value{}, err
+	}
+	if !s.row.IsValid() {
+		return c, reflect.Value{}, nil
+	}
+	return c, c.value(s.row), nil
+}
+
+func (e *literalExpr) eval(ctx *evalContext) (interface{}, error) {
+	return e.value, nil
+}
+
+func (e *columnExpr) eval(ctx *evalContext) (interface{}, error) {
+	c, f, err := ctx.field(e)
+	if err != nil {
+		return nil, err
+	}
+	return columnValue(c, f), nil
+}
+
+func (e *starExpr) eval(ctx *evalContext) (interface{}, error) {
+	return nil, syntaxError("syntax error at or near \"*\"")
+}
+
+func (e *unaryExpr) eval(ctx *evalContext) (interface{}, error) {
+	x, err := e.x.eval(ctx)
+	if err != nil || x == nil {
+		return nil, err
+	}
+	switch e.op {
+	case "not":
+		b, err := boolValue(x)
+		if err != nil {
+			return nil, err
+		}
+		return !b, nil
+	case "-":
+		return arithmetic("-", int64(0), x)
+	}
+	return arithmetic("+", int64(0), x)
+}
+
+func (e *binaryExpr) eval(ctx *evalContext) (interface{}, error) {
+	if e.op == "and" || e.op == "or" {
+		return e.logical(ctx)
+	}
+	l, err := e.l.eval(ctx)
+	if err != nil {
+		return nil, err
+	}
+	r, err := e.r.eval(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if l == nil || r == nil {
+		return nil, nil
+	}
+	switch e.op {
+	case "=", "<>", "<", ">", "<=", ">=":
+		return compareOp(e.op, l, r)
+	case "||":
+		return concatValues(l, r), nil
+	}
+	return arithmetic(e.op, l, r)
+}
+
+// logical evaluates AND or OR with Postgres's three-valued logic, where null
+// is unknown
+func (e *binaryExpr) logical(ctx *evalContext) (interface{}, error) {
+	l, err := evalBool(ctx, e.l)
+	if err != nil {
+		return nil, err
+	}
+	// Decided by the left operand, like Postgres short-circuiting
+	if l != nil && *l == (e.op == "or") {
+		return *l, nil
+	}
+	r, err := evalBool(ctx, e.r)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case r != nil && *r == (e.op == "or"):
+		return *r, nil
+	case l == nil || r == nil:
+		return nil, nil
+	}
+	return e.op == "and", nil
+}
+
+func (e *isExpr) eval(ctx *evalContext) (interface{}, error) {
+	x, err := e.x.eval(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var result bool
+	switch e.what {
+	case "null":
+		result = x == nil
+	case "true", "false":
+		b, err := boolValue(x)
+		if err != nil {
+			return nil, err
+		}
+		result = x != nil && b == (e.what == "true")
+	case "distinct":
+		y, err := e.from.eval(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if x == nil || y == nil {
+			result = (x == nil) != (y == nil)
+		} else {
+			eq, err := equalValues(x, y)
+			if err != nil {
+				return nil, err
+			}
+			result = !eq
+		}
+	}
+	return result != e.not, nil
+}
+
+func (e *inExpr) eval(ctx *evalContext) (interface{}, error) {
+	x, err := e.x.eval(ctx)
+	if err != nil || x == nil {
+		return nil, err
+	}
+	var list []interface{}
+	for _, item := range e.list {
+		v, err := item.eval(ctx)
+		if err != nil {
+			return nil, err
+		}
+		// A slice passed for IN (?) without pg.In holds the whole list
+		if arr, ok := v.([]interface{}); ok {
+			list = append(list, arr...)
+		} else {
+			list = append(list, v)
+		}
+	}
+	found, err := anyEqual(x, list)
+	if err != nil || found == nil {
+		return nil, err
+	}
+	return *found != e.not, nil
+}
+
+func (e *betweenExpr) eval(ctx *evalContext) (interface{}, error) {
+	x, err := e.x.eval(ctx)
+	if err != nil {
+		return nil, err
+	}
+	lo, err := e.lo.eval(ctx)
+	if err != nil {
+		return nil, err
+	}
+	hi, err := e.hi.eval(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if x == nil || lo == nil || hi == nil {
+		return nil, nil
+	}
+	c1, err := compareValues(x, lo)
+	if err != nil {
+		return nil, err
+	}
+	c2, err := compareValues(x, hi)
+	if err != nil {
+		return nil, err
+	}
+	return (c1 >= 0 && c2 <= 0) != e.not, nil
+}
+
+func (e *likeExpr) eval(ctx *evalContext) (interface{}, error) {
+	x, err := e.x.eval(ctx)
+	if err != nil {
+		return nil, err
+	}
+	pattern, err := e.pattern.eval(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if x == nil || pattern == nil {
+		return nil, nil
+	}
+	re, err := likeRegexp(textValue(pattern), e.fold)
+	if err != nil {
+		return nil, err
+	}
+	return re.MatchString(textValue(x)) != e.not, nil
+}
+
+// likeRegexp converts a LIKE pattern to a regular expression, where % matches
+// any text, _ any character and \ escapes the next character
+func likeRegexp(pattern string, fold bool) (*regexp.Regexp, error) {
+	var b strings.Builder
+	b.WriteString("^")
+	if fold {
+		b.WriteString("(?is)")
+	} else {
+		b.WriteString("(?s)")
+	}
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; c {
+		case '%':
+			b.WriteString(".*")
+		case '_':
+			b.WriteString(".")
+		case '\\':
+			if i+1 < len(pattern) {
+				i++
+				b.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+			}
+		default:
+			b.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		}
+	}
+	b.WriteString("$")
+	return regexp.Compile(b.String())
+}
+
+func (e *funcExpr) eval(ctx *evalContext) (interface{}, error) {
+	if isAggregate(e.name) {
+		return e.aggregate(ctx)
+	}
+	args := make([]interface{}, len(e.args))
+	for i, a := range e.args {
+		v, err := a.eval(ctx)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = v
+	}
+	return callFunction(ctx, e.name, args)
+}
+
+func (e *caseExpr) eval(ctx *evalContext) (interface{}, error) {
+	var operand interface{}
+	if e.operand != nil {
+		v, err := e.operand.eval(ctx)
+		if err != nil {
+			return nil, err
+		}
+		operand = v
+	}
+	for _, w := range e.whens {
+		var match bool
+		if e.operand != nil {
+			v, err := w.cond.eval(ctx)
+			if err != nil {
+				return nil, err
+			}
+			if operand != nil && v != nil {
+				if match, err = equalValues(operand, v); err != nil {
+					return nil, err
+				}
+			}
+		} else {
+			b, err := evalBool(ctx, w.cond)
+			if err != nil {
+				return nil, err
+			}
+			match = b != nil && *b
+		}
+		if match {
+			return w.result.eval(ctx)
+		}
+	}
+	if e.els != nil {
+		return e.els.eval(ctx)
+	}
+	return nil, nil
+}
+
+func (e *castExpr) eval(ctx *evalContext) (interface{}, error) {
+	x, err := e.x.eval(ctx)
+	if err != nil || x == nil {
+		return nil, err
+	}
+	return castValue(x, e.typ)
+}
+
+func (e *quantifiedExpr) eval(ctx *evalContext) (interface{}, error) {
+	x, err := e.x.eval(ctx)
+	if err != nil {
+		return nil, err
+	}
+	v, err := e.arr.eval(ctx)
+	if err != nil || x == nil || v == nil {
+		return nil, err
+	}
+	arr, err := arrayValue(v)
+	if err != nil {
+		return nil, err
+	}
+	sawNull := false
+	for _, item := range arr {
+		if item == nil {
+			sawNull = true
+			continue
+		}
+		r, err := compareOp(e.op, x, item)
+		if err != nil {
+			return nil, err
+		}
+		if r.(bool) != e.all {
+			return !e.all, nil
+		}
+	}
+	if sawNull {
+		return nil, nil
+	}
+	return e.all, nil
+}
+
+func (e *arrayExpr) eval(ctx *evalContext) (interface{}, error) {
+	arr := make([]interface{}, len(e.elems))
+	for i, el := range e.elems {
+		v, err := el.eval(ctx)
+		if err != nil {
+			return nil, err
+		}
+		arr[i] = v
+	}
+	return arr, nil
+}
+
+// evalBool evaluates a condition, returning nil if it is null
+func evalBool(ctx *evalContext, e expr) (*bool, error) {
+	v, err := e.eval(ctx)
+	if err != nil || v == nil {
+		return nil, err
+	}
+	b, err := boolValue(v)
+	if err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+// evalCondition evaluates a WHERE, HAVING or ON condition, which holds only if
+// it is true rather than false or null
+func evalCondition(ctx *evalContext, e expr) (bool, error) {
+	b, err := evalBool(ctx, e)
+	if err != nil {
+		return false, err
+	}
+	return b != nil && *b, nil
+}
+
+// anyEqual returns whether x equals any value in list, or nil if it does not
+// but list holds a null, like Postgres's IN
+func anyEqual(x interface{}, list []interface{}) (*bool, error) {
+	found, sawNull := false, false
+	for _, v := range list {
+		if v == nil {
+			sawNull = true
+			continue
+		}
+		eq, err := equalValues(x, v)
+		if err != nil {
+			return nil, err
+		}
+		if eq {
+			found = true
+			break
+		}
+	}
+	if !found && sawNull {
+		return nil, nil
+	}
+	return &found, nil
+}
+
+// callFunction calls a scalar SQL function
+func callFunction(ctx *evalContext, name string, args []interface{}) (interface{}, error) {
+	nargs := func(n int) error {
+		if len(args) != n {
+			return undefinedFunction(name, args)
+		}
+		return nil
+	}
+	switch name {
+	case "now", "current_timestamp", "localtimestamp", "transaction_timestamp",
+		"statement_timestamp", "clock_timestamp":
+		return ctx.now, nargs(0)
+	case "current_date":
+		y, m, d := ctx.now.Date()
+		return time.Date(y, m, d, 0, 0, 0, 0, ctx.now.Location()), nargs(0)
+	case "coalesce":
+		for _, a := range args {
+			if a != nil {
+				return a, nil
+			}
+		}
+		return nil, nil
+	case "nullif":
+		if err := nargs(2); err != nil {
+			return nil, err
+		}
+		if args[0] == nil || args[1] == nil {
+			return args[0], nil
+		}
+		eq, err := equalValues(args[0], args[1])
+		if err != nil || eq {
+			return nil, err
+		}
+		return args[0], nil
+	case "greatest", "least":
+		var best interface{}
+		for _, a := range args {
+			if a == nil {
+				continue
+			}
+			if best == nil {
+				best = a
+				continue
+			}
+			c, err := compareValues(a, best)
+			if err != nil {
+				return nil, err
+			}
+			if (c > 0) == (name == "greatest") && c != 0 {
+				best = a
+			}
+		}
+		return best, nil
+	case "concat":
+		var b strings.Builder
+		for _, a := range args {
+			if a != nil {
+				b.WriteString(textValue(a))
+			}
+		}
+		return b.String(), nil
+	}
+
+	// The rest are strict, returning null for a null argument
+	for _, a := range args {
+		if a == nil {
+			return nil, nil
+		}
+	}
+	switch name {
+	case "lower", "upper", "length", "char_length", "trim", "btrim", "ltrim", "rtrim":
+		if err := nargs(1); err != nil {
+			return nil, err
+		}
+		s := textValue(args[0])
+		switch name {
+		case "lower":
+			return strings.ToLower(s), nil
+		case "upper":
+			return strings.ToUpper(s), nil
+		case "trim", "btrim":
+			return strings.Trim(s, " "), nil
+		case "ltrim":
+			return strings.TrimLeft(s, " "), nil
+		case "rtrim":
+			return strings.TrimRight(s, " "), nil
+		}
+		return int64(len([]rune(s))), nil
+	case "abs", "round", "floor", "ceil", "ceiling":
+		if err := nargs(1); err != nil {
+			return nil, err
+		}
+		switch x := args[0].(type) {
+		case int64:
+			if name == "abs" && x < 0 {
+				return -x, nil
+			}
+			return x, nil
+		case float64:
+			switch name {
+			case "abs":
+				return math.Abs(x), nil
+			case "round":
+				return math.Round(x), nil
+			case "floor":
+				return math.Floor(x), nil
+			}
+			return math.Ceil(x), nil
+		}
+	case "array_length", "cardinality":
+		arr, err := arrayValue(args[0])
+		if err != nil {
+			return nil, err
+		}
+		if len(arr) == 0 {
+			return nil, nil
+		}
+		return int64(len(arr)), nil
+	}
+	return nil, undefinedFunction(name, args)
+}
+
+// undefinedFunction returns the error Postgres gives for a call to a function
+// which does not exist with the types of the arguments
+func undefinedFunction(name string, args []interface{}) error {
+	types := make([]string, len(args))
+	for i, a := range args {
+		types[i] = typeName(a)
+	}
+	return PGError(CodeUndefinedFunction,
+		fmt.Sprintf("function %s(%s) does not exist", name, strings.Join(types, ", ")))
+}
@@ -0,0 +1,771 @@
+package testutils
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// expr is a parsed SQL expression
+type expr interface {
+	eval(ctx *evalContext) (interface{}, error)
+}
+
+// literalExpr is a constant, or a param substituted for a placeholder. String
+// constants are converted to the type of the value they are compared with.
+type literalExpr struct {
+	value interface{}
+}
+
+// columnExpr is a reference to a column, optionally qualified by the name or
+// alias of its table
+type columnExpr struct {
+	table string
+	name  string
+}
+
+// starExpr is * or table.* in a select list or count(*)
+type starExpr struct {
+	table string
+}
+
+// unaryExpr is NOT, - or + applied to an expression
+type unaryExpr struct {
+	op string
+	x  expr
+}
+
+// binaryExpr is a logical, comparison, arithmetic or concatenation operator
+type binaryExpr struct {
+	op   string
+	l, r expr
+}
+
+// isExpr is x IS [NOT] NULL, TRUE or FALSE, or x IS [NOT] DISTINCT FROM y
+type isExpr struct {
+	x    expr
+	not  bool
+	what string
+	from expr
+}
+
+// inExpr is x [NOT] IN (list)
+type inExpr struct {
+	x    expr
+	list []expr
+	not  bool
+}
+
+// betweenExpr is x [NOT] BETWEEN lo AND hi
+type betweenExpr struct {
+	x, lo, hi expr
+	not       bool
+}
+
+// likeExpr is x [NOT] LIKE or ILIKE pattern
+type likeExpr struct {
+	x, pattern expr
+	not        bool
+	fold       bool
+}
+
+// funcExpr is a function call, like lower(name) or count(*)
+type funcExpr struct {
+	name     string
+	args     []expr
+	star     bool
+	distinct bool
+}
+
+// caseExpr is a CASE expression, with an operand for the simple form
+type caseExpr struct {
+	operand expr
+	whens   []caseWhen
+	els     expr
+}
+
+// caseWhen is a WHEN condition THEN result clause of a CASE expression
+type caseWhen struct {
+	cond, result expr
+}
+
+// castExpr is x::type or CAST(x AS type)
+type castExpr struct {
+	x   expr
+	typ string
+}
+
+// quantifiedExpr is x op ANY (array) or x op ALL (array)
+type quantifiedExpr struct {
+	op  string
+	x   expr
+	arr expr
+	all bool
+}
+
+// arrayExpr is ARRAY[elems]
+type arrayExpr struct {
+	elems []expr
+}
+
+// Operator precedences, from loosest to tightest binding, following Postgres
+const (
+	precOr = iota + 1
+	precAnd
+	precNot
+	precCompare
+	precConcat
+	precAdd
+	precMul
+	precUnary
+	precCast
+)
+
+// parser builds expressions and statements from tokens
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+// parseExpr parses the SQL of a complete expression, like a Where condition
+func parseExpr(sql string, params []interface{}, tbl *table, model interface{}) (expr, error) {
+	tokens, err := lexSQL(sql, params, tbl, model)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	e, err := p.expr(precOr)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.end(); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// peek returns the next token without consuming it
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+// peekAt returns the token n places after the next token
+func (p *parser) peekAt(n int) token {
+	if p.pos+n >= len(p.tokens) {
+		return token{kind: tokEOF}
+	}
+	return p.tokens[p.pos+n]
+}
+
+// next consumes and returns the next token
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+// accept consumes the keywords or operators if the next tokens match them all,
+// and returns whether they did
+func (p *parser) accept(words ...string) bool {
+	for i, w := range words {
+		if !p.peekAt(i).is(w) {
+			return false
+		}
+	}
+	p.pos += len(words)
+	return true
+}
+
+// expect consumes the keywords or operators or returns a syntax error
+func (p *parser) expect(words ...string) error {
+	for _, w := range words {
+		if !p.accept(w) {
+			return p.unexpected()
+		}
+	}
+	return nil
+}
+
+// end returns a syntax error unless all tokens have been consumed, allowing a
+// trailing semicolon
+func (p *parser) end() error {
+	p.accept(";")
+	if p.peek().kind != tokEOF {
+		return p.unexpected()
+	}
+	return nil
+}
+
+// unexpected returns a syntax error at the next token, or an unsupported error
+// if the token starts Postgres syntax MockDB does not parse
+func (p *parser) unexpected() error {
+	t := p.peek()
+	switch {
+	case t.kind == tokIdent && isUnsupportedKeyword(t.text):
+		return unsupported(strings.ToUpper(t.text) + " clauses")
+	case t.kind == tokOp && strings.Contains("~@&#^|!?", t.text) && p.peekAt(1).kind != tokEOF:
+		// An operator at the end of input, like a ? with no param, is left a
+		// syntax error
+		return unsupported(t.text + " operators")
+	}
+	return syntaxError("syntax error at or near %q", t.String())
+}
+
+// ident consumes an identifier, quoted or not
+func (p *parser) ident() (string, error) {
+	t := p.peek()
+	if t.kind != tokIdent && t.kind != tokQuotedIdent {
+		return "", p.unexpected()
+	}
+	p.pos++
+	return t.text, nil
+}
+
+// expr parses an expression whose operators bind at least as tightly as prec
+func (p *parser) expr(prec int) (expr, error) {
+	left, err := p.prefix()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		opPrec := p.infixPrec()
+		if opPrec == 0 || opPrec < prec {
+			return left, nil
+		}
+		left, err = p.infix(left, opPrec)
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// infixPrec returns the precedence of the operator at the next token, or 0 if
+// it is not one
+func (p *parser) infixPrec() int {
+	t := p.peek()
+	if t.kind == tokOp {
+		switch t.text {
+		case "=", "<>", "!=", "<", ">", "<=", ">=":
+			return precCompare
+		case "||":
+			return precConcat
+		case "+", "-":
+			return precAdd
+		case "*", "/", "%":
+			return precMul
+		case "::":
+			return precCast
+		}
+		return 0
+	}
+	if t.kind != tokIdent {
+		return 0
+	}
+	switch t.text {
+	case "or":
+		return precOr
+	case "and":
+		return precAnd
+	case "is", "in", "like", "ilike", "between":
+		return precCompare
+	case "not":
+		switch p.peekAt(1).text {
+		case "in", "like", "ilike", "between":
+			return precCompare
+		}
+	}
+	return 0
+}
+
+// infix parses the operator at the next token and its right operand
+func (p *parser) infix(left expr, prec int) (expr, error) {
+	t := p.next()
+	op := t.text
+	switch op {
+	case "::":
+		typ, err := p.typeName()
+		if err != nil {
+			return nil, err
+		}
+		return &castExpr{left, typ}, nil
+	case "is":
+		return p.is(left)
+	}
+	not := false
+	if op == "not" {
+		not = true
+		op = p.next().text
+	}
+	switch op {
+	case "in":
+		list, err := p.list()
+		if err != nil {
+			return nil, err
+		}
+		return &inExpr{left, list, not}, nil
+	case "between":
+		lo, err := p.expr(precConcat)
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect("and"); err != nil {
+			return nil, err
+		}
+		hi, err := p.expr(precConcat)
+		if err != nil {
+			return nil, err
+		}
+		return &betweenExpr{left, lo, hi, not}, nil
+	case "like", "ilike":
+		pattern, err := p.expr(precConcat)
+		if err != nil {
+			return nil, err
+		}
+		return &likeExpr{left, pattern, not, op == "ilike"}, nil
+	}
+	if op == "!=" {
+		op = "<>"
+	}
+	if prec == precCompare && (p.peek().is("any") || p.peek().is("some") || p.peek().is("all")) &&
+		p.peekAt(1).is("(") {
+		all := p.next().text == "all"
+		p.next()
+		arr, err := p.expr(precOr)
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		return &quantifiedExpr{op, left, arr, all}, nil
+	}
+	right, err := p.expr(prec + 1)
+	if err != nil {
+		return nil, err
+	}
+	return &binaryExpr{op, left, right}, nil
+}
+
+// is parses the rest of an IS expression
+func (p *parser) is(left expr) (expr, error) {
+	e := &isExpr{x: left, not: p.accept("not")}
+	switch {
+	case p.accept("null"):
+		e.what = "null"
+	case p.accept("true"):
+		e.what = "true"
+	case p.accept("false"):
+		e.what = "false"
+	case p.accept("distinct", "from"):
+		from, err := p.expr(precConcat)
+		if err != nil {
+			return nil, err
+		}
+		e.what, e.from = "distinct", from
+	default:
+		return nil, p.unexpected()
+	}
+	return e, nil
+}
+
+// list parses a parenthesized list of expressions
+func (p *parser) list() ([]expr, error) {
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	if p.peek().is("select") {
+		return nil, unsupported("subqueries")
+	}
+	var list []expr
+	for !p.accept(")") {
+		if len(list) > 0 {
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+		e, err := p.expr(precOr)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, e)
+	}
+	return list, nil
+}
+
+// prefix parses a primary expression or one with a prefix operator
+func (p *parser) prefix() (expr, error) {
+	t := p.peek()
+	switch {
+	case t.is("not"):
+		p.next()
+		x, err := p.expr(precNot)
+		if err != nil {
+			return nil, err
+		}
+		return &unaryExpr{"not", x}, nil
+	case t.is("-") || t.is("+"):
+		p.next()
+		x, err := p.expr(precUnary)
+		if err != nil {
+			return nil, err
+		}
+		return &unaryExpr{t.text, x}, nil
+	}
+	return p.primary()
+}
+
+// primary parses a constant, column, function call, parenthesized expression
+// or other expression without operators
+func (p *parser) primary() (expr, error) {
+	t := p.next()
+	switch t.kind {
+	case tokNumber:
+		if !strings.ContainsAny(t.text, ".eE") {
+			if n, err := strconv.ParseInt(t.text, 10, 64); err == nil {
+				return &literalExpr{value: n}, nil
+			}
+		}
+		f, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, syntaxError("invalid number %q", t.text)
+		}
+		return &literalExpr{value: f}, nil
+	case tokString:
+		return &literalExpr{value: t.text}, nil
+	case tokValue:
+		return &literalExpr{value: t.value}, nil
+	case tokQuotedIdent:
+		return p.column(t.text)
+	case tokOp:
+		switch t.text {
+		case "(":
+			if p.peek().is("select") {
+				return nil, unsupported("subqueries")
+			}
+			e, err := p.expr(precOr)
+			if err != nil {
+				return nil, err
+			}
+			if p.peek().is(",") {
+				return nil, unsupported("row constructors")
+			}
+			if err := p.expect(")"); err != nil {
+				return nil, err
+			}
+			return e, nil
+		case "*":
+			return &starExpr{}, nil
+		}
+		p.pos--
+		return nil, p.unexpected()
+	case tokIdent:
+		return p.keyword(t)
+	}
+	p.pos--
+	return nil, p.unexpected()
+}
+
+// keyword parses a primary expression starting with an unquoted identifier
+func (p *parser) keyword(t token) (expr, error) {
+	switch t.text {
+	case "null":
+		return &literalExpr{}, nil
+	case "true", "false":
+		return &literalExpr{value: t.text == "true"}, nil
+	case "case":
+		return p.caseExpr()
+	case "cast":
+		if err := p.expect("("); err != nil {
+			return nil, err
+		}
+		x, err := p.expr(precOr)
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect("as"); err != nil {
+			return nil, err
+		}
+		typ, err := p.typeName()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		return &castExpr{x, typ}, nil
+	case "array":
+		if err := p.expect("["); err != nil {
+			return nil, err
+		}
+		arr := &arrayExpr{}
+		for !p.accept("]") {
+			if len(arr.elems) > 0 {
+				if err := p.expect(","); err != nil {
+					return nil, err
+				}
+			}
+			e, err := p.expr(precOr)
+			if err != nil {
+				return nil, err
+			}
+			arr.elems = append(arr.elems, e)
+		}
+		return arr, nil
+	case "exists":
+		return nil, unsupported("subqueries")
+	case "current_timestamp", "localtimestamp", "current_date":
+		if !p.peek().is("(") {
+			return &funcExpr{name: t.text}, nil
+		}
+	case "interval", "timestamp", "timestamptz", "date", "uuid":
+		if p.peek().kind == tokString {
+			s := p.next().text
+			return &castExpr{&literalExpr{value: s}, t.text}, nil
+		}
+	}
+	if p.peek().is("(") {
+		return p.call(t.text)
+	}
+	if isReserved(t.text) {
+		p.pos--
+		return nil, p.unexpected()
+	}
+	return p.column(t.text)
+}
+
+// column parses a column reference starting with the identifier name, which
+// may be the table qualifying the column
+func (p *parser) column(name string) (expr, error) {
+	if !p.accept(".") {
+		return &columnExpr{name: name}, nil
+	}
+	if p.accept("*") {
+		return &starExpr{table: name}, nil
+	}
+	col, err := p.ident()
+	if err != nil {
+		return nil, err
+	}
+	return &columnExpr{table: name, name: col}, nil
+}
+
+// call parses the arguments of a call to the named function
+func (p *parser) call(name string) (expr, error) {
+	p.next()
+	f := &funcExpr{name: name}
+	if p.accept("*") {
+		f.star = true
+		return f, p.expect(")")
+	}
+	f.distinct = p.accept("distinct")
+	for !p.accept(")") {
+		if len(f.args) > 0 {
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+		e, err := p.expr(precOr)
+		if err != nil {
+			return nil, err
+		}
+		if p.peek().kind == tokIdent {
+			// Like extract(year FROM x) or trim(both FROM x)
+			return nil, unsupported(fmt.Sprintf("%s calls with keywords", name))
+		}
+		f.args = append(f.args, e)
+	}
+	return f, nil
+}
+
+// caseExpr parses the rest of a CASE expression
+func (p *parser) caseExpr() (expr, error) {
+	c := &caseExpr{}
+	if !p.peek().is("when") {
+		operand, err := p.expr(precOr)
+		if err != nil {
+			return nil, err
+		}
+		c.operand = operand
+	}
+	for p.accept("when") {
+		cond, err := p.expr(precOr)
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect("then"); err != nil {
+			return nil, err
+		}
+		result, err := p.expr(precOr)
+		if err != nil {
+			return nil, err
+		}
+		c.whens = append(c.whens, caseWhen{cond, result})
+	}
+	if len(c.whens) == 0 {
+		return nil, p.unexpected()
+	}
+	if p.accept("else") {
+		els, err := p.expr(precOr)
+		if err != nil {
+			return nil, err
+		}
+		c.els = els
+	}
+	return c, p.expect("end")
+}
+
+// typeName parses the name of a type, like integer, timestamp with time zone,
+// varchar(255) or text[]
+func (p *parser) typeName() (string, error) {
+	name, err := p.ident()
+	if err != nil {
+		return "", err
+	}
+	words := []string{strings.ToLower(name)}
+	for {
+		t := p.peek()
+		if t.kind != tokIdent {
+			break
+		}
+		switch t.text {
+		case "with", "without", "time", "zone", "precision", "varying":
+			words = append(words, p.next().text)
+			continue
+		}
+		break
+	}
+	if p.accept("(") {
+		for !p.accept(")") {
+			if p.next().kind == tokEOF {
+				return "", p.unexpected()
+			}
+		}
+	}
+	typ := strings.Join(words, " ")
+	for p.accept("[", "]") {
+		typ += "[]"
+	}
+	return typ, nil
+}
+
+// unsupported returns an error for SQL which is valid in Postgres but not
+// supported by MockDB
+func unsupported(feature string) error {
+	return PGError(CodeFeatureNotSupported,
+		fmt.Sprintf("testutils: %s are not supported by MockDB", feature))
+}
+
+// selectItem is an expression in a select list or Column, with its alias
+type selectItem struct {
+	e     expr
+	alias string
+}
+
+// name returns the name of the item's column in the results, which Postgres
+// takes from the alias, column or function
+func (item selectItem) name() string {
+	switch e := item.e.(type) {
+	case *columnExpr:
+		if item.alias == "" {
+			return e.name
+		}
+	case *funcExpr:
+		if item.alias == "" {
+			return e.name
+		}
+	}
+	if item.alias == "" {
+		return "?column?"
+	}
+	return item.alias
+}
+
+// selectItem parses an expression in a select list and its alias
+func (p *parser) selectItem() (selectItem, error) {
+	e, err := p.expr(precOr)
+	if err != nil {
+		return selectItem{}, err
+	}
+	item := selectItem{e: e}
+	if p.accept("as") || p.peek().kind == tokQuotedIdent ||
+		p.peek().kind == tokIdent && !isReserved(p.peek().text) {
+		if item.alias, err = p.ident(); err != nil {
+			return selectItem{}, err
+		}
+	}
+	return item, nil
+}
+
+// orderItem is an expression in an ORDER BY clause or Order
+type orderItem struct {
+	e          expr
+	desc       bool
+	nullsFirst bool
+}
+
+// orderItem parses an expression in an ORDER BY clause and its direction
+func (p *parser) orderItem() (orderItem, error) {
+	e, err := p.expr(precOr)
+	if err != nil {
+		return orderItem{}, err
+	}
+	item := orderItem{e: e}
+	if p.accept("desc") {
+		item.desc = true
+	} else {
+		p.accept("asc")
+	}
+	// Nulls sort after other values, so come first in descending order
+	item.nullsFirst = item.desc
+	if p.accept("nulls", "first") {
+		item.nullsFirst = true
+	} else if p.accept("nulls", "last") {
+		item.nullsFirst = false
+	}
+	return item, nil
+}
+
+// parseList parses the SQL of a comma separated list of items with the parse
+// function, like the columns passed to Column
+func parseList(sql string, params []interface{}, tbl *table, model interface{}, parse func(p *parser) error) error {
+	tokens, err := lexSQL(sql, params, tbl, model)
+	if err != nil {
+		return err
+	}
+	p := &parser{tokens: tokens}
+	for {
+		if err := parse(p); err != nil {
+			return err
+		}
+		if !p.accept(",") {
+			break
+		}
+	}
+	return p.end()
+}
+
+// isReserved returns whether an unquoted identifier is a keyword which cannot
+// be used as a column alias without AS
+func isReserved(word string) bool {
+	switch word {
+	case "from", "where", "group", "having", "order", "limit", "offset", "on", "join",
+		"inner", "left", "right", "full", "cross", "natural", "using", "union", "returning",
+		"intersect", "except", "and", "or", "not", "as", "set", "values", "for", "window",
+		"into", "default", "fetch", "tablesample", "collate", "filter", "over":
+		return true
+	}
+	return false
+}
+
+// isUnsupportedKeyword returns whether an unquoted identifier is a keyword
+// starting Postgres syntax which MockDB does not parse
+func isUnsupportedKeyword(word string) bool {
+	switch word {
+	case "fetch", "window", "tablesample", "collate", "escape", "similar", "lateral",
+		"filter", "over", "within", "at", "overlaps", "isnull", "notnull", "for", "with":
+		return true
+	}
+	return false
+}
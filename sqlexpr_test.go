@@ -0,0 +1,154 @@
+package testutils
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/go-pg/pg/v9"
+)
+
+func TestSQLExpr(t *testing.T) {
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	row := &Member{ID: 1, Email: "ann@example.com", Name: "Ann", TeamID: 2}
+	tbl := getTable(reflect.TypeOf(row))
+	eval := func(sql string, params ...interface{}) (interface{}, error) {
+		e, err := parseExpr(sql, params, tbl, row)
+		if err != nil {
+			return nil, err
+		}
+		ctx := &evalContext{
+			sources: []*rowSource{{tbl: tbl, name: tbl.name, alias: tbl.alias, row: reflect.ValueOf(row).Elem()}},
+			now:     now,
+		}
+		return e.eval(ctx)
+	}
+
+	t.Run("Values", func(t *testing.T) {
+		tests := []struct {
+			sql    string
+			params []interface{}
+			want   interface{}
+		}{
+			{"1 + 2 * 3", nil, int64(7)},
+			{"(1 + 2) * 3", nil, int64(9)},
+			{"7 / 2", nil, int64(3)},
+			{"7 / 2.0", nil, 3.5},
+			{"-id", nil, int64(-1)},
+			{"name || '!'", nil, "Ann!"},
+			{"lower(name)", nil, "ann"},
+			{"coalesce(nickname, name)", nil, "Ann"},
+			{"'42'::int + 1", nil, int64(43)},
+			{"CASE WHEN id = 1 THEN 'one' ELSE 'other' END", nil, "one"},
+			{"now() - interval '1 day'", nil, now.Add(-24 * time.Hour)},
+			{"?", []interface{}{int32(5)}, int64(5)},
+			{"?1 - ?0", []interface{}{1, 10}, int64(9)},
+			{"$2", []interface{}{1, "b"}, "b"},
+			{"?email", nil, "ann@example.com"},
+			{"?name", []interface{}{map[string]interface{}{"name": "Bob"}}, "Bob"},
+		}
+		for _, test := range tests {
+			got, err := eval(test.sql, test.params...)
+			if err != nil {
+				t.Fatalf("%s: %v", test.sql, err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Fatalf("%s: expected %#v; found %#v", test.sql, test.want, got)
+			}
+		}
+	})
+
+	t.Run("Conditions", func(t *testing.T) {
+		tests := []struct {
+			sql    string
+			params []interface{}
+			want   interface{}
+		}{
+			{"id = 1 AND name = 'Ann'", nil, true},
+			{"id = 2 OR name = 'Ann'", nil, true},
+			{"NOT id = 1", nil, false},
+			{"member.id = ?", []interface{}{1}, true},
+			{`"members"."id" = ?`, []interface{}{int64(1)}, true},
+			{"?TableAlias.id = 1", nil, true},
+			{"id IN (?)", []interface{}{pg.In([]int{3, 1})}, true},
+			{"id NOT IN (2, 3)", nil, true},
+			{"id = ANY(?)", []interface{}{pg.Array([]int{1, 2})}, true},
+			{"id BETWEEN 0 AND 1", nil, true},
+			{"email LIKE '%@example.com'", nil, true},
+			{"name ILIKE 'a%'", nil, true},
+			{"name NOT LIKE 'A_'", nil, true},
+			{"nickname IS NULL", nil, true},
+			{"team_id IS NOT NULL", nil, true},
+			// Zero values are null unless the column is tagged use_zero
+			{"number IS NULL", nil, true},
+			{"admin = false", nil, true},
+			{"number = 0", nil, nil},
+			{"number = 0 OR id = 1", nil, true},
+			{"number = 0 AND id = 1", nil, nil},
+			{"number IS DISTINCT FROM 0", nil, true},
+			{"id IN (2, NULL)", nil, nil},
+			{"team_id > '1'", nil, true},
+		}
+		for _, test := range tests {
+			got, err := eval(test.sql, test.params...)
+			if err != nil {
+				t.Fatalf("%s: %v", test.sql, err)
+			}
+			if got != test.want {
+				t.Fatalf("%s: expected %v; found %v", test.sql, test.want, got)
+			}
+		}
+	})
+
+	t.Run("Times", func(t *testing.T) {
+		o := &Order{ID: 1, CreatedAt: now}
+		otbl := getTable(reflect.TypeOf(o))
+		for _, sql := range []string{
+			"created_at = ?",
+			"created_at = '2020-01-01 12:00:00+00:00'",
+			"created_at > '2019-12-31'",
+			"created_at::date = '2020-01-01'",
+			"created_at <= now()",
+		} {
+			e, err := parseExpr(sql, []interface{}{now}, otbl, o)
+			if err != nil {
+				t.Fatalf("%s: %v", sql, err)
+			}
+			ctx := &evalContext{
+				sources: []*rowSource{{tbl: otbl, name: otbl.name, alias: otbl.alias, row: reflect.ValueOf(o).Elem()}},
+				now:     now,
+			}
+			ok, err := evalCondition(ctx, e)
+			if err != nil {
+				t.Fatalf("%s: %v", sql, err)
+			}
+			if !ok {
+				t.Fatalf("%s: expected true", sql)
+			}
+		}
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		tests := []struct {
+			sql  string
+			code string
+		}{
+			{"missing = 1", CodeUndefinedColumn},
+			{"other.id = 1", CodeUndefinedColumn},
+			{"id = 'one'", CodeInvalidTextRepresentation},
+			{"id / 0", CodeDivisionByZero},
+			{"id = ", CodeSyntaxError},
+			{"id = 1 1", CodeSyntaxError},
+			{"name = 'Ann", CodeSyntaxError},
+			{"id = ?", CodeSyntaxError},
+			{"nosuch(id)", CodeUndefinedFunction},
+			{"id IN (SELECT 1)", CodeFeatureNotSupported},
+		}
+		for _, test := range tests {
+			_, err := eval(test.sql)
+			if code := errCode(err); code != test.code {
+				t.Fatalf("%s: expected code %s; found %s (%v)", test.sql, test.code, code, err)
+			}
+		}
+	})
+}
@@ -0,0 +1,632 @@
+package testutils
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// rowGroup is a group of rows of a grouped query, which aggregate functions are
+// evaluated over
+type rowGroup struct {
+	rows []*evalContext
+	// values are the values of the GROUP BY expressions for the group
+	values []interface{}
+}
+
+// groupKeyExpr is a GROUP BY expression in the select list, HAVING or ORDER BY
+// of a grouped query, which evaluates to its value for the group
+type groupKeyExpr struct {
+	index int
+}
+
+func (e *groupKeyExpr) eval(ctx *evalContext) (interface{}, error) {
+	if ctx.group == nil {
+		return nil, groupingError("GROUP BY expressions can only be used in a grouped query")
+	}
+	return ctx.group.values[e.index], nil
+}
+
+// isAggregate returns whether the function is an aggregate function
+func isAggregate(name string) bool {
+	switch name {
+	case "count", "sum", "avg", "min", "max", "bool_and", "bool_or", "every",
+		"array_agg", "string_agg":
+		return true
+	}
+	return false
+}
+
+// aggregate evaluates an aggregate function over the rows of the group being
+// evaluated. Nulls are skipped by every function except count(*) and
+// array_agg.
+func (e *funcExpr) aggregate(ctx *evalContext) (interface{}, error) {
+	if ctx.group == nil {
+		return nil, groupingError("aggregate functions are not allowed here")
+	}
+	nargs := 1
+	if e.name == "string_agg" {
+		nargs = 2
+	}
+	if e.star && e.name != "count" || !e.star && len(e.args) != nargs {
+		args := make([]interface{}, len(e.args))
+		return nil, undefinedFunction(e.name, args)
+	}
+	var values []interface{}
+	var delimiter interface{}
+	for _, row := range ctx.group.rows {
+		if e.star {
+			values = append(values, true)
+			continue
+		}
+		v, err := e.args[0].eval(row)
+		if err != nil {
+			return nil, err
+		}
+		if v == nil && e.name != "array_agg" {
+			continue
+		}
+		if e.distinct {
+			dup, err := anyEqual(v, values)
+			if err != nil {
+				return nil, err
+			}
+			if v == nil && containsNil(values) || dup != nil && *dup {
+				continue
+			}
+		}
+		if nargs == 2 && delimiter == nil {
+			if delimiter, err = e.args[1].eval(row); err != nil {
+				return nil, err
+			}
+		}
+		values = append(values, v)
+	}
+
+	switch e.name {
+	case "count":
+		return int64(len(values)), nil
+	case "array_agg":
+		if len(values) == 0 {
+			return nil, nil
+		}
+		return values, nil
+	}
+	if len(values) == 0 {
+		return nil, nil
+	}
+	switch e.name {
+	case "sum", "avg":
+		sum := values[0]
+		for _, v := range values[1:] {
+			var err error
+			if sum, err = arithmetic("+", sum, v); err != nil {
+				return nil, err
+			}
+		}
+		if e.name == "sum" {
+			return sum, nil
+		}
+		switch s := sum.(type) {
+		case int64:
+			return float64(s) / float64(len(values)), nil
+		case float64:
+			return s / float64(len(values)), nil
+		case time.Duration:
+			return s / time.Duration(len(values)), nil
+		}
+		return nil, undefinedFunction(e.name, values[:1])
+	case "min", "max":
+		m := values[0]
+		for _, v := range values[1:] {
+			c, err := compareValues(v, m)
+			if err != nil {
+				return nil, err
+			}
+			if c < 0 && e.name == "min" || c > 0 && e.name == "max" {
+				m = v
+			}
+		}
+		return m, nil
+	case "bool_and", "every", "bool_or":
+		// bool_or is true if any value is, and the others false if any is
+		want := e.name == "bool_or"
+		for _, v := range values {
+			b, err := boolValue(v)
+			if err != nil {
+				return nil, err
+			}
+			if b == want {
+				return want, nil
+			}
+		}
+		return !want, nil
+	case "string_agg":
+		sep := ""
+		if delimiter != nil {
+			sep = textValue(delimiter)
+		}
+		s := make([]string, len(values))
+		for i, v := range values {
+			s[i] = textValue(v)
+		}
+		return strings.Join(s, sep), nil
+	}
+	return nil, undefinedFunction(e.name, values[:1])
+}
+
+// containsNil returns whether values includes a null
+func containsNil(values []interface{}) bool {
+	for _, v := range values {
+		if v == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// groupingError returns the error Postgres gives for a misuse of grouping
+func groupingError(message string) error {
+	return PGError(CodeGroupingError, message)
+}
+
+// mapExpr returns e with fn applied to each of its direct subexpressions,
+// copying it if any changed
+func mapExpr(e expr, fn func(expr) (expr, error)) (expr, error) {
+	var err error
+	apply := func(x expr) expr {
+		if x == nil || err != nil {
+			return x
+		}
+		var y expr
+		if y, err = fn(x); err != nil {
+			return x
+		}
+		return y
+	}
+	applyAll := func(xs []expr) []expr {
+		out := make([]expr, len(xs))
+		for i, x := range xs {
+			out[i] = apply(x)
+		}
+		return out
+	}
+	switch x := e.(type) {
+	case *unaryExpr:
+		c := *x
+		c.x = apply(x.x)
+		e = &c
+	case *binaryExpr:
+		c := *x
+		c.l, c.r = apply(x.l), apply(x.r)
+		e = &c
+	case *isExpr:
+		c := *x
+		c.x, c.from = apply(x.x), apply(x.from)
+		e = &c
+	case *inExpr:
+		c := *x
+		c.x, c.list = apply(x.x), applyAll(x.list)
+		e = &c
+	case *betweenExpr:
+		c := *x
+		c.x, c.lo, c.hi = apply(x.x), apply(x.lo), apply(x.hi)
+		e = &c
+	case *likeExpr:
+		c := *x
+		c.x, c.pattern = apply(x.x), apply(x.pattern)
+		e = &c
+	case *funcExpr:
+		c := *x
+		c.args = applyAll(x.args)
+		e = &c
+	case *caseExpr:
+		c := *x
+		c.operand, c.els = apply(x.operand), apply(x.els)
+		c.whens = make([]caseWhen, len(x.whens))
+		for i, w := range x.whens {
+			c.whens[i] = caseWhen{apply(w.cond), apply(w.result)}
+		}
+		e = &c
+	case *castExpr:
+		c := *x
+		c.x = apply(x.x)
+		e = &c
+	case *quantifiedExpr:
+		c := *x
+		c.x, c.arr = apply(x.x), apply(x.arr)
+		e = &c
+	case *arrayExpr:
+		c := *x
+		c.elems = applyAll(x.elems)
+		e = &c
+	}
+	return e, err
+}
+
+// hasAggregate returns whether an expression calls an aggregate function
+func hasAggregate(e expr) bool {
+	found := false
+	var visit func(expr) (expr, error)
+	visit = func(e expr) (expr, error) {
+		if f, ok := e.(*funcExpr); ok && isAggregate(f.name) {
+			found = true
+			return e, nil
+		}
+		return mapExpr(e, visit)
+	}
+	_, _ = visit(e)
+	return found
+}
+
+// selectQuery is the part of a SELECT after its FROM and WHERE clauses
+type selectQuery struct {
+	items  []selectItem
+	groups []expr
+	having expr
+	orders []orderItem
+	// limit and offset are -1 for none
+	limit  int
+	offset int
+}
+
+// run returns the results of the query for rows matching its WHERE clause,
+// grouping them if it has a GROUP BY clause or aggregates. The base context
+// has the query's tables with no current rows, for the single group of
+// an aggregate query matching no rows.
+func (s *selectQuery) run(base *evalContext, rows []*evalContext) ([]resultRow, error) {
+	ctxs, items, orders := rows, s.items, s.orders
+	if s.grouped() {
+		var err error
+		if ctxs, items, orders, err = s.group(base, rows); err != nil {
+			return nil, err
+		}
+	}
+	results := make([]resultRow, len(ctxs))
+	keys := make([][]interface{}, len(ctxs))
+	for i, ctx := range ctxs {
+		r, err := project(ctx, items)
+		if err != nil {
+			return nil, err
+		}
+		results[i] = r
+		if len(orders) == 0 {
+			continue
+		}
+		if keys[i], err = orderKeys(ctx, r, orders); err != nil {
+			return nil, err
+		}
+	}
+	if len(orders) > 0 {
+		index, err := sortIndex(keys, orders)
+		if err != nil {
+			return nil, err
+		}
+		sorted := make([]resultRow, len(results))
+		for i, j := range index {
+			sorted[i] = results[j]
+		}
+		results = sorted
+	}
+	if offset := s.offset; offset > 0 {
+		if offset > len(results) {
+			offset = len(results)
+		}
+		results = results[offset:]
+	}
+	if s.limit >= 0 && s.limit < len(results) {
+		results = results[:s.limit]
+	}
+	return results, nil
+}
+
+// grouped returns whether the query groups its rows
+func (s *selectQuery) grouped() bool {
+	if len(s.groups) > 0 || s.having != nil {
+		return true
+	}
+	for _, item := range s.items {
+		if hasAggregate(item.e) {
+			return true
+		}
+	}
+	for _, o := range s.orders {
+		if hasAggregate(o.e) {
+			return true
+		}
+	}
+	return false
+}
+
+// orderKeys returns the values of the order items for a row and its results.
+// Like Postgres, an item which is a number refers to the column in that
+// position, and a plain name to an output column before a column of a table.
+func orderKeys(ctx *evalContext, r resultRow, orders []orderItem) ([]interface{}, error) {
+	keys := make([]interface{}, len(orders))
+	out := r.expand()
+	for j, o := range orders {
+		switch e := o.e.(type) {
+		case *literalExpr:
+			if n, ok := e.value.(int64); ok {
+				if n < 1 || int(n) > len(out.values) {
+					return nil, PGError(CodeInvalidColumnReference,
+						fmt.Sprintf("ORDER BY position %d is not in select list", n))
+				}
+				keys[j] = out.values[n-1]
+				continue
+			}
+		case *columnExpr:
+			if e.table != "" {
+				break
+			}
+			found := false
+			for i, name := range out.names {
+				if name == e.name {
+					keys[j], found = out.values[i], true
+					break
+				}
+			}
+			if found {
+				continue
+			}
+		}
+		v, err := o.e.eval(ctx)
+		if err != nil {
+			return nil, err
+		}
+		keys[j] = v
+	}
+	return keys, nil
+}
+
+// group splits rows into groups with the same values of the GROUP BY
+// expressions, which form one group of every row if there are none, and filters
+// them with HAVING. It returns a context for each group and the select list
+// and orders rewritten to be evaluated against them.
+func (s *selectQuery) group(base *evalContext, rows []*evalContext) ([]*evalContext, []selectItem, []orderItem, error) {
+	keys := make([]expr, len(s.groups))
+	for i, g := range s.groups {
+		keys[i] = s.groupExpr(base, g)
+		if hasAggregate(keys[i]) {
+			return nil, nil, nil, groupingError("aggregate functions are not allowed in GROUP BY")
+		}
+	}
+
+	var groups []*rowGroup
+	for _, row := range rows {
+		values := make([]interface{}, len(keys))
+		for i, k := range keys {
+			v, err := k.eval(row)
+			if err != nil {
+				return nil, nil, nil, err
+			}
+			values[i] = v
+		}
+		var g *rowGroup
+		for _, other := range groups {
+			same, err := sameGroup(values, other.values)
+			if err != nil {
+				return nil, nil, nil, err
+			}
+			if same {
+				g = other
+				break
+			}
+		}
+		if g == nil {
+			g = &rowGroup{values: values}
+			groups = append(groups, g)
+		}
+		g.rows = append(g.rows, row)
+	}
+	if len(keys) == 0 && len(groups) == 0 {
+		groups = append(groups, &rowGroup{})
+	}
+
+	gr := &grouping{base: base, keys: keys}
+	items := s.items
+	if len(items) == 0 {
+		items = []selectItem{{e: &starExpr{table: base.sources[0].alias}}}
+	}
+	rewritten := make([]selectItem, len(items))
+	for i, item := range items {
+		e, err := gr.rewrite(item.e)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		rewritten[i] = selectItem{e, item.alias}
+		// Keep the name of a grouped column, which is no longer a column
+		if _, star := item.e.(*starExpr); !star {
+			rewritten[i].alias = item.name()
+		}
+	}
+	orders := make([]orderItem, len(s.orders))
+	for i, o := range s.orders {
+		orders[i] = o
+		// Output columns are looked up by orderKeys as they are
+		if _, ok := o.e.(*literalExpr); ok {
+			continue
+		}
+		if c, ok := o.e.(*columnExpr); ok && c.table == "" && hasOutput(items, c.name) {
+			continue
+		}
+		e, err := gr.rewrite(o.e)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		orders[i].e = e
+	}
+	var having expr
+	if s.having != nil {
+		var err error
+		if having, err = gr.rewrite(s.having); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+
+	var ctxs []*evalContext
+	for _, g := range groups {
+		ctx := &evalContext{sources: base.sources, now: base.now, group: g}
+		if len(g.rows) > 0 {
+			ctx.sources = g.rows[0].sources
+		}
+		if having != nil {
+			ok, err := evalCondition(ctx, having)
+			if err != nil {
+				return nil, nil, nil, err
+			}
+			if !ok {
+				continue
+			}
+		}
+		ctxs = append(ctxs, ctx)
+	}
+	return ctxs, rewritten, orders, nil
+}
+
+// groupExpr resolves a GROUP BY expression which is a number or the name of an
+// output column, but not of a column of a table, to the expression of that
+// column of the select list
+func (s *selectQuery) groupExpr(base *evalContext, e expr) expr {
+	switch x := e.(type) {
+	case *literalExpr:
+		if n, ok := x.value.(int64); ok && n >= 1 && int(n) <= len(s.items) {
+			return s.items[n-1].e
+		}
+	case *columnExpr:
+		if x.table != "" {
+			break
+		}
+		if _, _, err := base.lookup("", x.name); err == nil {
+			break
+		}
+		for _, item := range s.items {
+			if item.alias == x.name {
+				return item.e
+			}
+		}
+	}
+	return e
+}
+
+// hasOutput returns whether a select list has a column with the name, other
+// than through *
+func hasOutput(items []selectItem, name string) bool {
+	for _, item := range items {
+		if _, star := item.e.(*starExpr); !star && item.name() == name {
+			return true
+		}
+	}
+	return false
+}
+
+// sameGroup returns whether two lists of GROUP BY values are the same, where
+// nulls are the same as each other
+func sameGroup(a, b []interface{}) (bool, error) {
+	for i := range a {
+		if a[i] == nil || b[i] == nil {
+			if a[i] != b[i] {
+				return false, nil
+			}
+			continue
+		}
+		eq, err := equalValues(a[i], b[i])
+		if err != nil || !eq {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// grouping rewrites the expressions of a grouped query to be evaluated once
+// per group
+type grouping struct {
+	base *evalContext
+	keys []expr
+}
+
+// rewrite replaces the GROUP BY expressions in e with their values for the
+// group. Other columns outside of aggregates are an error, unless the primary
+// key of their table is grouped, when they are taken from the group's first
+// row.
+func (gr *grouping) rewrite(e expr) (expr, error) {
+	for i, k := range gr.keys {
+		if gr.same(e, k) {
+			return &groupKeyExpr{i}, nil
+		}
+	}
+	switch x := e.(type) {
+	case *funcExpr:
+		if isAggregate(x.name) {
+			return e, nil
+		}
+	case *columnExpr:
+		s, c, err := gr.base.lookup(x.table, x.name)
+		if err != nil {
+			return nil, err
+		}
+		if !gr.dependent(s) {
+			name := c.name
+			if x.table != "" {
+				name = x.table + "." + name
+			}
+			return nil, groupingError(fmt.Sprintf(
+				"column %q must appear in the GROUP BY clause or be used in an aggregate function", name))
+		}
+		return e, nil
+	case *starExpr:
+		for _, s := range gr.base.sources {
+			if x.table != "" && x.table != s.alias && x.table != s.name {
+				continue
+			}
+			if !gr.dependent(s) {
+				return nil, groupingError(fmt.Sprintf(
+					"columns of %q must appear in the GROUP BY clause or be used in an aggregate function", s.alias))
+			}
+		}
+		return e, nil
+	}
+	return mapExpr(e, gr.rewrite)
+}
+
+// same returns whether two expressions are the same, where column references
+// are the same if they refer to the same column
+func (gr *grouping) same(a, b expr) bool {
+	ca, ok := a.(*columnExpr)
+	cb, ok2 := b.(*columnExpr)
+	if ok && ok2 {
+		sa, a, err := gr.base.lookup(ca.table, ca.name)
+		if err != nil {
+			return false
+		}
+		sb, b, err := gr.base.lookup(cb.table, cb.name)
+		return err == nil && sa == sb && a == b
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// dependent returns whether every column of a table is functionally dependent
+// on the GROUP BY expressions, because they include its primary key
+func (gr *grouping) dependent(s *rowSource) bool {
+	if len(s.tbl.pks) == 0 {
+		return false
+	}
+	for _, pk := range s.tbl.pks {
+		grouped := false
+		for _, k := range gr.keys {
+			c, ok := k.(*columnExpr)
+			if !ok {
+				continue
+			}
+			if ks, kc, err := gr.base.lookup(c.table, c.name); err == nil && ks == s && kc == pk {
+				grouped = true
+				break
+			}
+		}
+		if !grouped {
+			return false
+		}
+	}
+	return true
+}
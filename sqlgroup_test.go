@@ -0,0 +1,187 @@
+package testutils
+
+import (
+	"testing"
+)
+
+func TestGroup(t *testing.T) {
+	members := func() *MockDB {
+		db := NewMockDB()
+		db.SetEvaluateSQL(true)
+		db.QueueModels(
+			&Member{ID: 1, Email: "ann@example.com", Name: "Ann", TeamID: 1, Number: 1},
+			&Member{ID: 2, Email: "bob@example.com", Name: "Bob", TeamID: 1, Number: 2},
+			&Member{ID: 3, Email: "cat@example.org", Name: "Cat", TeamID: 2, Number: 4, Admin: true},
+		)
+		return db
+	}
+	type total struct {
+		TeamID int
+		Total  int
+		Names  string
+	}
+
+	t.Run("Aggregates", func(t *testing.T) {
+		db := members()
+		row := &struct {
+			Count int
+			Sum   int
+			Min   int
+			Max   int
+			Avg   float64
+		}{}
+		_, err := db.QueryOne(row, `SELECT count(*), sum(number), min(number), max(number), avg(number) FROM members`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if row.Count != 3 || row.Sum != 7 || row.Min != 1 || row.Max != 4 || row.Avg < 2.33 || row.Avg > 2.34 {
+			t.Fatalf("expected the aggregates of every member; found %+v", row)
+		}
+
+		var names string
+		if _, err := db.QueryOne(&names, `SELECT string_agg(name, ', ') FROM members WHERE NOT admin`); err != nil {
+			t.Fatal(err)
+		}
+		if names != "Ann, Bob" {
+			t.Fatalf("expected Ann, Bob; found %q", names)
+		}
+
+		// An aggregate over no rows is one row, with a count of 0 and other
+		// aggregates null
+		var n int
+		var maxID *int
+		if _, err := db.QueryOne(&n, `SELECT count(*) FROM members WHERE id > 10`); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.QueryOne(&maxID, `SELECT max(id) FROM members WHERE id > 10`); err != nil {
+			t.Fatal(err)
+		}
+		if n != 0 || maxID != nil {
+			t.Fatalf("expected a count of 0 and a null max; found %d and %v", n, maxID)
+		}
+	})
+
+	t.Run("Group by", func(t *testing.T) {
+		db := members()
+		var totals []total
+		_, err := db.Query(&totals, `
+			SELECT team_id, count(*) AS total, string_agg(name, ',') AS names
+			FROM members
+			GROUP BY team_id
+			HAVING count(*) > ?
+			ORDER BY total DESC`, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(totals) != 2 || totals[0] != (total{1, 2, "Ann,Bob"}) || totals[1] != (total{2, 1, "Cat"}) {
+			t.Fatalf("expected the totals of teams 1 and 2; found %+v", totals)
+		}
+
+		var teams []int
+		if _, err := db.Query(&teams, `SELECT team_id FROM members GROUP BY 1 HAVING sum(number) > 3 ORDER BY 1`); err != nil {
+			t.Fatal(err)
+		}
+		if len(teams) != 1 || teams[0] != 2 {
+			t.Fatalf("expected team 2; found %v", teams)
+		}
+
+		// Columns of a table whose primary key is grouped may be selected
+		var ms []Member
+		if _, err := db.Query(&ms, `SELECT id, name FROM members GROUP BY id ORDER BY id`); err != nil {
+			t.Fatal(err)
+		}
+		if len(ms) != 3 || ms[2].Name != "Cat" {
+			t.Fatalf("expected every member; found %+v", ms)
+		}
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		db := members()
+		var totals []total
+		_, err := db.Query(&totals, `SELECT team_id, name FROM members GROUP BY team_id`)
+		if errCode(err) != CodeGroupingError {
+			t.Fatalf("expected a grouping error; found %v", err)
+		}
+		_, err = db.Query(&totals, `SELECT name, count(*) FROM members`)
+		if errCode(err) != CodeGroupingError {
+			t.Fatalf("expected a grouping error; found %v", err)
+		}
+		_, err = db.Query(&totals, `SELECT name FROM members WHERE count(*) > 1`)
+		if errCode(err) != CodeGroupingError {
+			t.Fatalf("expected a grouping error for an aggregate in WHERE; found %v", err)
+		}
+		_, err = db.Query(&totals, `SELECT team_id FROM members ORDER BY 2`)
+		if errCode(err) != CodeInvalidColumnReference {
+			t.Fatalf("expected an invalid column reference; found %v", err)
+		}
+	})
+
+	t.Run("Model", func(t *testing.T) {
+		db := members()
+		var totals []total
+		err := db.Model(&Member{}).
+			Column("team_id").
+			ColumnExpr("count(*) AS total").
+			ColumnExpr("string_agg(name, ?) AS names", "/").
+			Group("team_id").
+			Having("count(*) > ?", 1).
+			Select(&totals)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(totals) != 1 || totals[0] != (total{1, 2, "Ann/Bob"}) {
+			t.Fatalf("expected the total of team 1; found %+v", totals)
+		}
+
+		var n int
+		var admins bool
+		if err := db.Model(&Member{}).ColumnExpr("count(*), bool_or(admin)").Select(&n, &admins); err != nil {
+			t.Fatal(err)
+		}
+		if n != 3 || !admins {
+			t.Fatalf("expected 3 members including an admin; found %d and %v", n, admins)
+		}
+
+		t1 := &total{}
+		err = db.Model(&Member{}).
+			Column("team_id").
+			ColumnExpr("sum(number) AS total").
+			GroupExpr("team_id").
+			Order("total DESC").
+			Limit(1).
+			Select(t1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if t1.TeamID != 2 || t1.Total != 4 {
+			t.Fatalf("expected team 2 with a total of 4; found %+v", t1)
+		}
+
+		var teams []int
+		err = db.Model(&Member{}).Column("team_id").Group("team_id").Select(&teams)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(teams) != 2 {
+			t.Fatalf("expected 2 groups; found %v", teams)
+		}
+		teams = nil
+		err = db.Model(&Member{}).Column("team_id").Group("team_id").Having("count(*) > 1").Select(&teams)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(teams) != 1 {
+			t.Fatalf("expected 1 team with more than 1 member; found %v", teams)
+		}
+
+		err = db.Model(&Member{}).Column("name").Group("team_id").Select(&totals)
+		if errCode(err) != CodeGroupingError {
+			t.Fatalf("expected a grouping error; found %v", err)
+		}
+
+		calls := db.Calls().Method("Select").Matching(`GROUP BY team_id HAVING \(count\(\*\) > \?\)`)
+		if len(calls) != 1 {
+			t.Fatalf("expected the GROUP BY and HAVING to be recorded; found %+v", db.Calls())
+		}
+	})
+}
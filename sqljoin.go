@@ -0,0 +1,132 @@
+package testutils
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// statementJoin is a JOIN clause as it is parsed
+type statementJoin struct {
+	table string
+	alias string
+	left  bool
+	// cross is true for a CROSS JOIN or a table after a comma, which has no
+	// condition
+	cross bool
+	on    expr
+}
+
+// join parses a JOIN clause, returning nil if there is none. INNER, LEFT and
+// CROSS joins are supported, and a table after a comma is a CROSS JOIN. The
+// ON condition is left to the caller to require, as go-pg may add it with
+// JoinOn.
+func (p *parser) join() (*statementJoin, error) {
+	j := &statementJoin{}
+	switch {
+	case p.accept(","), p.accept("cross", "join"):
+		j.cross = true
+	case p.accept("join"), p.accept("inner", "join"):
+	case p.accept("left"):
+		p.accept("outer")
+		if err := p.expect("join"); err != nil {
+			return nil, err
+		}
+		j.left = true
+	case p.peek().is("right"), p.peek().is("full"), p.peek().is("natural"):
+		return nil, unsupported("RIGHT, FULL and NATURAL joins")
+	default:
+		return nil, nil
+	}
+	if p.peek().is("(") || p.peek().is("lateral") || p.peekAt(1).is("(") {
+		return nil, unsupported("joins of subqueries and table functions")
+	}
+	var err error
+	if j.table, j.alias, err = p.tableRef(true); err != nil {
+		return nil, err
+	}
+	if j.cross {
+		return j, nil
+	}
+	if p.peek().is("using") {
+		return nil, unsupported("JOIN ... USING clauses")
+	}
+	if p.accept("on") {
+		if j.on, err = p.expr(precOr); err != nil {
+			return nil, err
+		}
+	}
+	return j, nil
+}
+
+// tableJoin is a table joined to the rows of a query
+type tableJoin struct {
+	// source is the joined table, with no current row
+	source *rowSource
+	left   bool
+	on     expr
+	// withDeleted is whether soft deleted rows are joined
+	withDeleted bool
+}
+
+// newTableJoin returns the join of a JOIN clause to a table, returning an
+// error if its alias is taken by one of the tables it is joined to
+func newTableJoin(j *statementJoin, tbl *table, sources []*rowSource) (*tableJoin, error) {
+	if err := checkAlias(sources, j.alias); err != nil {
+		return nil, err
+	}
+	return &tableJoin{
+		source:      &rowSource{tbl: tbl, name: j.table, alias: j.alias},
+		left:        j.left,
+		on:          j.on,
+		withDeleted: true,
+	}, nil
+}
+
+// checkAlias returns an error if a table of sources is referred to by alias
+func checkAlias(sources []*rowSource, alias string) error {
+	for _, s := range sources {
+		if s.alias == alias {
+			return PGError(CodeDuplicateAlias, fmt.Sprintf("table name %q specified more than once", alias))
+		}
+	}
+	return nil
+}
+
+// rows joins each of rows to the models of the joined table matching the join
+// condition. For a LEFT JOIN, a row matching none is joined to nulls.
+func (j *tableJoin) rows(rows []*evalContext, models []interface{}) ([]*evalContext, error) {
+	var joined []*evalContext
+	for _, ctx := range rows {
+		matched := false
+		for _, m := range models {
+			v := reflect.Indirect(reflect.ValueOf(m))
+			if v.Type() != j.source.tbl.typ || !j.withDeleted && isSoftDeleted(m) {
+				continue
+			}
+			c := ctx.with(j.source, v, m)
+			if j.on != nil {
+				ok, err := evalCondition(c, j.on)
+				if err != nil {
+					return nil, err
+				}
+				if !ok {
+					continue
+				}
+			}
+			matched = true
+			joined = append(joined, c)
+		}
+		if j.left && !matched {
+			joined = append(joined, ctx.with(j.source, reflect.Value{}, nil))
+		}
+	}
+	return joined, nil
+}
+
+// with returns a copy of ctx with the row of another table
+func (ctx *evalContext) with(s *rowSource, row reflect.Value, model interface{}) *evalContext {
+	c := *ctx
+	c.sources = append(append([]*rowSource(nil), ctx.sources...),
+		&rowSource{tbl: s.tbl, name: s.name, alias: s.alias, row: row, model: model})
+	return &c
+}
@@ -0,0 +1,165 @@
+package testutils
+
+import (
+	"testing"
+)
+
+func TestJoin(t *testing.T) {
+	library := func() *MockDB {
+		db := NewMockDB()
+		db.SetEvaluateSQL(true)
+		db.QueueModels(
+			&Author{ID: 1, Name: "Ann"},
+			&Author{ID: 2, Name: "Bob"},
+			&Author{ID: 3, Name: "Cat"},
+			&Profile{ID: 1, AuthorID: 1, Bio: "hi"},
+			&Book{ID: 1, AuthorID: 1, Title: "B"},
+			&Book{ID: 2, AuthorID: 1, Title: "A"},
+			&Book{ID: 3, AuthorID: 2, Title: "C"},
+		)
+		return db
+	}
+
+	t.Run("Inner join", func(t *testing.T) {
+		db := library()
+		var titles []struct {
+			Title string
+			Name  string
+		}
+		_, err := db.Query(&titles, `
+			SELECT b.title, a.name
+			FROM books b
+			JOIN authors a ON a.id = b.author_id
+			WHERE a.name = ?
+			ORDER BY b.title`, "Ann")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(titles) != 2 || titles[0].Title != "A" || titles[1].Name != "Ann" {
+			t.Fatalf("expected Ann's books in order; found %+v", titles)
+		}
+
+		var authors []Author
+		if _, err := db.Query(&authors, `SELECT a.* FROM authors a INNER JOIN books b ON b.author_id = a.id WHERE b.title = 'C'`); err != nil {
+			t.Fatal(err)
+		}
+		if len(authors) != 1 || authors[0].Name != "Bob" {
+			t.Fatalf("expected Bob; found %+v", authors)
+		}
+
+		var n int
+		if _, err := db.QueryOne(&n, `SELECT count(*) FROM authors, books`); err != nil {
+			t.Fatal(err)
+		}
+		if n != 9 {
+			t.Fatalf("expected 9 rows in the cross join; found %d", n)
+		}
+	})
+
+	t.Run("Left join", func(t *testing.T) {
+		db := library()
+		var counts []struct {
+			Name  string
+			Books int
+		}
+		_, err := db.Query(&counts, `
+			SELECT a.name, count(b.id) AS books
+			FROM authors a
+			LEFT OUTER JOIN books b ON b.author_id = a.id
+			GROUP BY a.id
+			ORDER BY a.name`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(counts) != 3 || counts[0].Books != 2 || counts[1].Books != 1 || counts[2].Books != 0 {
+			t.Fatalf("expected 2, 1 and 0 books; found %+v", counts)
+		}
+
+		var bios []struct {
+			Name string
+			Bio  *string
+		}
+		if _, err := db.Query(&bios, `SELECT a.name, p.bio FROM authors a LEFT JOIN profiles p ON p.author_id = a.id ORDER BY a.id`); err != nil {
+			t.Fatal(err)
+		}
+		if len(bios) != 3 || bios[0].Bio == nil || *bios[0].Bio != "hi" || bios[1].Bio != nil {
+			t.Fatalf("expected only Ann to have a bio; found %+v", bios)
+		}
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		db := library()
+		var ids []int
+		_, err := db.Query(&ids, `SELECT id FROM authors a JOIN books b ON b.author_id = a.id`)
+		if errCode(err) != CodeAmbiguousColumn {
+			t.Fatalf("expected an ambiguous column error; found %v", err)
+		}
+		_, err = db.Query(&ids, `SELECT b.id FROM books b JOIN authors b ON b.id = 1`)
+		if errCode(err) != CodeDuplicateAlias {
+			t.Fatalf("expected a duplicate alias error; found %v", err)
+		}
+		_, err = db.Query(&ids, `SELECT a.id FROM authors a JOIN books b ON b.author_id = a.id GROUP BY a.id HAVING b.title = 'A'`)
+		if errCode(err) != CodeGroupingError {
+			t.Fatalf("expected a grouping error; found %v", err)
+		}
+	})
+
+	t.Run("Model", func(t *testing.T) {
+		db := library()
+		var books []Book
+		err := db.Model(&books).
+			Relation("Author").
+			Where("author.name = ?", "Ann").
+			Order("book.title").
+			Select()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(books) != 2 || books[0].Title != "A" || books[0].Author == nil || books[0].Author.Name != "Ann" {
+			t.Fatalf("expected Ann's books with their author; found %+v", books)
+		}
+
+		books = nil
+		if err := db.Model(&books).Relation("Author.Profile").Where("author__profile.bio IS NULL").Select(); err != nil {
+			t.Fatal(err)
+		}
+		if len(books) != 1 || books[0].ID != 3 {
+			t.Fatalf("expected the book of the author without a profile; found %+v", books)
+		}
+
+		var authors []Author
+		err = db.Model(&authors).
+			Join("LEFT JOIN books AS b").
+			JoinOn("b.author_id = author.id").
+			JoinOn("b.title <> ?", "C").
+			Where("b.id IS NULL").
+			Select()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(authors) != 2 || authors[0].Name != "Bob" || authors[1].Name != "Cat" {
+			t.Fatalf("expected Bob and Cat; found %+v", authors)
+		}
+		calls := db.Calls().Method("Select").Matching(`LEFT JOIN books AS b ON \(b.author_id = author.id\) AND \(b.title <> \?\)`)
+		if len(calls) != 1 {
+			t.Fatalf("expected the join to be recorded; found %+v", db.Calls())
+		}
+
+		var titles []string
+		err = db.Model(&Author{}).Column("b.title").Join("JOIN books AS b ON b.author_id = author.id").Select(&titles)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(titles) != 3 {
+			t.Fatalf("expected 3 joined rows; found %v", titles)
+		}
+
+		if err := db.Model(&authors).JoinOn("b.id = 1").Select(); err == nil {
+			t.Fatal("expected an error for JoinOn without a join")
+		}
+		err = db.Model(&authors).Join("JOIN missing AS m ON m.id = author.id").Select()
+		if errCode(err) != CodeUndefinedTable {
+			t.Fatalf("expected an undefined table error; found %v", err)
+		}
+	})
+}
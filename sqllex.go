@@ -0,0 +1,344 @@
+package testutils
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/go-pg/pg/v9/types"
+)
+
+// tokenKind is the kind of a token of SQL
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	// tokIdent is an unquoted identifier or keyword, lowercased like Postgres
+	tokIdent
+	// tokQuotedIdent is a double quoted identifier, which keeps its case
+	tokQuotedIdent
+	tokNumber
+	tokString
+	// tokValue is a param substituted for a placeholder, holding its value
+	// converted by sqlValue
+	tokValue
+	tokOp
+)
+
+// token is a token of SQL
+type token struct {
+	kind  tokenKind
+	text  string
+	value interface{}
+}
+
+// is returns whether the token is the keyword or operator s
+func (t token) is(s string) bool {
+	return (t.kind == tokIdent || t.kind == tokOp) && t.text == s
+}
+
+// String returns the token as it would appear in SQL, for error messages
+func (t token) String() string {
+	switch t.kind {
+	case tokEOF:
+		return "end of input"
+	case tokQuotedIdent:
+		return `"` + t.text + `"`
+	case tokString:
+		return "'" + t.text + "'"
+	case tokValue:
+		return fmt.Sprint(t.value)
+	}
+	return t.text
+}
+
+// lexer splits SQL into tokens, replacing placeholders with their params the
+// way go-pg formats queries
+type lexer struct {
+	src    string
+	pos    int
+	tokens []token
+
+	params []interface{}
+	// next is the index of the param of the next ? placeholder
+	next int
+	// named is the struct or map the values of ?name placeholders are read
+	// from, which is the last param or else the query's model
+	named reflect.Value
+	// tbl is the table of the query's model, for ?TableName and ?TableAlias
+	tbl *table
+}
+
+// lexSQL splits the SQL into tokens, substituting params for ?, ?0 and $1
+// placeholders and values from model, if it is a struct of the table tbl, for
+// ?name placeholders. Params implementing types.ValueAppender, like pg.In and
+// pg.Ident, are formatted and split into tokens themselves. The table and model
+// may be nil.
+func lexSQL(sql string, params []interface{}, tbl *table, model interface{}) ([]token, error) {
+	l := &lexer{src: sql, params: params, tbl: tbl}
+	if len(params) > 0 {
+		last := reflect.Indirect(reflect.ValueOf(params[len(params)-1]))
+		if last.Kind() == reflect.Struct && !isTimeType(last.Type()) || last.Kind() == reflect.Map {
+			l.named = last
+		}
+	}
+	if v := reflect.Indirect(reflect.ValueOf(model)); !l.named.IsValid() && v.Kind() == reflect.Struct {
+		l.named = v
+	}
+	if err := l.lex(); err != nil {
+		return nil, err
+	}
+	return append(l.tokens, token{kind: tokEOF}), nil
+}
+
+// lex appends the tokens of the lexer's source
+func (l *lexer) lex() error {
+	for l.pos < len(l.src) {
+		c := l.src[l.pos]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			l.pos++
+		case c == '-' && strings.HasPrefix(l.src[l.pos:], "--"):
+			for l.pos < len(l.src) && l.src[l.pos] != '\n' {
+				l.pos++
+			}
+		case c == '/' && strings.HasPrefix(l.src[l.pos:], "/*"):
+			return unsupported("block comments")
+		case isIdentStart(c):
+			start := l.pos
+			for l.pos < len(l.src) && isIdentChar(l.src[l.pos]) {
+				l.pos++
+			}
+			word := strings.ToLower(l.src[start:l.pos])
+			if word == "e" && l.pos < len(l.src) && l.src[l.pos] == '\'' {
+				// Backslash escapes would be misread as the end of the string
+				return unsupported("escape string constants")
+			}
+			l.emit(tokIdent, word)
+		case c >= '0' && c <= '9' || c == '.' && l.pos+1 < len(l.src) && isDigit(l.src[l.pos+1]):
+			l.lexNumber()
+		case c == '\'':
+			s, err := l.quoted('\'')
+			if err != nil {
+				return err
+			}
+			l.emit(tokString, s)
+		case c == '"':
+			s, err := l.quoted('"')
+			if err != nil {
+				return err
+			}
+			l.emit(tokQuotedIdent, s)
+		case c == '?':
+			if err := l.placeholder(); err != nil {
+				return err
+			}
+		case c == '$' && l.pos+1 < len(l.src) && isDigit(l.src[l.pos+1]):
+			l.pos++
+			n := l.digits()
+			if n < 1 || n > len(l.params) {
+				return syntaxError("no param for placeholder $%d", n)
+			}
+			if err := l.param(l.params[n-1]); err != nil {
+				return err
+			}
+		case c == '$' && l.pos+1 < len(l.src) && (l.src[l.pos+1] == '$' || isIdentStart(l.src[l.pos+1])):
+			return unsupported("dollar-quoted strings")
+		default:
+			l.lexOp()
+		}
+	}
+	return nil
+}
+
+// emit appends a token
+func (l *lexer) emit(kind tokenKind, text string) {
+	l.tokens = append(l.tokens, token{kind: kind, text: text})
+}
+
+// lexNumber lexes an integer or decimal number
+func (l *lexer) lexNumber() {
+	start := l.pos
+	for l.pos < len(l.src) && (isDigit(l.src[l.pos]) || l.src[l.pos] == '.') {
+		l.pos++
+	}
+	if l.pos < len(l.src) && (l.src[l.pos] == 'e' || l.src[l.pos] == 'E') {
+		l.pos++
+		if l.pos < len(l.src) && (l.src[l.pos] == '+' || l.src[l.pos] == '-') {
+			l.pos++
+		}
+		for l.pos < len(l.src) && isDigit(l.src[l.pos]) {
+			l.pos++
+		}
+	}
+	l.emit(tokNumber, l.src[start:l.pos])
+}
+
+// lexOp lexes an operator or punctuation
+func (l *lexer) lexOp() {
+	for _, op := range []string{"<>", "!=", "<=", ">=", "||", "::"} {
+		if strings.HasPrefix(l.src[l.pos:], op) {
+			l.pos += len(op)
+			l.emit(tokOp, op)
+			return
+		}
+	}
+	l.emit(tokOp, l.src[l.pos:l.pos+1])
+	l.pos++
+}
+
+// quoted returns the contents of a string or identifier starting at the
+// current position, with doubled quotes unescaped
+func (l *lexer) quoted(q byte) (string, error) {
+	var b strings.Builder
+	l.pos++
+	for l.pos < len(l.src) {
+		c := l.src[l.pos]
+		l.pos++
+		if c != q {
+			b.WriteByte(c)
+			continue
+		}
+		if l.pos < len(l.src) && l.src[l.pos] == q {
+			b.WriteByte(q)
+			l.pos++
+			continue
+		}
+		return b.String(), nil
+	}
+	return "", syntaxError("unterminated quoted string")
+}
+
+// digits reads a decimal number at the current position, or returns -1 if
+// there is none
+func (l *lexer) digits() int {
+	start := l.pos
+	for l.pos < len(l.src) && isDigit(l.src[l.pos]) {
+		l.pos++
+	}
+	if start == l.pos {
+		return -1
+	}
+	n, _ := strconv.Atoi(l.src[start:l.pos])
+	return n
+}
+
+// placeholder substitutes the param of a ?, ?0 or ?name placeholder
+func (l *lexer) placeholder() error {
+	l.pos++
+	if l.pos < len(l.src) && isDigit(l.src[l.pos]) {
+		n := l.digits()
+		if n >= len(l.params) {
+			return syntaxError("no param for placeholder ?%d", n)
+		}
+		return l.param(l.params[n])
+	}
+	if l.pos < len(l.src) && isIdentStart(l.src[l.pos]) {
+		start := l.pos
+		for l.pos < len(l.src) && isIdentChar(l.src[l.pos]) {
+			l.pos++
+		}
+		return l.namedParam(l.src[start:l.pos])
+	}
+	if l.next >= len(l.params) {
+		// go-pg leaves a ? with no param, which may be a jsonb operator
+		l.emit(tokOp, "?")
+		return nil
+	}
+	l.next++
+	return l.param(l.params[l.next-1])
+}
+
+// namedParam substitutes the value of a ?name placeholder
+func (l *lexer) namedParam(name string) error {
+	if l.tbl != nil {
+		switch name {
+		case "TableName":
+			l.emit(tokQuotedIdent, l.tbl.name)
+			return nil
+		case "TableAlias":
+			l.emit(tokQuotedIdent, l.tbl.alias)
+			return nil
+		}
+	}
+	v := l.named
+	switch v.Kind() {
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			break
+		}
+		if e := v.MapIndex(reflect.ValueOf(name).Convert(v.Type().Key())); e.IsValid() {
+			return l.param(e.Interface())
+		}
+	case reflect.Struct:
+		tbl := getTable(v.Type())
+		for _, c := range tbl.columns {
+			if c.name != name && c.field != name {
+				continue
+			}
+			f := c.value(v)
+			if !f.IsValid() || isNull(c, f) {
+				return l.param(nil)
+			}
+			return l.param(f.Interface())
+		}
+	}
+	return syntaxError("no param for placeholder ?%s", name)
+}
+
+// param substitutes a param for a placeholder
+func (l *lexer) param(p interface{}) error {
+	if a, ok := p.(types.ValueAppender); ok {
+		b, err := a.AppendValue(nil, 1)
+		if err != nil {
+			return err
+		}
+		sub := &lexer{src: string(b)}
+		if err := sub.lex(); err != nil {
+			return err
+		}
+		l.tokens = append(l.tokens, sub.tokens...)
+		return nil
+	}
+	l.tokens = append(l.tokens, token{kind: tokValue, text: "?", value: sqlValue(reflect.ValueOf(p))})
+	return nil
+}
+
+// syntaxError returns a pg.Error for SQL the lexer or parser cannot read
+func syntaxError(format string, args ...interface{}) error {
+	return PGError(CodeSyntaxError, fmt.Sprintf(format, args...))
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isIdentStart(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_'
+}
+
+func isIdentChar(c byte) bool {
+	return isIdentStart(c) || isDigit(c) || c == '$'
+}
+
+// checkParens returns a syntax error if the parentheses of the tokens are not
+// balanced, which is malformed SQL however much of it MockDB supports
+func checkParens(tokens []token) error {
+	depth := 0
+	for _, t := range tokens {
+		switch {
+		case t.kind != tokOp:
+		case t.text == "(":
+			depth++
+		case t.text == ")":
+			if depth--; depth < 0 {
+				return syntaxError(`syntax error at or near ")"`)
+			}
+		}
+	}
+	if depth > 0 {
+		return syntaxError("syntax error at end of input")
+	}
+	return nil
+}
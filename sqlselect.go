@@ -0,0 +1,254 @@
+package testutils
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/go-pg/pg/v9"
+)
+
+// resultRow is a row of the results of a query
+type resultRow struct {
+	// row is the stored struct of a row selected with *, whose columns are
+	// the results, or an invalid Value if the results are listed in values
+	row reflect.Value
+
+	names  []string
+	values []interface{}
+	// fields are the fields of the results which are plain columns, which are
+	// copied as they are into destinations of the same type, or invalid Values
+	fields []reflect.Value
+}
+
+// add appends a result to the row
+func (r *resultRow) add(name string, value interface{}, field reflect.Value) {
+	r.names = append(r.names, name)
+	r.values = append(r.values, value)
+	r.fields = append(r.fields, field)
+}
+
+// expand lists the columns of a row selected with * in its values
+func (r resultRow) expand() resultRow {
+	if !r.row.IsValid() {
+		return r
+	}
+	out := resultRow{}
+	for _, c := range getTable(r.row.Type()).columns {
+		f := c.value(r.row)
+		out.add(c.name, columnValue(c, f), f)
+	}
+	return out
+}
+
+// project evaluates a select list against a row. An empty list selects every
+// column of the first table, which is the model's table of a Model query.
+func project(ctx *evalContext, items []selectItem) (resultRow, error) {
+	if len(items) == 0 {
+		return resultRow{row: ctx.sources[0].row}, nil
+	}
+	var r resultRow
+	for _, item := range items {
+		switch e := item.e.(type) {
+		case *starExpr:
+			found := false
+			for _, s := range ctx.sources {
+				if e.table != "" && e.table != s.alias && e.table != s.name {
+					continue
+				}
+				found = true
+				for _, c := range s.tbl.columns {
+					var f reflect.Value
+					if s.row.IsValid() {
+						f = c.value(s.row)
+					}
+					r.add(c.name, columnValue(c, f), f)
+				}
+			}
+			if !found {
+				return r, PGError(CodeUndefinedTable,
+					fmt.Sprintf("missing FROM-clause entry for table %q", e.table))
+			}
+			continue
+		case *columnExpr:
+			c, f, err := ctx.field(e)
+			if err != nil {
+				return r, err
+			}
+			r.add(item.name(), columnValue(c, f), f)
+			continue
+		}
+		v, err := item.e.eval(ctx)
+		if err != nil {
+			return r, err
+		}
+		r.add(item.name(), v, reflect.Value{})
+	}
+	return r, nil
+}
+
+// sortRows sorts rows by the order items, like ORDER BY. Rows which compare
+// equal keep their order.
+func sortRows(rows []*evalContext, orders []orderItem) error {
+	if len(orders) == 0 {
+		return nil
+	}
+	keys := make([][]interface{}, len(rows))
+	for i, ctx := range rows {
+		keys[i] = make([]interface{}, len(orders))
+		for j, o := range orders {
+			v, err := o.e.eval(ctx)
+			if err != nil {
+				return err
+			}
+			keys[i][j] = v
+		}
+	}
+	index, err := sortIndex(keys, orders)
+	if err != nil {
+		return err
+	}
+	sorted := make([]*evalContext, len(rows))
+	for i, j := range index {
+		sorted[i] = rows[j]
+	}
+	copy(rows, sorted)
+	return nil
+}
+
+// sortIndex returns the indexes of rows in the order of their sort keys, which
+// are the values of the order items for each row
+func sortIndex(keys [][]interface{}, orders []orderItem) ([]int, error) {
+	index := make([]int, len(keys))
+	for i := range index {
+		index[i] = i
+	}
+	var sortErr error
+	sort.SliceStable(index, func(a, b int) bool {
+		for j, o := range orders {
+			x, y := keys[index[a]][j], keys[index[b]][j]
+			if x == nil || y == nil {
+				if (x == nil) == (y == nil) {
+					continue
+				}
+				return (x == nil) == o.nullsFirst
+			}
+			c, err := compareValues(x, y)
+			if err != nil {
+				if sortErr == nil {
+					sortErr = err
+				}
+				return false
+			}
+			if c != 0 {
+				return (c < 0) != o.desc
+			}
+		}
+		return false
+	})
+	return index, sortErr
+}
+
+// scanResults copies result rows into the destinations passed to a query. A
+// single pointer to a slice is filled with every row, with structs taking the
+// columns of the same names and other types the first column. Otherwise a
+// single struct takes the columns of a row, or each destination takes a column
+// in turn. If one is true, there must be exactly one row or pg.ErrNoRows or
+// pg.ErrMultiRows is returned, and otherwise the last row is scanned.
+func scanResults(dest []interface{}, rows []resultRow, one bool) error {
+	for _, d := range dest {
+		v := reflect.ValueOf(d)
+		if v.Kind() != reflect.Ptr || v.IsNil() {
+			return fmt.Errorf("testutils: model must be a non-nil pointer, not %T", d)
+		}
+	}
+	if len(dest) == 1 && isSlice(reflect.TypeOf(dest[0]).Elem()) {
+		s := reflect.ValueOf(dest[0]).Elem()
+		out := reflect.MakeSlice(s.Type(), len(rows), len(rows))
+		for i, r := range rows {
+			if err := scanRow(out.Index(i), r); err != nil {
+				return err
+			}
+		}
+		s.Set(out)
+		return nil
+	}
+	if one {
+		if len(rows) == 0 {
+			return pg.ErrNoRows
+		}
+		if len(rows) > 1 {
+			return pg.ErrMultiRows
+		}
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+	r := rows[len(rows)-1]
+	if len(dest) == 1 {
+		return scanRow(reflect.ValueOf(dest[0]).Elem(), r)
+	}
+	r = r.expand()
+	if len(dest) > len(r.values) {
+		return fmt.Errorf("testutils: %d destinations for %d columns", len(dest), len(r.values))
+	}
+	for i, d := range dest {
+		if err := scanColumn(reflect.ValueOf(d).Elem(), r, i); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// scanRow copies a result row into dst, which is a struct, a pointer to one,
+// or a scalar which takes the first column
+func scanRow(dst reflect.Value, r resultRow) error {
+	t := dst.Type()
+	if t.Kind() == reflect.Ptr && t.Elem().Kind() == reflect.Struct && !isTimeType(t.Elem()) {
+		if dst.IsNil() {
+			dst.Set(reflect.New(t.Elem()))
+		}
+		dst = dst.Elem()
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct || isTimeType(t) {
+		r = r.expand()
+		if len(r.values) == 0 {
+			return fmt.Errorf("testutils: no column to scan into %s", t)
+		}
+		return scanColumn(dst, r, 0)
+	}
+	if r.row.IsValid() && r.row.Type() == t {
+		dst.Set(copyValue(r.row, map[copyKey]reflect.Value{}))
+		return nil
+	}
+	r = r.expand()
+	tbl := getTable(t)
+	for i, name := range r.names {
+		c := tbl.column(name)
+		if c == nil {
+			if tbl.discardUnknownColumns {
+				continue
+			}
+			return fmt.Errorf("pg: can't find column=%s in model=%s (try discard_unknown_columns)",
+				name, t.Name())
+		}
+		f := c.value(dst)
+		if !f.IsValid() || !f.CanSet() {
+			continue
+		}
+		if err := scanColumn(f, r, i); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// scanColumn copies column i of a result row into dst
+func scanColumn(dst reflect.Value, r resultRow, i int) error {
+	if f := r.fields[i]; f.IsValid() && f.Type() == dst.Type() && f.CanInterface() {
+		dst.Set(copyValue(f, map[copyKey]reflect.Value{}))
+		return nil
+	}
+	return setSQLValue(dst, r.values[i])
+}
@@ -0,0 +1,795 @@
+package testutils
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/go-pg/pg/v9"
+)
+
+// statement is a SQL statement which MockDB can run against its models
+type statement struct {
+	// verb is "select", "insert", "update" or "delete"
+	verb string
+	// table is the table the statement reads or writes, or "" for a SELECT
+	// without FROM, and alias is the name it is referred to by
+	table string
+	alias string
+	joins []*statementJoin
+
+	// items are the select list, or the RETURNING list of a write
+	items     []selectItem
+	returning bool
+
+	where  expr
+	groups []expr
+	having expr
+	orders []orderItem
+	limit  expr
+	offset expr
+
+	// columns and values are the columns and rows of an INSERT, with a nil
+	// expression for DEFAULT
+	columns []string
+	values  [][]expr
+
+	sets []statementSet
+}
+
+// statementSet is an assignment in the SET clause of an UPDATE
+type statementSet struct {
+	column string
+	e      expr
+}
+
+// parseStatement parses the tokens of a SELECT, INSERT, UPDATE or DELETE
+// statement. Other statements and clauses MockDB cannot run return a pg.Error
+// with code CodeFeatureNotSupported.
+func parseStatement(tokens []token) (*statement, error) {
+	p := &parser{tokens: tokens}
+	s := &statement{}
+	var err error
+	switch {
+	case p.accept("select"):
+		s.verb = "select"
+		err = p.selectStatement(s)
+	case p.accept("insert", "into"):
+		s.verb = "insert"
+		err = p.insertStatement(s)
+	case p.accept("update"):
+		s.verb = "update"
+		err = p.updateStatement(s)
+	case p.accept("delete", "from"):
+		s.verb = "delete"
+		err = p.deleteStatement(s)
+	default:
+		if t := p.peek(); t.kind == tokIdent {
+			return nil, unsupported(strings.ToUpper(t.text) + " statements")
+		}
+		return nil, p.unexpected()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := p.end(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// selectStatement parses a SELECT statement after the SELECT keyword
+func (p *parser) selectStatement(s *statement) error {
+	if p.peek().is("distinct") {
+		return unsupported("SELECT DISTINCT queries")
+	}
+	p.accept("all")
+	items, err := p.selectList()
+	if err != nil {
+		return err
+	}
+	s.items = items
+	if p.accept("from") {
+		if p.peek().is("(") || p.peekAt(1).is("(") {
+			return unsupported("subqueries and table functions")
+		}
+		if s.table, s.alias, err = p.tableRef(true); err != nil {
+			return err
+		}
+		for {
+			j, err := p.join()
+			if err != nil {
+				return err
+			}
+			if j == nil {
+				break
+			}
+			if !j.cross && j.on == nil {
+				return p.unexpected()
+			}
+			s.joins = append(s.joins, j)
+		}
+	}
+	if err := p.where(s); err != nil {
+		return err
+	}
+	if p.accept("group", "by") {
+		for {
+			e, err := p.expr(precOr)
+			if err != nil {
+				return err
+			}
+			s.groups = append(s.groups, e)
+			if !p.accept(",") {
+				break
+			}
+		}
+	}
+	if p.accept("having") {
+		if s.having, err = p.expr(precOr); err != nil {
+			return err
+		}
+	}
+	if p.peek().is("union") || p.peek().is("intersect") || p.peek().is("except") {
+		return unsupported("set operations")
+	}
+	if p.accept("order", "by") {
+		for {
+			item, err := p.orderItem()
+			if err != nil {
+				return err
+			}
+			s.orders = append(s.orders, item)
+			if !p.accept(",") {
+				break
+			}
+		}
+	}
+	for {
+		switch {
+		case p.accept("limit"):
+			if p.accept("all") {
+				s.limit = nil
+				continue
+			}
+			if s.limit, err = p.expr(precOr); err != nil {
+				return err
+			}
+		case p.accept("offset"):
+			if s.offset, err = p.expr(precOr); err != nil {
+				return err
+			}
+			if !p.accept("rows") {
+				p.accept("row")
+			}
+		case p.accept("for"):
+			// Row locks have no effect, as MockDB runs one statement at a time
+			for t := p.peek(); t.kind != tokEOF && !t.is(";"); t = p.peek() {
+				p.next()
+			}
+		default:
+			return nil
+		}
+	}
+}
+
+// insertStatement parses an INSERT statement after INSERT INTO
+func (p *parser) insertStatement(s *statement) error {
+	var err error
+	if s.table, s.alias, err = p.tableRef(false); err != nil {
+		return err
+	}
+	if p.accept("(") {
+		for {
+			name, err := p.ident()
+			if err != nil {
+				return err
+			}
+			s.columns = append(s.columns, name)
+			if !p.accept(",") {
+				break
+			}
+		}
+		if err := p.expect(")"); err != nil {
+			return err
+		}
+	}
+	switch {
+	case p.accept("default", "values"):
+		s.values = [][]expr{nil}
+	case p.accept("values"):
+		for {
+			row, err := p.valuesRow()
+			if err != nil {
+				return err
+			}
+			s.values = append(s.values, row)
+			if !p.accept(",") {
+				break
+			}
+		}
+	case p.peek().is("select"):
+		return unsupported("INSERT ... SELECT statements")
+	default:
+		return p.unexpected()
+	}
+	if p.peek().is("on") {
+		return unsupported("ON CONFLICT clauses")
+	}
+	return p.returning(s)
+}
+
+// valuesRow parses a row of the VALUES of an INSERT, where DEFAULT is a nil
+// expression
+func (p *parser) valuesRow() ([]expr, error) {
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	var row []expr
+	for {
+		if p.accept("default") {
+			row = append(row, nil)
+		} else {
+			e, err := p.expr(precOr)
+			if err != nil {
+				return nil, err
+			}
+			row = append(row, e)
+		}
+		if !p.accept(",") {
+			break
+		}
+	}
+	return row, p.expect(")")
+}
+
+// updateStatement parses an UPDATE statement after the UPDATE keyword
+func (p *parser) updateStatement(s *statement) error {
+	var err error
+	if s.table, s.alias, err = p.tableRef(true); err != nil {
+		return err
+	}
+	if err := p.expect("set"); err != nil {
+		return err
+	}
+	for {
+		name, err := p.ident()
+		if err != nil {
+			return err
+		}
+		if err := p.expect("="); err != nil {
+			return err
+		}
+		if p.peek().is("default") {
+			return unsupported("DEFAULT values in SET clauses")
+		}
+		e, err := p.expr(precOr)
+		if err != nil {
+			return err
+		}
+		s.sets = append(s.sets, statementSet{name, e})
+		if !p.accept(",") {
+			break
+		}
+	}
+	if p.peek().is("from") {
+		return unsupported("UPDATE ... FROM statements")
+	}
+	if err := p.where(s); err != nil {
+		return err
+	}
+	return p.returning(s)
+}
+
+// deleteStatement parses a DELETE statement after DELETE FROM
+func (p *parser) deleteStatement(s *statement) error {
+	var err error
+	if s.table, s.alias, err = p.tableRef(true); err != nil {
+		return err
+	}
+	if p.peek().is("using") {
+		return unsupported("DELETE ... USING statements")
+	}
+	if err := p.where(s); err != nil {
+		return err
+	}
+	return p.returning(s)
+}
+
+// tableRef parses a table name, which may be qualified by a schema, and its
+// alias, which defaults to the name. An alias needs AS unless bare is true.
+func (p *parser) tableRef(bare bool) (string, string, error) {
+	p.accept("only")
+	name, err := p.ident()
+	if err != nil {
+		return "", "", err
+	}
+	if p.accept(".") {
+		if name, err = p.ident(); err != nil {
+			return "", "", err
+		}
+	}
+	alias := name
+	if p.accept("as") || bare && (p.peek().kind == tokQuotedIdent ||
+		p.peek().kind == tokIdent && !isReserved(p.peek().text)) {
+		if alias, err = p.ident(); err != nil {
+			return "", "", err
+		}
+	}
+	return name, alias, nil
+}
+
+// selectList parses a comma separated list of select items
+func (p *parser) selectList() ([]selectItem, error) {
+	var items []selectItem
+	for {
+		item, err := p.selectItem()
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+		if !p.accept(",") {
+			return items, nil
+		}
+	}
+}
+
+// where parses an optional WHERE clause
+func (p *parser) where(s *statement) error {
+	if !p.accept("where") {
+		return nil
+	}
+	e, err := p.expr(precOr)
+	s.where = e
+	return err
+}
+
+// returning parses an optional RETURNING clause
+func (p *parser) returning(s *statement) error {
+	if !p.accept("returning") {
+		return nil
+	}
+	items, err := p.selectList()
+	s.items, s.returning = items, true
+	return err
+}
+
+// execSQL runs a SQL statement against the models of the MockDB, or of tx if
+// it is not nil, scanning the rows it returns into model unless it is nil. If
+// one is true, the statement must return or affect exactly one row, like
+// QueryOne. It returns false if the statement cannot be run, when it should be
+// served from the queued responses instead.
+func (db *MockDB) execSQL(tx *MockTx, one bool, model, query interface{}, params []interface{}) (pg.Result, bool, error) {
+	sql, ok := query.(string)
+	if !ok {
+		return nil, false, nil
+	}
+	var tbl *table
+	if model != nil {
+		t := reflect.TypeOf(model)
+		for t.Kind() == reflect.Ptr || isSlice(t) {
+			t = t.Elem()
+		}
+		if t.Kind() == reflect.Struct && !isTimeType(t) {
+			tbl = getTable(t)
+		}
+	}
+	// Only SQL which is certainly malformed, like an unterminated string or
+	// unbalanced parentheses, returns an error. Anything else MockDB cannot
+	// parse may be valid Postgres, so falls back to the queued responses.
+	tokens, err := lexSQL(sql, params, tbl, model)
+	if pgErr, ok := err.(pg.Error); ok && pgErr.Field('C') == CodeFeatureNotSupported {
+		return nil, false, nil
+	}
+	if err == nil {
+		err = checkParens(tokens)
+	}
+	if err != nil {
+		return nil, true, err
+	}
+	s, err := parseStatement(tokens)
+	if err != nil {
+		return nil, false, nil
+	}
+
+	models, now := db.models, db.now()
+	if tx != nil {
+		models, now = tx.models, tx.start
+	}
+	var st *table
+	if s.table != "" {
+		if st = statementTable(s.table, tbl, models, db.models); st == nil {
+			return nil, false, nil
+		}
+	}
+	x := &statementExec{db: db, tx: tx, s: s, tbl: st, models: models, now: now}
+	sources := []*rowSource{{tbl: st, name: s.table, alias: s.alias}}
+	for _, j := range s.joins {
+		jt := statementTable(j.table, tbl, models, db.models)
+		if jt == nil {
+			return nil, false, nil
+		}
+		tj, err := newTableJoin(j, jt, sources)
+		if err != nil {
+			return nil, true, err
+		}
+		x.joins = append(x.joins, tj)
+		sources = append(sources, tj.source)
+	}
+	var results []resultRow
+	var n int
+	switch s.verb {
+	case "select":
+		results, err = x.selectRows()
+		n = len(results)
+	case "insert":
+		results, n, err = x.insert()
+	case "update":
+		results, n, err = x.update()
+	case "delete":
+		results, n, err = x.delete()
+	}
+	if err != nil {
+		return nil, true, err
+	}
+	if model != nil && (s.verb == "select" || s.returning) {
+		if err := scanResults([]interface{}{model}, results, false); err != nil {
+			return nil, true, err
+		}
+	}
+	if one {
+		if n == 0 {
+			return nil, true, pg.ErrNoRows
+		}
+		if n > 1 {
+			return nil, true, pg.ErrMultiRows
+		}
+	}
+	return NewMockResult(nil, n, len(results)), true, nil
+}
+
+// statementTable returns the table named name, of the query's model or of any
+// stored model, or nil if there is none
+func statementTable(name string, tbl *table, models ...[]interface{}) *table {
+	if tbl != nil && tbl.name == name {
+		return tbl
+	}
+	for _, ms := range models {
+		for _, m := range ms {
+			if t := getTable(reflect.Indirect(reflect.ValueOf(m)).Type()); t.name == name {
+				return t
+			}
+		}
+	}
+	return nil
+}
+
+// statementExec runs a parsed statement
+type statementExec struct {
+	db     *MockDB
+	tx     *MockTx
+	s      *statement
+	tbl    *table
+	joins  []*tableJoin
+	models []interface{}
+	now    time.Time
+}
+
+// selectRows returns the results of a SELECT
+func (x *statementExec) selectRows() ([]resultRow, error) {
+	var rows []*evalContext
+	if x.tbl == nil {
+		ctx := &evalContext{now: x.now}
+		ok := true
+		if x.s.where != nil {
+			var err error
+			if ok, err = evalCondition(ctx, x.s.where); err != nil {
+				return nil, err
+			}
+		}
+		if ok {
+			rows = append(rows, ctx)
+		}
+	} else {
+		var err error
+		if rows, err = x.rows(); err != nil {
+			return nil, err
+		}
+	}
+	ctx := &evalContext{now: x.now}
+	offset, err := evalRowCount(ctx, x.s.offset, "OFFSET", CodeInvalidRowCountInOffset)
+	if err != nil {
+		return nil, err
+	}
+	limit, err := evalRowCount(ctx, x.s.limit, "LIMIT", CodeInvalidRowCountInLimit)
+	if err != nil {
+		return nil, err
+	}
+	q := &selectQuery{
+		items:  x.s.items,
+		groups: x.s.groups,
+		having: x.s.having,
+		orders: x.s.orders,
+		limit:  limit,
+		offset: offset,
+	}
+	// Select the stored rows themselves for *, so they are copied as they are
+	if star, ok := q.items[0].e.(*starExpr); ok && len(q.items) == 1 && star.table == "" &&
+		x.tbl != nil && len(x.joins) == 0 && !q.grouped() {
+		q.items = nil
+	}
+	if x.tbl != nil {
+		ctx = x.context(reflect.Value{}, nil)
+		for _, j := range x.joins {
+			ctx = ctx.with(j.source, reflect.Value{}, nil)
+		}
+	}
+	return q.run(ctx, rows)
+}
+
+// rows returns the rows of the statement's table, joined to the rows of the
+// tables it joins, matching its WHERE clause. Like Postgres, soft deleted rows
+// are included.
+func (x *statementExec) rows() ([]*evalContext, error) {
+	var rows []*evalContext
+	for _, m := range x.models {
+		v := reflect.Indirect(reflect.ValueOf(m))
+		if v.Type() == x.tbl.typ {
+			rows = append(rows, x.context(v, m))
+		}
+	}
+	for _, j := range x.joins {
+		var err error
+		if rows, err = j.rows(rows, x.models); err != nil {
+			return nil, err
+		}
+	}
+	if x.s.where == nil {
+		return rows, nil
+	}
+	var matched []*evalContext
+	for _, ctx := range rows {
+		ok, err := evalCondition(ctx, x.s.where)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			matched = append(matched, ctx)
+		}
+	}
+	return matched, nil
+}
+
+// context returns the context to evaluate expressions against a row of the
+// statement's table
+func (x *statementExec) context(row reflect.Value, model interface{}) *evalContext {
+	return &evalContext{
+		sources: []*rowSource{{tbl: x.tbl, name: x.tbl.name, alias: x.s.alias, row: row, model: model}},
+		now:     x.now,
+	}
+}
+
+// evalRowCount evaluates the expression of a LIMIT or OFFSET clause, returning
+// -1 if there is none or it is null
+func evalRowCount(ctx *evalContext, e expr, clause, code string) (int, error) {
+	if e == nil {
+		return -1, nil
+	}
+	v, err := e.eval(ctx)
+	if err != nil || v == nil {
+		return -1, err
+	}
+	if v, err = castValue(v, "bigint"); err != nil {
+		return -1, err
+	}
+	n := v.(int64)
+	if n < 0 {
+		return -1, PGError(code, fmt.Sprintf("%s must not be negative", clause))
+	}
+	return int(n), nil
+}
+
+// project evaluates the statement's select or RETURNING list against rows
+func (x *statementExec) project(rows []*evalContext, items []selectItem) ([]resultRow, error) {
+	results := make([]resultRow, len(rows))
+	for i, ctx := range rows {
+		var err error
+		if results[i], err = project(ctx, items); err != nil {
+			return nil, err
+		}
+	}
+	return results, nil
+}
+
+// returning returns the results of the RETURNING list of a write for the rows
+// it wrote, or nil if it has none
+func (x *statementExec) returning(rows []*evalContext) ([]resultRow, error) {
+	if !x.s.returning {
+		return nil, nil
+	}
+	items := x.s.items
+	if star, ok := items[0].e.(*starExpr); ok && len(items) == 1 && star.table == "" {
+		items = nil
+	}
+	return x.project(rows, items)
+}
+
+// insert runs an INSERT, returning the results of its RETURNING list and the
+// number of rows inserted
+func (x *statementExec) insert() ([]resultRow, int, error) {
+	columns := make([]*column, len(x.s.columns))
+	for i, name := range x.s.columns {
+		if columns[i] = x.tbl.column(name); columns[i] == nil {
+			return nil, 0, PGError(CodeUndefinedColumn, fmt.Sprintf(
+				"column %q of relation %q does not exist", name, x.tbl.name))
+		}
+	}
+	if len(x.s.columns) == 0 {
+		columns = x.tbl.columns
+	}
+	var inserted []interface{}
+	var rows []*evalContext
+	for _, values := range x.s.values {
+		if len(values) > len(columns) {
+			return nil, 0, syntaxError("INSERT has more expressions than target columns")
+		}
+		if len(values) < len(columns) && len(x.s.columns) > 0 {
+			return nil, 0, syntaxError("INSERT has more target columns than expressions")
+		}
+		v := reflect.New(x.tbl.typ)
+		ctx := &evalContext{now: x.now}
+		for i, e := range values {
+			if e == nil {
+				continue
+			}
+			value, err := e.eval(ctx)
+			if err != nil {
+				return nil, 0, err
+			}
+			f := columns[i].value(v.Elem())
+			if !f.IsValid() || value == nil {
+				continue
+			}
+			if err := setSQLValue(f, value); err != nil {
+				return nil, 0, err
+			}
+		}
+		inserted = append(inserted, v.Interface())
+		rows = append(rows, x.context(v.Elem(), v.Interface()))
+	}
+	err := x.db.statement(x.tx, func() error {
+		if x.tx != nil {
+			return x.tx.insert(inserted)
+		}
+		return x.db.insert(inserted)
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	results, err := x.returning(rows)
+	return results, len(inserted), err
+}
+
+// queryAssignment is an assignment of an UPDATE, setting a column to the
+// value of an expression
+type queryAssignment struct {
+	col *column
+	e   expr
+}
+
+// assign sets the columns of v, a copy of the row of ctx in the table tbl, to
+// the values of the assignments evaluated against the row
+func assign(tbl *table, v reflect.Value, ctx *evalContext, sets []queryAssignment) error {
+	values := make([]interface{}, len(sets))
+	for i, s := range sets {
+		value, err := s.e.eval(ctx)
+		if err != nil {
+			return err
+		}
+		values[i] = value
+	}
+	for i, s := range sets {
+		if isPK(tbl, s.col) {
+			old := columnValue(s.col, s.col.value(ctx.sources[0].row))
+			if eq, err := equalValues(old, values[i]); old == nil || values[i] == nil || err != nil || !eq {
+				return unsupported("updates of primary keys")
+			}
+		}
+		f := s.col.value(v)
+		if !f.IsValid() {
+			continue
+		}
+		if err := setSQLValue(f, values[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// update runs an UPDATE, returning the results of its RETURNING list and the
+// number of rows updated
+func (x *statementExec) update() ([]resultRow, int, error) {
+	sets := make([]queryAssignment, len(x.s.sets))
+	for i, s := range x.s.sets {
+		c := x.tbl.column(s.column)
+		if c == nil {
+			return nil, 0, PGError(CodeUndefinedColumn, fmt.Sprintf(
+				"column %q of relation %q does not exist", s.column, x.tbl.name))
+		}
+		sets[i] = queryAssignment{c, s.e}
+	}
+	rows, err := x.rows()
+	if err != nil {
+		return nil, 0, err
+	}
+	updated := make([]*evalContext, len(rows))
+	for i, ctx := range rows {
+		row := ctx.sources[0].row
+		c := reflect.New(row.Type())
+		c.Elem().Set(copyValue(row, map[copyKey]reflect.Value{}))
+		if err := assign(x.tbl, c.Elem(), ctx, sets); err != nil {
+			return nil, 0, err
+		}
+		updated[i] = x.context(c.Elem(), c.Interface())
+	}
+	err = x.db.statement(x.tx, func() error {
+		for _, ctx := range updated {
+			var err error
+			if x.tx != nil {
+				err = x.tx.update(ctx.sources[0].model, false)
+			} else {
+				err = x.db.update(ctx.sources[0].model, false)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	results, err := x.returning(updated)
+	return results, len(updated), err
+}
+
+// delete runs a DELETE, returning the results of its RETURNING list and the
+// number of rows deleted. Rows are removed even if the table has a soft_delete
+// column, as the statement is run as it is.
+func (x *statementExec) delete() ([]resultRow, int, error) {
+	rows, err := x.rows()
+	if err != nil {
+		return nil, 0, err
+	}
+	var deleted []*evalContext
+	err = x.db.statement(x.tx, func() error {
+		for _, ctx := range rows {
+			m := ctx.sources[0].model
+			models := x.db.models
+			if x.tx != nil {
+				models = x.tx.models
+			}
+			// A row may have gone already, deleted by a cascade
+			if i, err := indexModel(models, m, true); err != nil || i < 0 {
+				continue
+			}
+			var err error
+			if x.tx != nil {
+				err = x.tx.delete(deepCopy(m), true)
+			} else {
+				err = x.db.delete(deepCopy(m), true)
+			}
+			if err != nil {
+				return err
+			}
+			deleted = append(deleted, ctx)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	results, err := x.returning(deleted)
+	return results, len(deleted), err
+}
@@ -0,0 +1,271 @@
+package testutils
+
+import (
+	"testing"
+
+	"github.com/go-pg/pg/v9"
+)
+
+func TestEvaluateSQL(t *testing.T) {
+	members := func() *MockDB {
+		db := NewMockDB()
+		db.SetEvaluateSQL(true)
+		db.QueueModels(
+			&Member{ID: 1, Email: "ann@example.com", Name: "Ann", TeamID: 1, Number: 1},
+			&Member{ID: 2, Email: "bob@example.com", Name: "Bob", TeamID: 1, Number: 2},
+			&Member{ID: 3, Email: "cat@example.org", Name: "Cat", TeamID: 2, Number: 1},
+		)
+		return db
+	}
+
+	t.Run("Select", func(t *testing.T) {
+		db := members()
+		var ms []Member
+		res, err := db.Query(&ms, `SELECT * FROM members WHERE team_id = ? ORDER BY id DESC LIMIT 5`, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(ms) != 2 || ms[0].ID != 2 || ms[1].Name != "Ann" {
+			t.Fatalf("expected members 2 and 1; found %+v", ms)
+		}
+		if res.RowsReturned() != 2 {
+			t.Fatalf("expected 2 rows returned; found %d", res.RowsReturned())
+		}
+
+		m := &Member{}
+		if _, err := db.QueryOne(m, `SELECT id, name FROM "members" AS m WHERE m.email = $1`, "cat@example.org"); err != nil {
+			t.Fatal(err)
+		}
+		if m.ID != 3 || m.Name != "Cat" || m.Email != "" {
+			t.Fatalf("expected only the id and name of member 3; found %+v", m)
+		}
+
+		var name string
+		if _, err := db.QueryOne(&name, `SELECT upper(name) FROM members ORDER BY name DESC LIMIT 1 OFFSET 1`); err != nil {
+			t.Fatal(err)
+		}
+		if name != "BOB" {
+			t.Fatalf("expected BOB; found %q", name)
+		}
+
+		if _, err := db.QueryOne(&Member{}, `SELECT * FROM members WHERE id = 4`); err != pg.ErrNoRows {
+			t.Fatalf("expected pg.ErrNoRows; found %v", err)
+		}
+		if _, err := db.QueryOne(&Member{}, `SELECT * FROM members WHERE number = 1`); err != pg.ErrMultiRows {
+			t.Fatalf("expected pg.ErrMultiRows; found %v", err)
+		}
+		if _, err := db.Query(&ms, `SELECT * FROM members WHERE missing = 1`); errCode(err) != CodeUndefinedColumn {
+			t.Fatalf("expected an undefined column error; found %v", err)
+		}
+
+		var n int
+		if _, err := db.QueryOne(&n, `SELECT 1 + 2`); err != nil {
+			t.Fatal(err)
+		}
+		if n != 3 {
+			t.Fatalf("expected 3; found %d", n)
+		}
+	})
+
+	t.Run("Insert", func(t *testing.T) {
+		db := members()
+		m := &Member{}
+		_, err := db.QueryOne(m, `INSERT INTO members (email, name, team_id, number) VALUES (?, ?, 2, 2) RETURNING *`,
+			"dan@example.com", "Dan")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m.ID != 4 || m.Name != "Dan" {
+			t.Fatalf("expected the inserted member with a generated key; found %+v", m)
+		}
+		found, _ := db.FindAny(&Member{ID: 4})
+		if found == nil || found.(*Member).Email != "dan@example.com" {
+			t.Fatalf("expected the member to be stored; found %+v", found)
+		}
+
+		res, err := db.Exec(`INSERT INTO members (id, email, name) VALUES (10, 'e@example.com', 'E'), (11, 'f@example.com', 'F')`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.RowsAffected() != 2 {
+			t.Fatalf("expected 2 rows affected; found %d", res.RowsAffected())
+		}
+
+		p := &Post{}
+		if _, err := db.QueryOne(p, `INSERT INTO posts DEFAULT VALUES RETURNING id`); err != nil {
+			t.Fatal(err)
+		}
+		if p.ID != 1 {
+			t.Fatalf("expected a post with a generated key; found %+v", p)
+		}
+
+		_, err = db.Exec(`INSERT INTO members (id, email, name) VALUES (12, 'ann@example.com', 'Ann')`)
+		if errCode(err) != CodeUniqueViolation {
+			t.Fatalf("expected a unique violation; found %v", err)
+		}
+		_, err = db.Exec(`INSERT INTO members (id, email) VALUES (12, 'g@example.com', 'G')`)
+		if errCode(err) != CodeSyntaxError {
+			t.Fatalf("expected a syntax error; found %v", err)
+		}
+	})
+
+	t.Run("Update", func(t *testing.T) {
+		db := members()
+		var names []string
+		res, err := db.Query(&names, `UPDATE members SET name = name || '!', number = number + 10 WHERE team_id = ? RETURNING name`, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.RowsAffected() != 2 || len(names) != 2 || names[0] != "Ann!" {
+			t.Fatalf("expected 2 updated names; found %v", names)
+		}
+		found, _ := db.FindAny(&Member{ID: 2})
+		if m := found.(*Member); m.Name != "Bob!" || m.Number != 12 {
+			t.Fatalf("expected the member to be updated; found %+v", m)
+		}
+
+		// The statement is undone when a constraint fails on any row
+		_, err = db.Exec(`UPDATE members SET number = 1 WHERE team_id = 1`)
+		if errCode(err) != CodeUniqueViolation {
+			t.Fatalf("expected a unique violation; found %v", err)
+		}
+		found, _ = db.FindAny(&Member{ID: 1})
+		if m := found.(*Member); m.Number != 11 {
+			t.Fatalf("expected the update to be undone; found %+v", m)
+		}
+
+		if _, err := db.QueryOne(nil, `UPDATE members SET name = 'x' WHERE id = 4`); err != pg.ErrNoRows {
+			t.Fatalf("expected pg.ErrNoRows; found %v", err)
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		db := NewMockDB()
+		db.SetEvaluateSQL(true)
+		db.QueueModels(&Post{ID: 1, Title: "a"}, &Post{ID: 2, Title: "b"})
+		if err := db.Delete(&Post{ID: 1}); err != nil {
+			t.Fatal(err)
+		}
+
+		// Unlike the ORM, plain SQL sees and removes soft deleted rows
+		var posts []Post
+		if _, err := db.Query(&posts, `DELETE FROM posts RETURNING id`); err != nil {
+			t.Fatal(err)
+		}
+		if len(posts) != 2 {
+			t.Fatalf("expected 2 deleted posts; found %+v", posts)
+		}
+		if deleted, _ := db.Deleted(&Post{ID: 1}); deleted != nil {
+			t.Fatalf("expected the soft deleted post to be removed; found %+v", deleted)
+		}
+	})
+
+	t.Run("Transactions", func(t *testing.T) {
+		db := members()
+		tx, err := db.Begin()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tx.Exec(`DELETE FROM members WHERE id = ?`, 1); err != nil {
+			t.Fatal(err)
+		}
+		var ms []Member
+		if _, err := tx.Query(&ms, `SELECT * FROM members`); err != nil {
+			t.Fatal(err)
+		}
+		if len(ms) != 2 {
+			t.Fatalf("expected 2 members in the transaction; found %d", len(ms))
+		}
+		if err := tx.Rollback(); err != nil {
+			t.Fatal(err)
+		}
+		if found, _ := db.FindAny(&Member{ID: 1}); found == nil {
+			t.Fatal("expected the delete to be rolled back")
+		}
+	})
+
+	t.Run("Falls back to queued responses", func(t *testing.T) {
+		db := members()
+		db.QueueResponses(
+			[]Member{{ID: 7}},
+			Member{ID: 8},
+			[]Member{{ID: 9}},
+		)
+		var ms []Member
+		if _, err := db.Query(&ms, `SELECT m.* FROM members m RIGHT JOIN members n ON n.id = m.id`); err != nil {
+			t.Fatal(err)
+		}
+		if len(ms) != 1 || ms[0].ID != 7 {
+			t.Fatalf("expected the queued response for a right join; found %+v", ms)
+		}
+		m := &Member{}
+		if _, err := db.QueryOne(m, `SELECT * FROM unknown_table`); err != nil {
+			t.Fatal(err)
+		}
+		if m.ID != 8 {
+			t.Fatalf("expected the queued response for an unknown table; found %+v", m)
+		}
+
+		db.SetEvaluateSQL(false)
+		if _, err := db.Query(&ms, `SELECT * FROM members`); err != nil {
+			t.Fatal(err)
+		}
+		if len(ms) != 1 || ms[0].ID != 9 {
+			t.Fatalf("expected the queued response when disabled; found %+v", ms)
+		}
+	})
+
+	t.Run("Syntax errors", func(t *testing.T) {
+		db := members()
+		db.QueueResponses([]Member{{ID: 7}})
+		var ms []Member
+		for _, sql := range []string{
+			`SELECT id FROM members WHERE name = 'Ann`,
+			`SELECT id FROM members WHERE (id = 1`,
+			`SELECT id FROM members WHERE id = 1)`,
+		} {
+			if _, err := db.Query(&ms, sql); errCode(err) != CodeSyntaxError {
+				t.Fatalf("%s: expected a syntax error; found %v", sql, err)
+			}
+		}
+		if len(ms) != 0 {
+			t.Fatalf("expected the queued response not to be used; found %+v", ms)
+		}
+	})
+
+	t.Run("Fallback", func(t *testing.T) {
+		// SQL MockDB cannot parse may be valid Postgres, so uses the queued
+		// responses
+		for _, sql := range []string{
+			`SELECT id FROM members WHERE name ~ '^A'`,
+			`SELECT id /* c */ FROM members`,
+			`SELECT id FROM members WHERE name = E'a'`,
+			`SELECT id FROM members WHERE name = $$a$$`,
+			`SELECT id FROM members WHERE data->>'a' = 'b'`,
+			`SELECT id FROM members WHERE data::jsonb ? 'a'`,
+			`SELECT id FROM members WHERE id = ?`,
+			`SELECT 1; SELECT 2`,
+		} {
+			db := members()
+			db.QueueResponses([]Member{{ID: 7}})
+			var ms []Member
+			if _, err := db.Query(&ms, sql); err != nil {
+				t.Fatalf("%s: %v", sql, err)
+			}
+			if len(ms) != 1 || ms[0].ID != 7 {
+				t.Fatalf("%s: expected the queued response; found %+v", sql, ms)
+			}
+		}
+	})
+
+	t.Run("Calls", func(t *testing.T) {
+		db := members()
+		if _, err := db.Exec(`UPDATE members SET name = ? WHERE id = ?`, "Anne", 1); err != nil {
+			t.Fatal(err)
+		}
+		calls := db.Calls().Method("Exec").WithParams("Anne", 1)
+		if len(calls) != 1 || calls[0].Result.RowsAffected() != 1 {
+			t.Fatalf("expected the Exec call to be recorded; found %+v", calls)
+		}
+	})
+}
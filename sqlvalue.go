@@ -0,0 +1,800 @@
+package testutils
+
+import (
+	"bytes"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Values in SQL expressions are nil for null, bool, int64, float64, string,
+// time.Time, time.Duration for intervals, uuid, []interface{} for arrays, or
+// any other value of a column, like a map of a json column, which can only be
+// compared for equality.
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// columnValue returns the SQL value of the field f of column c, which is null
+// if go-pg would send it as null
+func columnValue(c *column, f reflect.Value) interface{} {
+	if isNull(c, f) {
+		return nil
+	}
+	return sqlValue(f)
+}
+
+// sqlValue converts a Go value to an SQL value
+func sqlValue(v reflect.Value) interface{} {
+	if !v.IsValid() {
+		return nil
+	}
+	if v.CanInterface() {
+		if valuer, ok := v.Interface().(driver.Valuer); ok {
+			if (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) && v.IsNil() {
+				return nil
+			}
+			if dv, err := valuer.Value(); err == nil {
+				return sqlValue(reflect.ValueOf(dv))
+			}
+		}
+	}
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+	t := v.Type()
+	switch {
+	case t == timeType:
+		return v.Interface().(time.Time)
+	case isTimeType(t):
+		return v.Field(0).Interface().(time.Time)
+	case t == durationType:
+		return time.Duration(v.Int())
+	}
+	switch v.Kind() {
+	case reflect.Bool:
+		return v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return int64(v.Uint())
+	case reflect.Float32, reflect.Float64:
+		return v.Float()
+	case reflect.String:
+		return v.String()
+	case reflect.Array:
+		if t.Len() == 16 && t.Elem().Kind() == reflect.Uint8 {
+			var u uuid
+			reflect.Copy(reflect.ValueOf(&u).Elem(), v)
+			return u
+		}
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return string(v.Bytes())
+		}
+	}
+	if v.Kind() == reflect.Slice || v.Kind() == reflect.Array {
+		arr := make([]interface{}, v.Len())
+		for i := range arr {
+			arr[i] = sqlValue(v.Index(i))
+		}
+		return arr
+	}
+	if v.CanInterface() {
+		return v.Interface()
+	}
+	return nil
+}
+
+// typeName returns the name of the Postgres type of a value, for error messages
+func typeName(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "unknown"
+	case bool:
+		return "boolean"
+	case int64:
+		return "bigint"
+	case float64:
+		return "double precision"
+	case string:
+		return "text"
+	case time.Time:
+		return "timestamp with time zone"
+	case time.Duration:
+		return "interval"
+	case uuid:
+		return "uuid"
+	case []interface{}:
+		return "array"
+	}
+	return "jsonb"
+}
+
+// boolValue returns a boolean value, or an error for any other type
+func boolValue(v interface{}) (bool, error) {
+	switch x := v.(type) {
+	case bool:
+		return x, nil
+	case string:
+		if b, ok := parseBool(x); ok {
+			return b, nil
+		}
+	}
+	return false, PGError(CodeDatatypeMismatch,
+		fmt.Sprintf("argument of type %s must be type boolean", typeName(v)))
+}
+
+// parseBool parses the text forms of a boolean Postgres accepts
+func parseBool(s string) (bool, bool) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "t", "true", "y", "yes", "on", "1":
+		return true, true
+	case "f", "false", "n", "no", "off", "0":
+		return false, true
+	}
+	return false, false
+}
+
+// textValue returns the text form of a value, as Postgres casts it to text
+func textValue(v interface{}) string {
+	switch x := v.(type) {
+	case string:
+		return x
+	case bool:
+		if x {
+			return "true"
+		}
+		return "false"
+	case int64:
+		return strconv.FormatInt(x, 10)
+	case float64:
+		return strconv.FormatFloat(x, 'f', -1, 64)
+	case time.Time:
+		return x.Format("2006-01-02 15:04:05.999999-07:00")
+	case []interface{}:
+		parts := make([]string, len(x))
+		for i, e := range x {
+			if e == nil {
+				parts[i] = "NULL"
+			} else {
+				parts[i] = textValue(e)
+			}
+		}
+		return "{" + strings.Join(parts, ",") + "}"
+	}
+	return fmt.Sprint(v)
+}
+
+// arrayValue returns the elements of an array value, parsing the text form
+// '{a,b}' produced by pg.Array
+func arrayValue(v interface{}) ([]interface{}, error) {
+	switch x := v.(type) {
+	case []interface{}:
+		return x, nil
+	case string:
+		s := strings.TrimSpace(x)
+		if !strings.HasPrefix(s, "{") || !strings.HasSuffix(s, "}") {
+			break
+		}
+		s = s[1 : len(s)-1]
+		if s == "" {
+			return []interface{}{}, nil
+		}
+		var arr []interface{}
+		for _, part := range strings.Split(s, ",") {
+			part = strings.TrimSpace(part)
+			if strings.EqualFold(part, "null") {
+				arr = append(arr, nil)
+				continue
+			}
+			arr = append(arr, strings.Trim(part, `"`))
+		}
+		return arr, nil
+	}
+	return nil, PGError(CodeInvalidTextRepresentation,
+		fmt.Sprintf("malformed array literal: %q", textValue(v)))
+}
+
+// coerce converts a pair of non-null values to the same type, like Postgres
+// converting a string constant to the type it is compared with, or returns an
+// error if they cannot be compared
+func coerce(a, b interface{}) (interface{}, interface{}, error) {
+	switch x := a.(type) {
+	case int64:
+		if y, ok := b.(float64); ok {
+			return float64(x), y, nil
+		}
+	case float64:
+		if y, ok := b.(int64); ok {
+			return x, float64(y), nil
+		}
+	case string:
+		if _, ok := b.(string); !ok {
+			c, err := castValue(x, typeName(b))
+			if err != nil {
+				return nil, nil, err
+			}
+			return c, b, nil
+		}
+	}
+	if _, ok := b.(string); ok {
+		if _, ok := a.(string); !ok {
+			c, err := castValue(b, typeName(a))
+			if err != nil {
+				return nil, nil, err
+			}
+			return a, c, nil
+		}
+	}
+	if reflect.TypeOf(a) != reflect.TypeOf(b) {
+		return nil, nil, PGError(CodeUndefinedFunction,
+			fmt.Sprintf("operator does not exist: %s = %s", typeName(a), typeName(b)))
+	}
+	return a, b, nil
+}
+
+// compareValues compares two non-null values, returning -1, 0 or 1
+func compareValues(a, b interface{}) (int, error) {
+	a, b, err := coerce(a, b)
+	if err != nil {
+		return 0, err
+	}
+	switch x := a.(type) {
+	case int64:
+		y := b.(int64)
+		switch {
+		case x < y:
+			return -1, nil
+		case x > y:
+			return 1, nil
+		}
+		return 0, nil
+	case float64:
+		y := b.(float64)
+		switch {
+		case x < y:
+			return -1, nil
+		case x > y:
+			return 1, nil
+		}
+		return 0, nil
+	case time.Duration:
+		y := b.(time.Duration)
+		switch {
+		case x < y:
+			return -1, nil
+		case x > y:
+			return 1, nil
+		}
+		return 0, nil
+	case string:
+		return strings.Compare(x, b.(string)), nil
+	case bool:
+		y := b.(bool)
+		switch {
+		case x == y:
+			return 0, nil
+		case y:
+			return -1, nil
+		}
+		return 1, nil
+	case time.Time:
+		y := b.(time.Time)
+		switch {
+		case x.Before(y):
+			return -1, nil
+		case x.After(y):
+			return 1, nil
+		}
+		return 0, nil
+	case uuid:
+		y := b.(uuid)
+		return bytes.Compare(x[:], y[:]), nil
+	case []interface{}:
+		y := b.([]interface{})
+		for i := 0; i < len(x) && i < len(y); i++ {
+			if x[i] == nil || y[i] == nil {
+				if (x[i] == nil) != (y[i] == nil) {
+					if x[i] == nil {
+						return 1, nil
+					}
+					return -1, nil
+				}
+				continue
+			}
+			c, err := compareValues(x[i], y[i])
+			if err != nil || c != 0 {
+				return c, err
+			}
+		}
+		return len(x) - len(y), nil
+	}
+	if reflect.DeepEqual(a, b) {
+		return 0, nil
+	}
+	return 1, nil
+}
+
+// equalValues returns whether two non-null values are equal
+func equalValues(a, b interface{}) (bool, error) {
+	c, err := compareValues(a, b)
+	return c == 0, err
+}
+
+// compareOp applies a comparison operator to two non-null values
+func compareOp(op string, a, b interface{}) (interface{}, error) {
+	c, err := compareValues(a, b)
+	if err != nil {
+		return nil, err
+	}
+	switch op {
+	case "=":
+		return c == 0, nil
+	case "<>":
+		return c != 0, nil
+	case "<":
+		return c < 0, nil
+	case ">":
+		return c > 0, nil
+	case "<=":
+		return c <= 0, nil
+	}
+	return c >= 0, nil
+}
+
+// concatValues applies the || operator to two non-null values
+func concatValues(a, b interface{}) interface{} {
+	x, xok := a.([]interface{})
+	y, yok := b.([]interface{})
+	switch {
+	case xok && yok:
+		return append(append([]interface{}(nil), x...), y...)
+	case xok:
+		return append(append([]interface{}(nil), x...), b)
+	case yok:
+		return append([]interface{}{a}, y...)
+	}
+	return textValue(a) + textValue(b)
+}
+
+// arithmetic applies an arithmetic operator to two non-null values
+func arithmetic(op string, a, b interface{}) (interface{}, error) {
+	switch x := a.(type) {
+	case time.Time:
+		switch y := b.(type) {
+		case time.Duration:
+			if op == "+" {
+				return x.Add(y), nil
+			}
+			if op == "-" {
+				return x.Add(-y), nil
+			}
+		case time.Time:
+			if op == "-" {
+				return x.Sub(y), nil
+			}
+		case string:
+			if op == "-" {
+				if t, err := parseTime(y); err == nil {
+					return x.Sub(t), nil
+				}
+			}
+			d, err := parseInterval(y)
+			if err != nil {
+				return nil, err
+			}
+			return arithmetic(op, x, d)
+		}
+	case time.Duration:
+		switch y := b.(type) {
+		case time.Time:
+			if op == "+" {
+				return y.Add(x), nil
+			}
+		case time.Duration:
+			switch op {
+			case "+":
+				return x + y, nil
+			case "-":
+				return x - y, nil
+			}
+		case int64:
+			return arithmetic(op, a, float64(y))
+		case float64:
+			switch op {
+			case "*":
+				return time.Duration(float64(x) * y), nil
+			case "/":
+				if y == 0 {
+					return nil, divisionByZero()
+				}
+				return time.Duration(float64(x) / y), nil
+			}
+		}
+	}
+
+	a, b, err := coerce(a, b)
+	if err != nil {
+		return nil, operatorError(op, a, b)
+	}
+	switch x := a.(type) {
+	case int64:
+		y := b.(int64)
+		switch op {
+		case "+":
+			return x + y, nil
+		case "-":
+			return x - y, nil
+		case "*":
+			return x * y, nil
+		case "/", "%":
+			if y == 0 {
+				return nil, divisionByZero()
+			}
+			if op == "/" {
+				return x / y, nil
+			}
+			return x % y, nil
+		}
+	case float64:
+		y := b.(float64)
+		switch op {
+		case "+":
+			return x + y, nil
+		case "-":
+			return x - y, nil
+		case "*":
+			return x * y, nil
+		case "/", "%":
+			if y == 0 {
+				return nil, divisionByZero()
+			}
+			if op == "/" {
+				return x / y, nil
+			}
+			return math.Mod(x, y), nil
+		}
+	}
+	return nil, operatorError(op, a, b)
+}
+
+// operatorError returns the error Postgres gives for an operator which does not
+// exist for the types of its operands
+func operatorError(op string, a, b interface{}) error {
+	return PGError(CodeUndefinedFunction,
+		fmt.Sprintf("operator does not exist: %s %s %s", typeName(a), op, typeName(b)))
+}
+
+// divisionByZero returns the error Postgres gives for division by zero
+func divisionByZero() error {
+	return PGError(CodeDivisionByZero, "division by zero")
+}
+
+// invalidInput returns the error Postgres gives for text which cannot be
+// converted to a type
+func invalidInput(typ string, s string) error {
+	return PGError(CodeInvalidTextRepresentation,
+		fmt.Sprintf("invalid input syntax for type %s: %q", typ, s))
+}
+
+// castValue converts a non-null value to the named Postgres type
+func castValue(v interface{}, typ string) (interface{}, error) {
+	if strings.HasSuffix(typ, "[]") {
+		arr, err := arrayValue(v)
+		if err != nil {
+			return nil, err
+		}
+		elem := strings.TrimSuffix(typ, "[]")
+		out := make([]interface{}, len(arr))
+		for i, e := range arr {
+			if e == nil {
+				continue
+			}
+			if out[i], err = castValue(e, elem); err != nil {
+				return nil, err
+			}
+		}
+		return out, nil
+	}
+	switch typ {
+	case "int", "integer", "bigint", "smallint", "int2", "int4", "int8", "serial", "bigserial":
+		switch x := v.(type) {
+		case int64:
+			return x, nil
+		case float64:
+			return int64(math.Round(x)), nil
+		case bool:
+			if x {
+				return int64(1), nil
+			}
+			return int64(0), nil
+		case string:
+			n, err := strconv.ParseInt(strings.TrimSpace(x), 10, 64)
+			if err != nil {
+				return nil, invalidInput(typ, x)
+			}
+			return n, nil
+		}
+	case "numeric", "decimal", "real", "float", "float4", "float8", "double precision":
+		switch x := v.(type) {
+		case int64:
+			return float64(x), nil
+		case float64:
+			return x, nil
+		case string:
+			f, err := strconv.ParseFloat(strings.TrimSpace(x), 64)
+			if err != nil {
+				return nil, invalidInput(typ, x)
+			}
+			return f, nil
+		}
+	case "text", "varchar", "character varying", "char", "character", "citext", "name":
+		return textValue(v), nil
+	case "bool", "boolean":
+		switch x := v.(type) {
+		case bool:
+			return x, nil
+		case int64:
+			return x != 0, nil
+		case string:
+			b, ok := parseBool(x)
+			if !ok {
+				return nil, invalidInput("boolean", x)
+			}
+			return b, nil
+		}
+	case "timestamp", "timestamptz", "timestamp with time zone", "timestamp without time zone", "date":
+		var t time.Time
+		switch x := v.(type) {
+		case time.Time:
+			t = x
+		case string:
+			parsed, err := parseTime(x)
+			if err != nil {
+				return nil, invalidInput(typ, x)
+			}
+			t = parsed
+		default:
+			return nil, castError(v, typ)
+		}
+		if typ == "date" {
+			y, m, d := t.Date()
+			t = time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+		}
+		return t, nil
+	case "interval":
+		switch x := v.(type) {
+		case time.Duration:
+			return x, nil
+		case string:
+			return parseInterval(x)
+		}
+	case "uuid":
+		switch x := v.(type) {
+		case uuid:
+			return x, nil
+		case string:
+			u, ok := parseUUID(x)
+			if !ok {
+				return nil, invalidInput("uuid", x)
+			}
+			return u, nil
+		}
+	case "array":
+		return arrayValue(v)
+	case "json", "jsonb", "unknown":
+		return v, nil
+	default:
+		return nil, PGError(CodeUndefinedObject, fmt.Sprintf("type %q does not exist", typ))
+	}
+	return nil, castError(v, typ)
+}
+
+// castError returns the error Postgres gives for a cast between types which
+// cannot be converted
+func castError(v interface{}, typ string) error {
+	return PGError(CodeCannotCoerce, fmt.Sprintf("cannot cast type %s to %s", typeName(v), typ))
+}
+
+// timeLayouts are the formats of timestamps and dates accepted in SQL,
+// including the format go-pg sends times in
+var timeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05.999999999-07:00:00",
+	"2006-01-02 15:04:05.999999999-07:00",
+	"2006-01-02 15:04:05.999999999-07",
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02",
+}
+
+// parseTime parses the text form of a timestamp or date, which is in UTC if it
+// has no time zone
+func parseTime(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	for _, layout := range timeLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, invalidInput("timestamp with time zone", s)
+}
+
+// intervalUnits are the lengths of the units of intervals. Months and years
+// are taken as 30 and 365 days.
+var intervalUnits = map[string]time.Duration{
+	"microsecond": time.Microsecond,
+	"millisecond": time.Millisecond,
+	"second":      time.Second,
+	"sec":         time.Second,
+	"minute":      time.Minute,
+	"min":         time.Minute,
+	"hour":        time.Hour,
+	"day":         24 * time.Hour,
+	"week":        7 * 24 * time.Hour,
+	"month":       30 * 24 * time.Hour,
+	"mon":         30 * 24 * time.Hour,
+	"year":        365 * 24 * time.Hour,
+}
+
+// parseInterval parses an interval like '1 day' or '2 hours 30 minutes', or
+// '01:30:00'
+func parseInterval(s string) (time.Duration, error) {
+	fields := strings.Fields(strings.ToLower(s))
+	var d time.Duration
+	for i := 0; i < len(fields); i++ {
+		if strings.Count(fields[i], ":") == 2 {
+			var h, m int64
+			var sec float64
+			if _, err := fmt.Sscanf(fields[i], "%d:%d:%g", &h, &m, &sec); err != nil {
+				return 0, invalidInput("interval", s)
+			}
+			d += time.Duration(h)*time.Hour + time.Duration(m)*time.Minute +
+				time.Duration(sec*float64(time.Second))
+			continue
+		}
+		n, err := strconv.ParseFloat(fields[i], 64)
+		if err != nil || i+1 >= len(fields) {
+			return 0, invalidInput("interval", s)
+		}
+		i++
+		unit := strings.TrimSuffix(fields[i], "s")
+		length, ok := intervalUnits[unit]
+		if !ok {
+			return 0, invalidInput("interval", s)
+		}
+		d += time.Duration(n * float64(length))
+	}
+	return d, nil
+}
+
+// setSQLValue sets dst, a field or scan destination, to an SQL value,
+// converting it to the field's type
+func setSQLValue(dst reflect.Value, v interface{}) error {
+	if dst.CanAddr() {
+		if scanner, ok := dst.Addr().Interface().(sql.Scanner); ok {
+			if arr, ok := v.([]interface{}); ok {
+				v = textValue(arr)
+			}
+			if u, ok := v.(uuid); ok {
+				v = u.String()
+			}
+			if d, ok := v.(time.Duration); ok {
+				v = int64(d)
+			}
+			return scanner.Scan(v)
+		}
+	}
+	if v == nil {
+		dst.Set(reflect.Zero(dst.Type()))
+		return nil
+	}
+	t := dst.Type()
+	if t.Kind() == reflect.Ptr {
+		p := reflect.New(t.Elem())
+		if err := setSQLValue(p.Elem(), v); err != nil {
+			return err
+		}
+		dst.Set(p)
+		return nil
+	}
+	if t.Kind() == reflect.Interface {
+		dst.Set(reflect.ValueOf(v))
+		return nil
+	}
+
+	switch x := v.(type) {
+	case time.Time:
+		if isTimeType(t) || t == timeType {
+			setTime(dst, x)
+			return nil
+		}
+		if t.Kind() == reflect.String {
+			dst.SetString(textValue(x))
+			return nil
+		}
+	case time.Duration:
+		if t.Kind() == reflect.Int64 {
+			dst.SetInt(int64(x))
+			return nil
+		}
+	case uuid:
+		switch {
+		case t.Kind() == reflect.String:
+			dst.SetString(x.String())
+			return nil
+		case t.Kind() == reflect.Array && t.Len() == 16 && t.Elem().Kind() == reflect.Uint8:
+			reflect.Copy(dst, reflect.ValueOf(x))
+			return nil
+		}
+	case string:
+		switch {
+		case t.Kind() == reflect.String:
+			dst.SetString(x)
+			return nil
+		case t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8:
+			dst.SetBytes([]byte(x))
+			return nil
+		case isNumber(t.Kind()) || t.Kind() == reflect.Bool || isTimeType(t) || t == timeType ||
+			t.Kind() == reflect.Array && t.Len() == 16:
+			typ := "numeric"
+			switch {
+			case t.Kind() == reflect.Bool:
+				typ = "boolean"
+			case t.Kind() == reflect.Array:
+				typ = "uuid"
+			case isTimeType(t) || t == timeType:
+				typ = "timestamptz"
+			}
+			c, err := castValue(x, typ)
+			if err != nil {
+				return err
+			}
+			return setSQLValue(dst, c)
+		}
+	case bool:
+		if t.Kind() == reflect.Bool {
+			dst.SetBool(x)
+			return nil
+		}
+	case int64, float64:
+		if isNumber(t.Kind()) {
+			dst.Set(reflect.ValueOf(x).Convert(t))
+			return nil
+		}
+		if t.Kind() == reflect.String {
+			dst.SetString(textValue(x))
+			return nil
+		}
+	case []interface{}:
+		if t.Kind() == reflect.Slice {
+			s := reflect.MakeSlice(t, len(x), len(x))
+			for i, e := range x {
+				if err := setSQLValue(s.Index(i), e); err != nil {
+					return err
+				}
+			}
+			dst.Set(s)
+			return nil
+		}
+	}
+	src := reflect.ValueOf(v)
+	switch {
+	case src.Type().AssignableTo(t):
+		dst.Set(reflect.ValueOf(deepCopy(v)))
+		return nil
+	case src.Type().ConvertibleTo(t):
+		dst.Set(src.Convert(t))
+		return nil
+	}
+	return fmt.Errorf("testutils: cannot scan %s into %s", typeName(v), t)
+}
@@ -0,0 +1,291 @@
+package testutils
+
+import (
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+// column is a struct field which go-pg maps to a table column
+type column struct {
+	field   string
+	index   []int
+	name    string
+	typ     reflect.Type
+	options map[string]string
+}
+
+// hasOption returns whether the column's pg tag includes the option, for
+// example "pk" or "notnull"
+func (c *column) hasOption(name string) bool {
+	_, ok := c.options[name]
+	return ok
+}
+
+// value returns the column's field in v, a struct, or an invalid Value if it is
+// in a nil embedded struct pointer
+func (c *column) value(v reflect.Value) reflect.Value {
+	for i, x := range c.index {
+		if i > 0 {
+			if v.Kind() == reflect.Ptr {
+				if v.IsNil() {
+					return reflect.Value{}
+				}
+				v = v.Elem()
+			}
+		}
+		v = v.Field(x)
+	}
+	return v
+}
+
+// table describes how go-pg maps a struct type to a table
+type table struct {
+	typ     reflect.Type
+	name    string
+	columns []*column
+	pks     []*column
+
+	// alias is the name go-pg gives the table in queries, the underscored
+	// type name unless the tableName field has an alias: tag
+	alias string
+
+	// discardUnknownColumns is set by the tableName field's
+	// discard_unknown_columns option, which ignores query results with no
+	// column in the struct rather than failing
+	discardUnknownColumns bool
+
+	// softDelete is the column tagged soft_delete, or nil
+	softDelete *column
+
+	// uniques are the columns of each unique constraint. Columns tagged
+	// unique:name with the same name share a constraint.
+	uniques [][]*column
+
+	// relationFields are the fields go-pg treats as relations to other tables,
+	// which are not columns
+	relationFields []*column
+}
+
+// tables caches the table of each struct type
+var tables sync.Map
+
+// getTable returns the table for a struct type, or a pointer to one
+func getTable(t reflect.Type) *table {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if tbl, ok := tables.Load(t); ok {
+		return tbl.(*table)
+	}
+	tbl := newTable(t)
+	tables.Store(t, tbl)
+	return tbl
+}
+
+// newTable reads the pg struct tags of a struct type
+func newTable(t reflect.Type) *table {
+	tbl := &table{
+		typ:   t,
+		name:  pluralize(underscore(t.Name())),
+		alias: underscore(t.Name()),
+	}
+	if t.Kind() != reflect.Struct {
+		return tbl
+	}
+	tbl.addColumns(t, nil)
+
+	for _, c := range tbl.columns {
+		if c.hasOption("pk") {
+			tbl.pks = append(tbl.pks, c)
+		}
+		if c.hasOption("soft_delete") && tbl.softDelete == nil {
+			tbl.softDelete = c
+		}
+	}
+	groups := make(map[string]int)
+	for _, c := range tbl.columns {
+		group, ok := c.options["unique"]
+		if !ok {
+			continue
+		}
+		if i, ok := groups[group]; ok && group != "" {
+			tbl.uniques[i] = append(tbl.uniques[i], c)
+			continue
+		}
+		groups[group] = len(tbl.uniques)
+		tbl.uniques = append(tbl.uniques, []*column{c})
+	}
+	if len(tbl.pks) == 0 {
+		for _, c := range tbl.columns {
+			if c.name == "id" {
+				tbl.pks = append(tbl.pks, c)
+				break
+			}
+		}
+	}
+	return tbl
+}
+
+// addColumns adds the columns of the fields of t, flattening embedded structs
+func (tbl *table) addColumns(t reflect.Type, index []int) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, options := parseTag(f.Tag.Get("pg"))
+		if f.Name == "tableName" {
+			if name != "" {
+				tbl.name = strings.Trim(name, `"`)
+			}
+			if alias := options["alias"]; alias != "" {
+				tbl.alias = strings.Trim(alias, `"`)
+			}
+			_, tbl.discardUnknownColumns = options["discard_unknown_columns"]
+			continue
+		}
+		if name == "-" || (f.PkgPath != "" && !f.Anonymous) {
+			continue
+		}
+		fieldIndex := append(append([]int(nil), index...), i)
+
+		ft := f.Type
+		if ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if f.Anonymous && ft.Kind() == reflect.Struct && !isTimeType(ft) {
+			tbl.addColumns(ft, fieldIndex)
+			continue
+		}
+		if f.PkgPath != "" {
+			continue
+		}
+		if isRelation(f.Type, options) {
+			tbl.relationFields = append(tbl.relationFields, &column{
+				field:   f.Name,
+				index:   fieldIndex,
+				name:    underscore(f.Name),
+				typ:     f.Type,
+				options: options,
+			})
+			continue
+		}
+
+		if name == "" {
+			name = underscore(f.Name)
+		}
+		tbl.columns = append(tbl.columns, &column{
+			field:   f.Name,
+			index:   fieldIndex,
+			name:    name,
+			typ:     f.Type,
+			options: options,
+		})
+	}
+}
+
+// column returns the table's column with the name, or nil
+func (tbl *table) column(name string) *column {
+	for _, c := range tbl.columns {
+		if c.name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// isRelation returns whether go-pg treats a field of type t as a relation to
+// another table rather than a column
+func isRelation(t reflect.Type, options map[string]string) bool {
+	for _, opt := range []string{"composite", "json_use_number", "array", "hstore"} {
+		if _, ok := options[opt]; ok {
+			return false
+		}
+	}
+	if typ, ok := options["type"]; ok && strings.HasPrefix(typ, "json") {
+		return false
+	}
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() == reflect.Slice {
+		t = t.Elem()
+		if t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+	}
+	return t.Kind() == reflect.Struct && !isTimeType(t)
+}
+
+// isTimeType returns whether t is time.Time or a struct wrapping it, like
+// pg.NullTime
+func isTimeType(t reflect.Type) bool {
+	if t == timeType {
+		return true
+	}
+	return t.Kind() == reflect.Struct && t.NumField() > 0 &&
+		t.Field(0).Anonymous && t.Field(0).Type == timeType
+}
+
+// parseTag splits a pg struct tag into the column name and a map of options,
+// with the value of key:value options
+func parseTag(tag string) (string, map[string]string) {
+	options := make(map[string]string)
+	parts := strings.Split(tag, ",")
+	for _, part := range parts[1:] {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		if i := strings.Index(part, ":"); i >= 0 {
+			options[part[:i]] = part[i+1:]
+		} else {
+			options[part] = ""
+		}
+	}
+	name := parts[0]
+	// A name with a colon is an option, like pg:"default:now()"
+	if i := strings.Index(name, ":"); i >= 0 {
+		options[name[:i]] = name[i+1:]
+		name = ""
+	}
+	return name, options
+}
+
+// underscore converts a Go name to a column or table name the same way as
+// go-pg, for example UserID to user_id
+func underscore(s string) string {
+	isUpper := func(c byte) bool { return c >= 'A' && c <= 'Z' }
+	isLower := func(c byte) bool { return c >= 'a' && c <= 'z' }
+	r := make([]byte, 0, len(s)+5)
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if isUpper(c) {
+			if i > 0 && i+1 < len(s) && (isLower(s[i-1]) || isLower(s[i+1])) {
+				r = append(r, '_', c+'a'-'A')
+			} else {
+				r = append(r, c+'a'-'A')
+			}
+		} else {
+			r = append(r, c)
+		}
+	}
+	return string(r)
+}
+
+// pluralize returns the plural of an English noun for the default table name
+// of a type, covering the common cases handled by go-pg's inflection library
+func pluralize(s string) string {
+	switch {
+	case s == "":
+		return s
+	case strings.HasSuffix(s, "y") && len(s) > 1 && !strings.ContainsAny(s[len(s)-2:len(s)-1], "aeiou"):
+		return s[:len(s)-1] + "ies"
+	case strings.HasSuffix(s, "s") || strings.HasSuffix(s, "x") || strings.HasSuffix(s, "z") ||
+		strings.HasSuffix(s, "ch") || strings.HasSuffix(s, "sh"):
+		return s + "es"
+	default:
+		return s + "s"
+	}
+}
@@ -0,0 +1,146 @@
+package testutils
+
+import (
+	"reflect"
+	"testing"
+)
+
+type Account struct {
+	ID    int64
+	Email string
+}
+
+type Membership struct {
+	tableName struct{} `pg:"org_members"`
+
+	OrgID  int `pg:",pk"`
+	UserID int `pg:",pk"`
+	Role   string
+}
+
+type BaseFields struct {
+	UUID string `pg:"uuid,pk"`
+}
+
+type Document struct {
+	BaseFields
+	Title  string
+	Author *Account
+}
+
+type Category struct {
+	ID int
+}
+
+type Note struct {
+	Text string
+}
+
+func TestTable(t *testing.T) {
+	t.Run("Underscore", func(t *testing.T) {
+		for name, want := range map[string]string{
+			"ID":         "id",
+			"UserID":     "user_id",
+			"HTTPServer": "http_server",
+			"CreatedAt":  "created_at",
+		} {
+			if got := underscore(name); got != want {
+				t.Fatalf("underscore(%q) = %q; expected %q", name, got, want)
+			}
+		}
+	})
+
+	t.Run("Names", func(t *testing.T) {
+		if name := getTable(reflect.TypeOf(&Account{})).name; name != "accounts" {
+			t.Fatalf("expected table accounts; found %s", name)
+		}
+		if name := getTable(reflect.TypeOf(&Membership{})).name; name != "org_members" {
+			t.Fatalf("expected table org_members; found %s", name)
+		}
+		if name := getTable(reflect.TypeOf(Category{})).name; name != "categories" {
+			t.Fatalf("expected table categories; found %s", name)
+		}
+	})
+
+	t.Run("Columns", func(t *testing.T) {
+		tbl := getTable(reflect.TypeOf(&Document{}))
+		if len(tbl.columns) != 2 {
+			t.Fatalf("expected 2 columns; found %d", len(tbl.columns))
+		}
+		if len(tbl.pks) != 1 || tbl.pks[0].name != "uuid" {
+			t.Fatalf("expected embedded uuid primary key; found %v", tbl.pks)
+		}
+		if tbl.column("author") != nil {
+			t.Fatal("relation should not be a column")
+		}
+	})
+}
+
+func TestPlainStructs(t *testing.T) {
+	t.Run("Insert, Select, Update and Delete", func(t *testing.T) {
+		db := NewMockDB()
+		if err := db.Insert(&Account{ID: 1, Email: "a@example.com"}, &Account{ID: 2}); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := db.Update(&Account{ID: 1, Email: "b@example.com"}); err != nil {
+			t.Fatal(err)
+		}
+		a := &Account{ID: 1}
+		if err := db.Select(a); err != nil {
+			t.Fatal(err)
+		}
+		if a.Email != "b@example.com" {
+			t.Fatalf("expected updated email; found %q", a.Email)
+		}
+
+		if err := db.Delete(&Account{ID: 2}); err != nil {
+			t.Fatal(err)
+		}
+		if found, _ := db.FindAny(&Account{ID: 2}); found != nil {
+			t.Fatal("expected account 2 to be deleted")
+		}
+	})
+
+	t.Run("Composite keys", func(t *testing.T) {
+		db := NewMockDB()
+		db.QueueModels(&Membership{OrgID: 1, UserID: 1, Role: "owner"},
+			&Membership{OrgID: 1, UserID: 2, Role: "member"})
+		m := &Membership{OrgID: 1, UserID: 2}
+		if err := db.Select(m); err != nil {
+			t.Fatal(err)
+		}
+		if m.Role != "member" {
+			t.Fatalf("expected member role; found %q", m.Role)
+		}
+	})
+
+	t.Run("Transactions", func(t *testing.T) {
+		db := NewMockDB()
+		err := db.RunInTransaction(func(tx Tx) error {
+			return tx.Insert(&Account{ID: 1})
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if found, _ := db.FindAny(&Account{ID: 1}); found == nil {
+			t.Fatal("expected the committed account")
+		}
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		db := NewMockDB()
+		if err := db.Insert(Account{ID: 1}); err == nil {
+			t.Fatal("expected an error inserting a non-pointer")
+		}
+		if err := db.Insert(&Note{}); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Select(&Note{}); err == nil {
+			t.Fatal("expected an error selecting a model without a primary key")
+		}
+		if err := db.Update(&Account{ID: 3}); err == nil {
+			t.Fatal("expected an error updating a missing model")
+		}
+	})
+}